@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os/signal"
 	"shortener/internal/config"
+	"shortener/internal/geoip"
 	"shortener/internal/handler"
 	"shortener/internal/router"
 	"shortener/internal/service"
@@ -27,8 +28,14 @@ func main() {
 		log.Fatal(err)
 	}
 
-	svc := service.NewShortenerService(dbConn)
-	handler := handler.NewShortenerHandler(svc)
+	geo, err := geoip.Open(cfg.GeoIPDBPath)
+	if err != nil {
+		log.Printf("geoip database not loaded, country rules disabled: %v", err)
+	}
+	defer geo.Close()
+
+	svc := service.NewShortenerService(dbConn, geo, cfg.DedupWindow)
+	handler := handler.NewShortenerHandler(svc, cfg.BaseURL)
 	router := router.NewRouter(handler)
 
 	srv := http.Server{
@@ -4,11 +4,29 @@ CREATE TABLE IF NOT EXISTS url(
     original_url TEXT NOT NULL);
 CREATE INDEX IF NOT EXISTS idx_alias ON url(alias);
 
+CREATE TABLE IF NOT EXISTS redirect_rules(
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    url_id UUID NOT NULL REFERENCES url(id) ON DELETE CASCADE,
+    rule_type TEXT NOT NULL CHECK (rule_type IN ('country', 'device', 'language', 'split')),
+    value TEXT NOT NULL DEFAULT '',
+    weight INT NOT NULL DEFAULT 0,
+    target_url TEXT NOT NULL,
+    priority INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT NOW());
+CREATE INDEX IF NOT EXISTS idx_redirect_rules_url_id ON redirect_rules(url_id);
+
 CREATE TABLE IF NOT EXISTS analytics(
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     url_id UUID NOT NULL REFERENCES url(id) ON DELETE CASCADE,
     user_Agent TEXT NOT NULL,
     ip_address TEXT,
-    time_transitions TIMESTAMP DEFAULT NOW());
+    time_transitions TIMESTAMP DEFAULT NOW(),
+    rule_id UUID REFERENCES redirect_rules(id) ON DELETE SET NULL,
+    source TEXT NOT NULL DEFAULT 'direct',
+    click_type TEXT NOT NULL DEFAULT 'human' CHECK (click_type IN ('human', 'bot', 'preview')),
+    visitor_id TEXT NOT NULL DEFAULT '',
+    duplicate BOOLEAN NOT NULL DEFAULT FALSE);
 
-CREATE INDEX IF NOT EXISTS idx_analytics_url_id ON analytics(url_id);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_analytics_url_id ON analytics(url_id);
+CREATE INDEX IF NOT EXISTS idx_analytics_rule_id ON analytics(rule_id);
+CREATE INDEX IF NOT EXISTS idx_analytics_visitor ON analytics(url_id, visitor_id, time_transitions);
\ No newline at end of file
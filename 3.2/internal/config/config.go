@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	e "shortener/internal/entity"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -14,6 +15,15 @@ func GetConfig() *e.Config {
 		log.Fatalf("Error loading .env file")
 	}
 
+	dedupWindow := 30 * time.Second
+	if v := os.Getenv("CLICK_DEDUP_WINDOW"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("invalid CLICK_DEDUP_WINDOW: %v", err)
+		}
+		dedupWindow = d
+	}
+
 	return &e.Config{
 		PostgresUser:     os.Getenv("POSTGRES_USER"),
 		PostgresPassword: os.Getenv("POSTGRES_PASSWORD"),
@@ -21,5 +31,8 @@ func GetConfig() *e.Config {
 		PostgresHost:     os.Getenv("POSTGRES_HOST"),
 		PostgresPort:     os.Getenv("POSTGRES_PORT"),
 		ServerPort:       os.Getenv("SERVER_PORT"),
+		GeoIPDBPath:      os.Getenv("GEOIP_DB_PATH"),
+		BaseURL:          os.Getenv("BASE_URL"),
+		DedupWindow:      dedupWindow,
 	}
 }
@@ -13,6 +13,9 @@ type Config struct {
 	PostgresHost     string
 	PostgresPort     string
 	ServerPort       string
+	GeoIPDBPath      string
+	BaseURL          string
+	DedupWindow      time.Duration
 }
 
 type Transition struct {
@@ -21,4 +24,62 @@ type Transition struct {
 	UserAgent       string
 	IPAddress       string
 	TimeTransitions time.Time
+	RuleID          *uuid.UUID
+	Source          string
+	ClickType       string
+	Duplicate       bool
+}
+
+type RuleType string
+
+const (
+	RuleCountry  RuleType = "country"
+	RuleDevice   RuleType = "device"
+	RuleLanguage RuleType = "language"
+	RuleSplit    RuleType = "split"
+)
+
+type Rule struct {
+	ID        uuid.UUID
+	URLID     uuid.UUID
+	Type      RuleType
+	Value     string
+	Weight    int
+	TargetURL string
+	Priority  int
+	CreatedAt time.Time
+}
+
+type RuleStats struct {
+	Rule        Rule
+	Clicks      int
+	HumanClicks int
+}
+
+type Visitor struct {
+	IP             string
+	UserAgent      string
+	AcceptLanguage string
+	Source         string
+	Method         string
+}
+
+const (
+	SourceDirect = "direct"
+	SourceQR     = "qr"
+)
+
+const (
+	ClickHuman   = "human"
+	ClickBot     = "bot"
+	ClickPreview = "preview"
+)
+
+type ClickStats struct {
+	Total      int
+	Human      int
+	Unique     int
+	Bot        int
+	Preview    int
+	Duplicates int
 }
@@ -0,0 +1,53 @@
+package geoip
+
+import (
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+type Resolver struct {
+	reader *geoip2.Reader
+}
+
+func Open(path string) (*Resolver, error) {
+	if path == "" {
+		return &Resolver{}, nil
+	}
+
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return &Resolver{}, err
+	}
+
+	return &Resolver{reader: reader}, nil
+}
+
+func (r *Resolver) Country(ip string) string {
+	if r == nil || r.reader == nil {
+		return ""
+	}
+
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+
+	record, err := r.reader.Country(parsed)
+	if err != nil {
+		return ""
+	}
+
+	return record.Country.IsoCode
+}
+
+func (r *Resolver) Close() error {
+	if r == nil || r.reader == nil {
+		return nil
+	}
+	return r.reader.Close()
+}
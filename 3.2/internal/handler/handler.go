@@ -2,19 +2,28 @@ package handler
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	e "shortener/internal/entity"
+	"shortener/internal/qr"
 	svc "shortener/internal/service"
+	"strconv"
+	"strings"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
 type ShortenerHandler struct {
-	svc svc.ShortenerService
+	svc     svc.ShortenerService
+	baseURL string
 }
 
-func NewShortenerHandler(svc svc.ShortenerService) *ShortenerHandler {
+func NewShortenerHandler(svc svc.ShortenerService, baseURL string) *ShortenerHandler {
 	return &ShortenerHandler{
-		svc: svc,
+		svc:     svc,
+		baseURL: strings.TrimRight(baseURL, "/"),
 	}
 }
 
@@ -41,13 +50,24 @@ func (h *ShortenerHandler) Redirect(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	shortUrl := vars["short_url"]
 
-	longURL, err := h.svc.Redirect(r.Context(), shortUrl)
+	visitor := e.Visitor{
+		IP:             r.RemoteAddr,
+		UserAgent:      r.UserAgent(),
+		AcceptLanguage: r.Header.Get("Accept-Language"),
+		Source:         e.SourceDirect,
+		Method:         r.Method,
+	}
+	if r.URL.Query().Get("src") == e.SourceQR {
+		visitor.Source = e.SourceQR
+	}
+
+	longURL, ruleID, err := h.svc.Redirect(r.Context(), shortUrl, visitor)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	h.svc.LogTransition(shortUrl, r.UserAgent(), r.RemoteAddr)
+	h.svc.LogTransition(shortUrl, visitor, ruleID)
 	http.Redirect(w, r, longURL, http.StatusFound)
 }
 
@@ -61,9 +81,34 @@ func (h *ShortenerHandler) Analytics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ruleStats, err := h.svc.GetRuleStats(r.Context(), shortUrl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	sourceStats, err := h.svc.GetSourceStats(r.Context(), shortUrl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	clickStats, err := h.svc.GetClickStats(r.Context(), shortUrl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
 	resp := map[string]interface{}{
-		"analytics": transitions,
-		"count":     len(transitions),
+		"analytics":     transitions,
+		"count":         clickStats.Total,
+		"human_count":   clickStats.Human,
+		"unique_humans": clickStats.Unique,
+		"bot_count":     clickStats.Bot,
+		"preview_count": clickStats.Preview,
+		"duplicates":    clickStats.Duplicates,
+		"rules":         ruleStats,
+		"sources":       sourceStats,
 	}
 
 	data, err := json.MarshalIndent(resp, "", "  ")
@@ -76,3 +121,146 @@ func (h *ShortenerHandler) Analytics(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 
 }
+
+func (h *ShortenerHandler) AddRule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortUrl := vars["short_url"]
+
+	var rule e.Rule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := h.svc.AddRule(r.Context(), shortUrl, rule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func (h *ShortenerHandler) GetRules(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortUrl := vars["short_url"]
+
+	rules, err := h.svc.GetRules(r.Context(), shortUrl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rules": rules,
+	})
+}
+
+func (h *ShortenerHandler) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortUrl := vars["short_url"]
+
+	ruleID, err := uuid.Parse(vars["rule_id"])
+	if err != nil {
+		http.Error(w, "invalid rule id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.DeleteRule(r.Context(), shortUrl, ruleID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ShortenerHandler) QRCode(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortUrl := vars["short_url"]
+
+	opts, err := parseQROptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	exists, err := h.svc.Exists(r.Context(), shortUrl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if !exists {
+		http.Error(w, "url not found", http.StatusNotFound)
+		return
+	}
+
+	link := fmt.Sprintf("%s/s/%s?src=%s", h.publicBaseURL(r), url.PathEscape(shortUrl), e.SourceQR)
+	data, err := qr.Generate(link, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", opts.ContentType())
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(data)
+}
+
+func (h *ShortenerHandler) publicBaseURL(r *http.Request) string {
+	if h.baseURL != "" {
+		return h.baseURL
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+func parseQROptions(q url.Values) (qr.Options, error) {
+	opts := qr.DefaultOptions()
+
+	if v := q.Get("format"); v != "" {
+		opts.Format = strings.ToLower(v)
+	}
+	if v := q.Get("level"); v != "" {
+		opts.Level = strings.ToUpper(v)
+	}
+	if v := q.Get("size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid size")
+		}
+		opts.Size = size
+	}
+	if v := q.Get("margin"); v != "" {
+		margin, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid margin")
+		}
+		opts.Margin = margin
+	}
+	if v := q.Get("fg"); v != "" {
+		c, err := qr.ParseColor(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.Foreground = c
+	}
+	if v := q.Get("bg"); v != "" {
+		c, err := qr.ParseColor(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.Background = c
+	}
+
+	return opts, opts.Validate()
+}
@@ -0,0 +1,169 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+
+	MinSize = 64
+	MaxSize = 2048
+)
+
+type Options struct {
+	Format     string
+	Size       int
+	Level      string
+	Margin     int
+	Foreground color.RGBA
+	Background color.RGBA
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Format:     FormatPNG,
+		Size:       256,
+		Level:      "M",
+		Margin:     4,
+		Foreground: color.RGBA{0, 0, 0, 255},
+		Background: color.RGBA{255, 255, 255, 255},
+	}
+}
+
+func (o Options) Validate() error {
+	if o.Format != FormatPNG && o.Format != FormatSVG {
+		return fmt.Errorf("unsupported format %q", o.Format)
+	}
+	if o.Size < MinSize || o.Size > MaxSize {
+		return fmt.Errorf("size must be between %d and %d", MinSize, MaxSize)
+	}
+	if o.Margin < 0 || o.Margin > 16 {
+		return fmt.Errorf("margin must be between 0 and 16")
+	}
+	if _, err := recoveryLevel(o.Level); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (o Options) ContentType() string {
+	if o.Format == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// ParseColor accepts hex colours in RRGGBB or RGB form, with or without "#".
+func ParseColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
+
+func Generate(content string, o Options) ([]byte, error) {
+	level, err := recoveryLevel(o.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := qrcode.New(content, level)
+	if err != nil {
+		return nil, err
+	}
+	code.DisableBorder = true
+	modules := code.Bitmap()
+
+	if o.Format == FormatSVG {
+		return renderSVG(modules, o), nil
+	}
+	return renderPNG(modules, o)
+}
+
+func recoveryLevel(level string) (qrcode.RecoveryLevel, error) {
+	switch strings.ToUpper(level) {
+	case "L":
+		return qrcode.Low, nil
+	case "M":
+		return qrcode.Medium, nil
+	case "Q":
+		return qrcode.High, nil
+	case "H":
+		return qrcode.Highest, nil
+	default:
+		return 0, fmt.Errorf("unknown error-correction level %q", level)
+	}
+}
+
+func renderPNG(modules [][]bool, o Options) ([]byte, error) {
+	n := len(modules)
+	module := o.Size / (n + 2*o.Margin)
+	if module == 0 {
+		return nil, fmt.Errorf("size %d is too small for this code", o.Size)
+	}
+	// Leftover pixels are split evenly so the code stays centered and every
+	// module has the same integer width.
+	offset := (o.Size - module*n) / 2
+
+	img := image.NewPaletted(image.Rect(0, 0, o.Size, o.Size), color.Palette{o.Background, o.Foreground})
+	for y, row := range modules {
+		for x, set := range row {
+			if !set {
+				continue
+			}
+			for dy := 0; dy < module; dy++ {
+				for dx := 0; dx < module; dx++ {
+					img.SetColorIndex(offset+x*module+dx, offset+y*module+dy, 1)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func renderSVG(modules [][]bool, o Options) []byte {
+	total := len(modules) + 2*o.Margin
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		o.Size, o.Size, total, total)
+	fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="%s"/>`, hex(o.Background))
+	fmt.Fprintf(&buf, `<path fill="%s" d="`, hex(o.Foreground))
+	for y, row := range modules {
+		for x, set := range row {
+			if set {
+				fmt.Fprintf(&buf, "M%d %dh1v1h-1z", x+o.Margin, y+o.Margin)
+			}
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes()
+}
+
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,70 @@
+package qr
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    color.RGBA
+		wantErr bool
+	}{
+		{in: "#000000", want: color.RGBA{0, 0, 0, 255}},
+		{in: "#ff8000", want: color.RGBA{255, 128, 0, 255}},
+		{in: "FF8000", want: color.RGBA{255, 128, 0, 255}},
+		{in: "#f80", want: color.RGBA{255, 136, 0, 255}},
+		{in: "abc", want: color.RGBA{170, 187, 204, 255}},
+		{in: "", wantErr: true},
+		{in: "#", wantErr: true},
+		{in: "#ff80", wantErr: true},
+		{in: "#ff80000", wantErr: true},
+		{in: "#gg0000", wantErr: true},
+		{in: "#+12345", wantErr: true},
+		{in: "red", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseColor(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseColor(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseColor(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestValidateSize(t *testing.T) {
+	tests := []struct {
+		size    int
+		wantErr bool
+	}{
+		{size: MinSize - 1, wantErr: true},
+		{size: MinSize},
+		{size: 256},
+		{size: MaxSize},
+		{size: MaxSize + 1, wantErr: true},
+		{size: 0, wantErr: true},
+		{size: -256, wantErr: true},
+	}
+	for _, tt := range tests {
+		o := DefaultOptions()
+		o.Size = tt.size
+		if err := o.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate() with size %d error = %v, wantErr %v", tt.size, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGenerateTooSmall(t *testing.T) {
+	o := DefaultOptions()
+	o.Size = MinSize
+	o.Margin = 16
+	_, err := Generate("https://example.com/"+strings.Repeat("x", 200), o)
+	if err == nil {
+		t.Error("Generate() of a large code at the minimum size succeeded, want error")
+	}
+}
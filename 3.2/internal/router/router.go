@@ -11,7 +11,11 @@ import (
 /*
 – POST /shorten — создание новой сокращённой ссылки;
 – GET /s/{short_url} — переход по короткой ссылке;
-– GET /analytics/{short_url} — получение аналитики (число переходов, User-Agent, время переходов).
+– GET /s/{short_url}/qr — QR-код короткой ссылки в PNG или SVG;
+– GET /analytics/{short_url} — получение аналитики (число переходов, User-Agent, время переходов);
+– POST /rules/{short_url} — добавление правила перенаправления (страна, устройство, язык, A/B-сплит);
+– GET /rules/{short_url} — список правил перенаправления;
+– DELETE /rules/{short_url}/{rule_id} — удаление правила.
 */
 
 func NewRouter(h *handler.ShortenerHandler) *mux.Router {
@@ -19,8 +23,12 @@ func NewRouter(h *handler.ShortenerHandler) *mux.Router {
 	r.Use(middleware.Logger)
 
 	r.HandleFunc("/shorten", h.NewShorten).Methods("POST")
-	r.HandleFunc("/s/{short_url}", h.Redirect).Methods("GET")
+	r.HandleFunc("/s/{short_url}", h.Redirect).Methods("GET", "HEAD")
+	r.HandleFunc("/s/{short_url}/qr", h.QRCode).Methods("GET")
 	r.HandleFunc("/analytics/{short_url}", h.Analytics).Methods("GET")
+	r.HandleFunc("/rules/{short_url}", h.AddRule).Methods("POST")
+	r.HandleFunc("/rules/{short_url}", h.GetRules).Methods("GET")
+	r.HandleFunc("/rules/{short_url}/{rule_id}", h.DeleteRule).Methods("DELETE")
 
 	return r
 }
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+
+	e "shortener/internal/entity"
+)
+
+// Link unfurlers of messengers and social networks. They fetch the page once
+// to build a preview card, so the click is not made by a person.
+var previewSignatures = []string{
+	"facebookexternalhit",
+	"facebookcatalog",
+	"twitterbot",
+	"slackbot",
+	"slack-imgproxy",
+	"telegrambot",
+	"whatsapp",
+	"discordbot",
+	"linkedinbot",
+	"skypeuripreview",
+	"vkshare",
+	"viber",
+	"redditbot",
+	"pinterestbot",
+	"pinterest/0.",
+	"embedly",
+	"iframely",
+	"applebot",
+	"google-pagerenderer",
+	"mattermost",
+	"microsoft teams",
+}
+
+// Crawlers name themselves "SomeBot/1.0" or "SomeBot-Variant", and most
+// link a contact page as "+http...". A bare "bot" substring would also
+// match phone models such as Cubot.
+var botSignatures = []string{
+	"bot/",
+	"bot-",
+	"+http",
+	"crawler",
+	"spider",
+	"crawl",
+	"slurp",
+	"headlesschrome",
+	"phantomjs",
+	"curl/",
+	"wget/",
+	"httpie/",
+	"python-requests",
+	"python-urllib",
+	"aiohttp",
+	"go-http-client",
+	"okhttp",
+	"java/",
+	"libwww-perl",
+	"axios/",
+	"node-fetch",
+	"postmanruntime",
+	"scrapy",
+	"lighthouse",
+	"uptime",
+	"monitor",
+}
+
+func classifyClick(v e.Visitor) string {
+	ua := strings.ToLower(strings.TrimSpace(v.UserAgent))
+
+	for _, sig := range previewSignatures {
+		if strings.Contains(ua, sig) {
+			return e.ClickPreview
+		}
+	}
+	if v.Method == http.MethodHead {
+		return e.ClickPreview
+	}
+
+	if ua == "" {
+		return e.ClickBot
+	}
+	for _, sig := range botSignatures {
+		if strings.Contains(ua, sig) {
+			return e.ClickBot
+		}
+	}
+	for _, token := range strings.FieldsFunc(ua, isTokenSeparator) {
+		if token == "bot" {
+			return e.ClickBot
+		}
+	}
+
+	return e.ClickHuman
+}
+
+func isTokenSeparator(r rune) bool {
+	return r == ' ' || r == ';' || r == '(' || r == ')' || r == ','
+}
+
+// visitorID identifies a visitor by IP and User-Agent without storing them
+// in a joinable form. The port is dropped since it changes per connection.
+func visitorID(v e.Visitor) string {
+	ip := v.IP
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	sum := sha256.Sum256([]byte(ip + "|" + v.UserAgent))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"net/http"
+	e "shortener/internal/entity"
+	"testing"
+)
+
+func TestClassifyClick(t *testing.T) {
+	tests := []struct {
+		name   string
+		ua     string
+		method string
+		want   string
+	}{
+		{"chrome", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36", "", e.ClickHuman},
+		{"cubot phone", "Mozilla/5.0 (Linux; Android 10; CUBOT X30) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Mobile Safari/537.36", "", e.ClickHuman},
+		{"cubot model with underscore", "Mozilla/5.0 (Linux; Android 9; CUBOT_P30 Build/PPR1) Mobile Safari/537.36", "", e.ClickHuman},
+		{"pinterest in-app browser", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 [Pinterest/iOS]", "", e.ClickHuman},
+		{"pinterest android app", "Mozilla/5.0 (Linux; Android 14) Chrome/120.0 Mobile Safari/537.36 Pinterest for Android/12.1", "", e.ClickHuman},
+		{"googlebot", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "", e.ClickBot},
+		{"adsbot", "AdsBot-Google (+http://www.google.com/adsbot.html)", "", e.ClickBot},
+		{"duckduckbot", "DuckDuckBot-Https/1.1; (+https://duckduckgo.com/duckduckbot)", "", e.ClickBot},
+		{"bare bot token", "Mozilla/5.0 (compatible; bot)", "", e.ClickBot},
+		{"baidu spider", "Mozilla/5.0 (compatible; Baiduspider/2.0)", "", e.ClickBot},
+		{"curl", "curl/8.7.1", "", e.ClickBot},
+		{"empty", "", "", e.ClickBot},
+		{"pinterest crawler", "Pinterestbot/1.0 (+http://www.pinterest.com/bot.html)", "", e.ClickPreview},
+		{"old pinterest crawler", "Pinterest/0.2 (+http://www.pinterest.com/bot.html)", "", e.ClickPreview},
+		{"telegram", "TelegramBot (like TwitterBot)", "", e.ClickPreview},
+		{"facebook", "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", "", e.ClickPreview},
+		{"head request", "Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0", http.MethodHead, e.ClickPreview},
+	}
+	for _, tt := range tests {
+		v := e.Visitor{UserAgent: tt.ua, Method: tt.method}
+		if got := classifyClick(v); got != tt.want {
+			t.Errorf("%s: classifyClick = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	e "shortener/internal/entity"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+)
+
+func (s *ShortenerService) AddRule(ctx context.Context, shortUrl string, rule e.Rule) (e.Rule, error) {
+	if err := validateRule(rule); err != nil {
+		return e.Rule{}, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return e.Rule{}, err
+	}
+	defer tx.Rollback()
+
+	// Locking the url row serialises rule changes per link, so concurrent
+	// splits cannot both pass the weight check.
+	var urlID uuid.UUID
+	err = tx.QueryRowContext(ctx, "SELECT id FROM url WHERE alias = $1 FOR UPDATE", shortUrl).Scan(&urlID)
+	if err != nil {
+		return e.Rule{}, fmt.Errorf("url not found")
+	}
+
+	if rule.Type == e.RuleSplit {
+		var total int
+		err := tx.QueryRowContext(ctx, `
+            SELECT COALESCE(SUM(weight), 0)
+            FROM redirect_rules
+            WHERE url_id = $1 AND rule_type = $2
+        `, urlID, e.RuleSplit).Scan(&total)
+		if err != nil {
+			return e.Rule{}, err
+		}
+		if total+rule.Weight > 100 {
+			return e.Rule{}, fmt.Errorf("split weights exceed 100%%: %d already allocated", total)
+		}
+	}
+
+	rule.URLID = urlID
+	err = tx.QueryRowContext(ctx, `
+        INSERT INTO redirect_rules (url_id, rule_type, value, weight, target_url, priority)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at
+    `, urlID, rule.Type, rule.Value, rule.Weight, rule.TargetURL, rule.Priority).Scan(&rule.ID, &rule.CreatedAt)
+	if err != nil {
+		return e.Rule{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return e.Rule{}, err
+	}
+	return rule, nil
+}
+
+func (s *ShortenerService) GetRules(ctx context.Context, shortUrl string) ([]e.Rule, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT r.id, r.url_id, r.rule_type, r.value, r.weight, r.target_url, r.priority, r.created_at
+        FROM redirect_rules r
+        JOIN url u ON u.id = r.url_id
+        WHERE u.alias = $1
+        ORDER BY r.priority, r.created_at
+    `, shortUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []e.Rule
+	for rows.Next() {
+		var r e.Rule
+		if err := rows.Scan(&r.ID, &r.URLID, &r.Type, &r.Value, &r.Weight, &r.TargetURL, &r.Priority, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+func (s *ShortenerService) DeleteRule(ctx context.Context, shortUrl string, ruleID uuid.UUID) error {
+	res, err := s.db.ExecContext(ctx, `
+        DELETE FROM redirect_rules
+        WHERE id = $1 AND url_id = (SELECT id FROM url WHERE alias = $2)
+    `, ruleID, shortUrl)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("rule not found")
+	}
+	return nil
+}
+
+func (s *ShortenerService) GetRuleStats(ctx context.Context, shortUrl string) ([]e.RuleStats, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT r.id, r.url_id, r.rule_type, r.value, r.weight, r.target_url, r.priority, r.created_at,
+               COUNT(a.id),
+               COUNT(a.id) FILTER (WHERE a.click_type = 'human' AND NOT a.duplicate)
+        FROM redirect_rules r
+        JOIN url u ON u.id = r.url_id
+        LEFT JOIN analytics a ON a.rule_id = r.id
+        WHERE u.alias = $1
+        GROUP BY r.id
+        ORDER BY r.priority, r.created_at
+    `, shortUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []e.RuleStats
+	for rows.Next() {
+		var st e.RuleStats
+		r := &st.Rule
+		if err := rows.Scan(&r.ID, &r.URLID, &r.Type, &r.Value, &r.Weight, &r.TargetURL, &r.Priority, &r.CreatedAt, &st.Clicks, &st.HumanClicks); err != nil {
+			return nil, err
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
+
+// matchRule picks the rule to apply for the visitor. Conditional rules are
+// checked in priority order and the first match wins; otherwise the visitor
+// falls into one of the split buckets by roll, 0..99, or gets nil for the
+// original URL. country resolves the visitor IP and is only called if a
+// country rule is reached.
+func matchRule(rules []e.Rule, v e.Visitor, country func(ip string) string, roll int) *e.Rule {
+	var visitorCountry, device, language string
+	var splits []e.Rule
+
+	for i := range rules {
+		r := &rules[i]
+		switch r.Type {
+		case e.RuleCountry:
+			if visitorCountry == "" {
+				visitorCountry = country(v.IP)
+			}
+			if containsValue(r.Value, visitorCountry) {
+				return r
+			}
+		case e.RuleDevice:
+			if device == "" {
+				device = detectDevice(v.UserAgent)
+			}
+			if containsValue(r.Value, device) {
+				return r
+			}
+		case e.RuleLanguage:
+			if language == "" {
+				language = preferredLanguage(v.AcceptLanguage)
+			}
+			if containsValue(r.Value, language) {
+				return r
+			}
+		case e.RuleSplit:
+			splits = append(splits, *r)
+		}
+	}
+
+	return splitBucket(splits, roll)
+}
+
+// splitBucket returns the split whose share of 0..99 contains roll, or nil
+// if roll falls into the unallocated remainder.
+func splitBucket(splits []e.Rule, roll int) *e.Rule {
+	for i := range splits {
+		if roll < splits[i].Weight {
+			return &splits[i]
+		}
+		roll -= splits[i].Weight
+	}
+	return nil
+}
+
+func validateRule(r e.Rule) error {
+	u, err := url.ParseRequestURI(r.TargetURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid target url")
+	}
+
+	switch r.Type {
+	case e.RuleCountry, e.RuleLanguage:
+		if strings.TrimSpace(r.Value) == "" {
+			return fmt.Errorf("rule value is required")
+		}
+	case e.RuleDevice:
+		for _, d := range strings.Split(r.Value, ",") {
+			switch strings.ToLower(strings.TrimSpace(d)) {
+			case DeviceMobile, DeviceTablet, DeviceDesktop:
+			default:
+				return fmt.Errorf("unknown device type %q", d)
+			}
+		}
+	case e.RuleSplit:
+		if r.Weight <= 0 || r.Weight > 100 {
+			return fmt.Errorf("split weight must be between 1 and 100")
+		}
+	default:
+		return fmt.Errorf("unknown rule type %q", r.Type)
+	}
+	return nil
+}
+
+func containsValue(list, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, item := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(item), value) {
+			return true
+		}
+	}
+	return false
+}
+
+func detectDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "ipad"), strings.Contains(ua, "tablet"),
+		strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		return DeviceTablet
+	case strings.Contains(ua, "mobi"), strings.Contains(ua, "iphone"), strings.Contains(ua, "ipod"):
+		return DeviceMobile
+	default:
+		return DeviceDesktop
+	}
+}
+
+// preferredLanguage returns the primary subtag of the highest-weighted
+// language in an Accept-Language header, e.g. "ru" for "ru-RU,en;q=0.8".
+func preferredLanguage(header string) string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+
+	var langs []lang
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if v, ok := strings.CutPrefix(param, "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		primary, _, _ := strings.Cut(tag, "-")
+		langs = append(langs, lang{tag: strings.ToLower(primary), q: q})
+	}
+
+	if len(langs) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	return langs[0].tag
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	e "shortener/internal/entity"
+	"testing"
+)
+
+func TestDetectDevice(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want string
+	}{
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148", DeviceMobile},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile Safari/537.36", DeviceMobile},
+		{"Mozilla/5.0 (Linux; Android 13; SM-X710) Safari/537.36", DeviceTablet},
+		{"Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X)", DeviceTablet},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/126.0", DeviceDesktop},
+		{"", DeviceDesktop},
+	}
+	for _, tt := range tests {
+		if got := detectDevice(tt.ua); got != tt.want {
+			t.Errorf("detectDevice(%q) = %q, want %q", tt.ua, got, tt.want)
+		}
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"ru-RU,en;q=0.8", "ru"},
+		{"en;q=0.5, de-DE;q=0.9", "de"},
+		{"fr;q=0, es", "es"},
+		{"*, it;q=0.1", "it"},
+		{"EN-us", "en"},
+		{"", ""},
+		{"*", ""},
+	}
+	for _, tt := range tests {
+		if got := preferredLanguage(tt.header); got != tt.want {
+			t.Errorf("preferredLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestMatchRule(t *testing.T) {
+	rules := []e.Rule{
+		{Type: e.RuleCountry, Value: "DE, AT", TargetURL: "https://de.example.com"},
+		{Type: e.RuleDevice, Value: "mobile", TargetURL: "https://m.example.com"},
+		{Type: e.RuleLanguage, Value: "ru", TargetURL: "https://ru.example.com"},
+		{Type: e.RuleSplit, Weight: 30, TargetURL: "https://a.example.com"},
+		{Type: e.RuleSplit, Weight: 50, TargetURL: "https://b.example.com"},
+	}
+	countries := map[string]string{"1.1.1.1": "AT", "2.2.2.2": "US"}
+	country := func(ip string) string { return countries[ip] }
+
+	tests := []struct {
+		name string
+		v    e.Visitor
+		roll int
+		want string
+	}{
+		{"country", e.Visitor{IP: "1.1.1.1", UserAgent: "iPhone"}, 0, "https://de.example.com"},
+		{"device", e.Visitor{IP: "2.2.2.2", UserAgent: "iPhone"}, 0, "https://m.example.com"},
+		{"language", e.Visitor{IP: "2.2.2.2", AcceptLanguage: "ru-RU"}, 0, "https://ru.example.com"},
+		{"first split", e.Visitor{IP: "2.2.2.2"}, 29, "https://a.example.com"},
+		{"second split", e.Visitor{IP: "2.2.2.2"}, 30, "https://b.example.com"},
+		{"last of second split", e.Visitor{IP: "2.2.2.2"}, 79, "https://b.example.com"},
+		{"unallocated", e.Visitor{IP: "2.2.2.2"}, 80, ""},
+		{"unknown country", e.Visitor{IP: "9.9.9.9"}, 99, ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if r := matchRule(rules, tt.v, country, tt.roll); r != nil {
+			got = r.TargetURL
+		}
+		if got != tt.want {
+			t.Errorf("%s: matchRule = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchRuleWithoutRules(t *testing.T) {
+	country := func(string) string {
+		t.Fatal("country looked up without country rules")
+		return ""
+	}
+	if r := matchRule(nil, e.Visitor{IP: "1.1.1.1"}, country, 0); r != nil {
+		t.Errorf("matchRule(nil) = %+v, want nil", r)
+	}
+}
+
+func TestSplitBucket(t *testing.T) {
+	splits := []e.Rule{{Weight: 50}, {Weight: 25}, {Weight: 25}}
+	tests := []struct {
+		roll int
+		want int
+	}{
+		{0, 0}, {49, 0}, {50, 1}, {74, 1}, {75, 2}, {99, 2},
+	}
+	for _, tt := range tests {
+		got := splitBucket(splits, tt.roll)
+		if got != &splits[tt.want] {
+			t.Errorf("splitBucket(%d) = %v, want split %d", tt.roll, got, tt.want)
+		}
+	}
+}
@@ -8,15 +8,23 @@ import (
 	"math/rand/v2"
 	"net/url"
 	e "shortener/internal/entity"
+	"shortener/internal/geoip"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 type ShortenerService struct {
-	db *sql.DB
+	db          *sql.DB
+	geo         *geoip.Resolver
+	dedupWindow time.Duration
 }
 
-func NewShortenerService(db *sql.DB) ShortenerService {
+func NewShortenerService(db *sql.DB, geo *geoip.Resolver, dedupWindow time.Duration) ShortenerService {
 	return ShortenerService{
-		db: db,
+		db:          db,
+		geo:         geo,
+		dedupWindow: dedupWindow,
 	}
 }
 
@@ -36,32 +44,48 @@ func (s *ShortenerService) NewShorten(ctx context.Context, baseUrl string) (stri
 	return alias, nil
 }
 
-func (s *ShortenerService) Redirect(ctx context.Context, shortUrl string) (string, error) {
+func (s *ShortenerService) Redirect(ctx context.Context, shortUrl string, v e.Visitor) (string, *uuid.UUID, error) {
 	var originalURL string
 
 	err := s.db.QueryRow("SELECT original_url FROM url WHERE alias = $1", shortUrl).Scan(&originalURL)
 	if err == sql.ErrNoRows {
 		fmt.Println("url not found")
-		return "", fmt.Errorf("url not found")
+		return "", nil, fmt.Errorf("url not found")
 	} else if err != nil {
-		log.Fatal(err)
-		return "", err
+		log.Println(err)
+		return "", nil, err
+	}
+
+	rules, err := s.GetRules(ctx, shortUrl)
+	if err != nil {
+		log.Printf("load redirect rules for %s: %v", shortUrl, err)
+		return originalURL, nil, nil
+	}
+
+	if rule := matchRule(rules, v, s.geo.Country, rand.IntN(100)); rule != nil {
+		return rule.TargetURL, &rule.ID, nil
 	}
 
-	return originalURL, nil
+	return originalURL, nil, nil
 }
 
-func (s *ShortenerService) LogTransition(shortUrl string, userAgent string, ip string) error {
+func (s *ShortenerService) LogTransition(shortUrl string, v e.Visitor, ruleID *uuid.UUID) error {
 	var urlID string
 	err := s.db.QueryRow("SELECT id FROM url WHERE alias=$1", shortUrl).Scan(&urlID)
 	if err != nil {
 		return err
 	}
 
+	// A click is a duplicate if the same visitor already followed the link
+	// within the dedup window; it is still stored so totals stay accurate.
 	_, err = s.db.Exec(`
-        INSERT INTO analytics (url_id, user_agent, ip_address)
-        VALUES ($1, $2, $3)
-    `, urlID, userAgent, ip)
+        INSERT INTO analytics (url_id, user_agent, ip_address, rule_id, source, click_type, visitor_id, duplicate)
+        SELECT $1, $2, $3, $4, $5, $6, $7, EXISTS(
+            SELECT 1 FROM analytics
+            WHERE url_id = $1 AND visitor_id = $7
+              AND time_transitions > NOW() - make_interval(secs => $8)
+        )
+    `, urlID, v.UserAgent, v.IP, ruleID, v.Source, classifyClick(v), visitorID(v), s.dedupWindow.Seconds())
 
 	return err
 }
@@ -69,7 +93,7 @@ func (s *ShortenerService) LogTransition(shortUrl string, userAgent string, ip s
 func (s *ShortenerService) GetAnalyticsData(ctx context.Context, shortUrl string) ([]e.Transition, error) {
 	var transitions []e.Transition
 	rows, err := s.db.Query(`
-        SELECT *
+        SELECT id, url_id, user_agent, ip_address, time_transitions, rule_id, source, click_type, duplicate
         FROM analytics
         WHERE url_id = (SELECT id FROM url WHERE alias=$1)
         ORDER BY time_transitions DESC
@@ -82,7 +106,7 @@ func (s *ShortenerService) GetAnalyticsData(ctx context.Context, shortUrl string
 
 	for rows.Next() {
 		var t e.Transition
-		if err := rows.Scan(&t.ID, &t.URLID, &t.UserAgent, &t.IPAddress, &t.TimeTransitions); err != nil {
+		if err := rows.Scan(&t.ID, &t.URLID, &t.UserAgent, &t.IPAddress, &t.TimeTransitions, &t.RuleID, &t.Source, &t.ClickType, &t.Duplicate); err != nil {
 			return nil, err
 		}
 		transitions = append(transitions, t)
@@ -90,6 +114,51 @@ func (s *ShortenerService) GetAnalyticsData(ctx context.Context, shortUrl string
 	return transitions, nil
 }
 
+func (s *ShortenerService) GetClickStats(ctx context.Context, shortUrl string) (e.ClickStats, error) {
+	var st e.ClickStats
+	err := s.db.QueryRowContext(ctx, `
+        SELECT COUNT(*),
+               COUNT(*) FILTER (WHERE click_type = 'human'),
+               COUNT(*) FILTER (WHERE click_type = 'human' AND NOT duplicate),
+               COUNT(*) FILTER (WHERE click_type = 'bot'),
+               COUNT(*) FILTER (WHERE click_type = 'preview'),
+               COUNT(*) FILTER (WHERE duplicate)
+        FROM analytics
+        WHERE url_id = (SELECT id FROM url WHERE alias=$1)
+    `, shortUrl).Scan(&st.Total, &st.Human, &st.Unique, &st.Bot, &st.Preview, &st.Duplicates)
+	return st, err
+}
+
+func (s *ShortenerService) Exists(ctx context.Context, shortUrl string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM url WHERE alias = $1)", shortUrl).Scan(&exists)
+	return exists, err
+}
+
+func (s *ShortenerService) GetSourceStats(ctx context.Context, shortUrl string) (map[string]int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT source, COUNT(*)
+        FROM analytics
+        WHERE url_id = (SELECT id FROM url WHERE alias=$1)
+        GROUP BY source
+    `, shortUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]int)
+	for rows.Next() {
+		var source string
+		var count int
+		if err := rows.Scan(&source, &count); err != nil {
+			return nil, err
+		}
+		stats[source] = count
+	}
+	return stats, rows.Err()
+}
+
 func generateShortUrl() string {
 	n := rand.IntN(10) + 4
 	letters := []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"commentTree/internal/auth"
 	"commentTree/internal/config"
+	"commentTree/internal/events"
+	"commentTree/internal/filter"
 	"commentTree/internal/handler"
+	"commentTree/internal/notify"
 	"commentTree/internal/postgresql"
 	"commentTree/internal/router"
 	"commentTree/internal/service"
@@ -27,9 +31,41 @@ func main() {
 		log.Fatal(err)
 	}
 
-	svc := service.NewCommentsService(dbConn)
-	handler := handler.NewCommentsHandler(svc)
-	router := router.NewRouter(handler)
+	tp := auth.NewTokenProvider([]byte(cfg.JWTSecret))
+	filters := filter.FromConfig(cfg.Filter, service.NewCommentHistory(dbConn))
+	repo := service.NewPostgresRepository(dbConn, cfg.MaxDepth)
+	svc := service.NewCommentsService(repo, filters)
+	users := service.NewUsersService(dbConn, tp)
+	if err := users.EnsureModerator(ctx, cfg.ModeratorName, cfg.ModeratorPass); err != nil {
+		log.Printf("create moderator account: %v", err)
+	}
+
+	broker := events.NewBroker(svc.GetComment)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := broker.Listen(ctx, postgresql.DSN(cfg)); err != nil {
+			log.Printf("comment events: %v", err)
+		}
+	}()
+
+	sender, err := notify.FromConfig(cfg.Notify)
+	if err != nil {
+		log.Fatal(err)
+	}
+	notifications := service.NewNotificationsService(dbConn, sender, []byte(cfg.JWTSecret), cfg.Notify)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		notifications.Run(ctx)
+	}()
+
+	commentsHandler := handler.NewCommentsHandler(svc)
+	authHandler := handler.NewAuthHandler(users)
+	moderationHandler := handler.NewModerationHandler(svc, users)
+	eventsHandler := handler.NewEventsHandler(svc, broker)
+	notificationsHandler := handler.NewNotificationsHandler(notifications)
+	router := router.NewRouter(commentsHandler, authHandler, moderationHandler, eventsHandler, notificationsHandler, tp)
 
 	srv := http.Server{
 		Addr:    ":8080",
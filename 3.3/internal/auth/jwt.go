@@ -0,0 +1,72 @@
+package auth
+
+import (
+	e "commentTree/internal/entity"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// MinSecretLength is the shortest JWT_SECRET accepted. HS256 signs with an
+// empty key just as well, which would let anyone forge moderator tokens.
+const MinSecretLength = 32
+
+type TokenProvider struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewTokenProvider(secret []byte) *TokenProvider {
+	return &TokenProvider{
+		secret: secret,
+		ttl:    24 * time.Hour,
+	}
+}
+
+func (p *TokenProvider) Generate(u *e.User) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id":  u.UserID.String(),
+		"username": u.UserName,
+		"role":     string(u.Role),
+		"exp":      time.Now().Add(p.ttl).Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(p.secret)
+}
+
+func (p *TokenProvider) Parse(tokenStr string) (*e.User, error) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		return p.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid claims")
+	}
+
+	idStr, _ := claims["user_id"].(string)
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return nil, fmt.Errorf("not claim user_id")
+	}
+	username, ok := claims["username"].(string)
+	if !ok {
+		return nil, fmt.Errorf("not claim username")
+	}
+	role, ok := claims["role"].(string)
+	if !ok {
+		return nil, fmt.Errorf("not claim role")
+	}
+
+	return &e.User{
+		UserID:   id,
+		UserName: username,
+		Role:     e.Role(role),
+	}, nil
+}
@@ -1,9 +1,13 @@
 package config
 
 import (
+	"commentTree/internal/auth"
 	e "commentTree/internal/entity"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -14,12 +18,81 @@ func GetConfig() *e.Config {
 		log.Fatalf("Error loading .env file")
 	}
 
-	return &e.Config{
+	cfg := &e.Config{
 		PostgresUser:     os.Getenv("POSTGRES_USER"),
 		PostgresPassword: os.Getenv("POSTGRES_PASSWORD"),
 		PostgresDB:       os.Getenv("POSTGRES_DB"),
 		PostgresHost:     os.Getenv("POSTGRES_HOST"),
 		PostgresPort:     os.Getenv("POSTGRES_PORT"),
 		ServerPort:       os.Getenv("SERVER_PORT"),
+		JWTSecret:        os.Getenv("JWT_SECRET"),
+		ModeratorName:    os.Getenv("MODERATOR_NAME"),
+		ModeratorPass:    os.Getenv("MODERATOR_PASSWORD"),
+		MaxDepth:         envInt("COMMENT_MAX_DEPTH", 50),
+		Filter: e.FilterConfig{
+			BannedWords:       splitList(os.Getenv("FILTER_BANNED_WORDS")),
+			BannedWordsAction: os.Getenv("FILTER_BANNED_WORDS_ACTION"),
+			MaxLinks:          envInt("FILTER_MAX_LINKS", 2),
+			LinksAction:       os.Getenv("FILTER_LINKS_ACTION"),
+			MinLength:         envInt("FILTER_MIN_LENGTH", 1),
+			MaxLength:         envInt("FILTER_MAX_LENGTH", 5000),
+			FloodLimit:        envInt("FILTER_FLOOD_LIMIT", 5),
+			FloodWindow:       envDuration("FILTER_FLOOD_WINDOW", time.Minute),
+			DuplicateWindow:   envDuration("FILTER_DUPLICATE_WINDOW", 10*time.Minute),
+		},
+		Notify: e.NotifyConfig{
+			Sender:       os.Getenv("NOTIFY_SENDER"),
+			BaseURL:      strings.TrimRight(os.Getenv("BASE_URL"), "/"),
+			Interval:     envDuration("NOTIFY_INTERVAL", 10*time.Second),
+			MaxAttempts:  envInt("NOTIFY_MAX_ATTEMPTS", 5),
+			WebhookURL:   os.Getenv("NOTIFY_WEBHOOK_URL"),
+			SMTPAddr:     os.Getenv("SMTP_ADDR"),
+			SMTPUser:     os.Getenv("SMTP_USER"),
+			SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+			SMTPFrom:     os.Getenv("SMTP_FROM"),
+		},
 	}
+
+	// The secret signs both session tokens and unsubscribe links.
+	if len(cfg.JWTSecret) < auth.MinSecretLength {
+		log.Fatalf("JWT_SECRET must be at least %d characters", auth.MinSecretLength)
+	}
+	if cfg.ModeratorName != "" && cfg.ModeratorPass == "" {
+		log.Fatalf("MODERATOR_PASSWORD is not set")
+	}
+	return cfg
+}
+
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+	return n
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+	return d
+}
+
+func splitList(v string) []string {
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
 }
@@ -1,6 +1,8 @@
 package entity
 
 import (
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,6 +15,39 @@ type Config struct {
 	PostgresHost     string
 	PostgresPort     string
 	ServerPort       string
+	JWTSecret        string
+	ModeratorName    string
+	ModeratorPass    string
+	// MaxDepth is how deep replies may nest below a root comment; zero
+	// means no limit.
+	MaxDepth int
+	Filter   FilterConfig
+	Notify   NotifyConfig
+}
+
+type NotifyConfig struct {
+	// Sender is log, webhook or smtp.
+	Sender       string
+	BaseURL      string
+	Interval     time.Duration
+	MaxAttempts  int
+	WebhookURL   string
+	SMTPAddr     string
+	SMTPUser     string
+	SMTPPassword string
+	SMTPFrom     string
+}
+
+type FilterConfig struct {
+	BannedWords       []string
+	BannedWordsAction string
+	MaxLinks          int
+	LinksAction       string
+	MinLength         int
+	MaxLength         int
+	FloodLimit        int
+	FloodWindow       time.Duration
+	DuplicateWindow   time.Duration
 }
 
 type Comments struct {
@@ -20,12 +55,223 @@ type Comments struct {
 	ParentID  *uuid.UUID `db:"parent_id"`
 	UserName  string     `db:"user_name"`
 	Comment   string     `db:"comment"`
+	HTML      string     `db:"comment_html"`
 	Path      string     `db:"path"`
+	ThreadKey string     `db:"thread_key"`
 	Date      time.Time  `db:"date"`
+	Deleted   bool       `db:"deleted"`
+	EditedAt  *time.Time `db:"edited_at"`
+	AuthorID  *uuid.UUID `db:"author_id"`
+	Hidden    bool       `db:"hidden"`
+	Locked    bool       `db:"locked"`
+
+	Status       string `db:"status"`
+	StatusReason string `db:"status_reason"`
+
+	Upvotes   int            `db:"upvotes"`
+	Downvotes int            `db:"downvotes"`
+	Score     int            `db:"score"`
+	Reactions ReactionCounts `db:"reactions"`
+
+	Depth       int `db:"depth"`
+	ReplyCount  int `db:"reply_count"`
+	MoreReplies int `db:"more_replies"`
+}
+
+const (
+	StatusApproved = "approved"
+	StatusPending  = "pending"
+	StatusRejected = "rejected"
+)
+
+type CommentsPage struct {
+	Comments   []Comments
+	NextCursor string
+}
+
+type Revision struct {
+	RevisionID uuid.UUID `db:"revision_id"`
+	CommentID  uuid.UUID `db:"comment_id"`
+	Comment    string    `db:"comment"`
+	Date       time.Time `db:"date"`
 }
 
 type CommentResponse struct {
+	UserName  string
+	Comment   string
+	ParentID  uuid.UUID
+	ThreadKey string
+}
+
+type Thread struct {
+	ThreadKey    string    `db:"thread_key"`
+	Closed       bool      `db:"closed"`
+	CreatedAt    time.Time `db:"created_at"`
+	CommentCount int       `db:"comment_count"`
+	RootCount    int       `db:"root_count"`
+}
+
+// MoveRequest names the new place of a comment: a new parent, or no parent
+// to make it a root comment of ThreadKey (of its current thread if empty).
+type MoveRequest struct {
+	ParentID  uuid.UUID
+	ThreadKey string
+}
+
+// ThreadExport is a whole thread as it is moved between installations.
+// Comments come in tree order, so every parent precedes its replies.
+type ThreadExport struct {
+	Version    int
+	ThreadKey  string
+	Closed     bool
+	ExportedAt time.Time
+	Comments   []ExportedComment
+}
+
+type ExportedComment struct {
+	CommentID    uuid.UUID
+	ParentID     *uuid.UUID
+	UserName     string
+	AuthorID     *uuid.UUID
+	Comment      string
+	Date         time.Time
+	EditedAt     *time.Time
+	Deleted      bool
+	Hidden       bool
+	Locked       bool
+	Status       string
+	StatusReason string
+}
+
+// ImportResult maps the IDs from the imported file to the stored ones; they
+// differ only when IDs were remapped.
+type ImportResult struct {
+	ThreadKey string
+	Imported  int
+	IDs       map[uuid.UUID]uuid.UUID
+}
+
+// CommentEvent is pushed to subscribers when a published comment is created,
+// edited, deleted or moved. Comment is nil when the row no longer exists.
+// OldThreadKey and OldPath are set for moved comments only.
+type CommentEvent struct {
+	Type         string
+	CommentID    uuid.UUID
+	ParentID     *uuid.UUID
+	ThreadKey    string
+	Path         string
+	OldThreadKey string
+	OldPath      string
+	Comment      *Comments
+}
+
+const (
+	EventCreated = "created"
+	EventEdited  = "edited"
+	EventDeleted = "deleted"
+	EventMoved   = "moved"
+)
+
+// Notification tells a user about a reply to one of their comments or a
+// comment that mentions them.
+type Notification struct {
+	Kind string
+	Comments
+}
+
+type NotificationsPage struct {
+	Notifications []Notification
+	NextCursor    string
+}
+
+const (
+	NotifyReply   = "reply"
+	NotifyMention = "mention"
+	NotifyThread  = "thread"
+)
+
+type NotificationSettings struct {
+	Email    string `db:"email"`
+	Replies  bool   `db:"replies"`
+	Mentions bool   `db:"mentions"`
+	// Threads lists the keys of the threads the user follows.
+	Threads []string
+}
+
+type CommentNode struct {
+	Comments
+	Children []*CommentNode
+}
+
+type SearchQuery struct {
+	Query     string
+	Author    string
+	ThreadKey string
+	From      *time.Time
+	To        *time.Time
+	Limit     int
+	Offset    int
+}
+
+type SearchHit struct {
+	Comments
+	Rank         float64
+	Snippet      string
+	RootID       uuid.UUID
+	RootUserName string
+	RootComment  string
+}
+
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+)
+
+type User struct {
+	UserID    uuid.UUID `db:"user_id"`
+	UserName  string    `db:"user_name"`
+	PassHash  string    `db:"pass_hash" json:"-"`
+	Role      Role      `db:"role"`
+	Banned    bool      `db:"banned"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type Credentials struct {
 	UserName string
-	Comment  string
-	ParentID uuid.UUID
+	Password string
+}
+
+// ReactionCounts maps an emoji to the number of users who reacted with it.
+// It is stored as JSONB on the comment row.
+type ReactionCounts map[string]int
+
+func (r *ReactionCounts) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*r = ReactionCounts{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported reactions type %T", src)
+	}
+	counts := ReactionCounts{}
+	if err := json.Unmarshal(data, &counts); err != nil {
+		return err
+	}
+	*r = counts
+	return nil
+}
+
+type Vote struct {
+	Value int
+}
+
+type Reaction struct {
+	Emoji string
 }
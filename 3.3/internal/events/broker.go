@@ -0,0 +1,208 @@
+package events
+
+import (
+	e "commentTree/internal/entity"
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Channel is the notification channel written by the comments_notify
+// trigger in storage/model.sql.
+const Channel = "comment_events"
+
+// SubscriberBuffer is how many events a subscriber may fall behind before
+// it is dropped. A dropped client reconnects and reloads.
+const SubscriberBuffer = 32
+
+// Loader fetches the current state of a comment named in a notification.
+type Loader func(ctx context.Context, id uuid.UUID) (*e.Comments, error)
+
+// notification is the payload built by notify_comment_change().
+type notification struct {
+	Type         string     `json:"type"`
+	CommentID    uuid.UUID  `json:"comment_id"`
+	ParentID     *uuid.UUID `json:"parent_id"`
+	ThreadKey    string     `json:"thread_key"`
+	Path         string     `json:"path"`
+	OldThreadKey string     `json:"old_thread_key"`
+	OldPath      string     `json:"old_path"`
+}
+
+// Subscription receives the events of one thread, or of one subtree when
+// created with a path. Events is closed when the subscriber is dropped or
+// the broker stops.
+type Subscription struct {
+	Events <-chan e.CommentEvent
+
+	events    chan e.CommentEvent
+	threadKey string
+	path      string
+}
+
+// matches reports whether ev concerns the subscription; a moved comment
+// matches both where it was and where it is now.
+func (s *Subscription) matches(ev e.CommentEvent) bool {
+	if s.covers(ev.ThreadKey, ev.Path) {
+		return true
+	}
+	return ev.Type == e.EventMoved && s.covers(ev.OldThreadKey, ev.OldPath)
+}
+
+func (s *Subscription) covers(threadKey, path string) bool {
+	if threadKey != s.threadKey {
+		return false
+	}
+	return s.path == "" || path == s.path || strings.HasPrefix(path, s.path+".")
+}
+
+// Broker fans comment events out to the subscribers of this instance. The
+// events come from PostgreSQL LISTEN, so changes made through any instance
+// reach every subscriber.
+type Broker struct {
+	load Loader
+
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+func NewBroker(load Loader) *Broker {
+	return &Broker{
+		load: load,
+		subs: map[*Subscription]struct{}{},
+	}
+}
+
+// Subscribe registers a subscriber for a thread. A non-empty path limits
+// it to the comment with that path and everything below it.
+func (b *Broker) Subscribe(threadKey, path string) *Subscription {
+	ch := make(chan e.CommentEvent, SubscriberBuffer)
+	s := &Subscription{Events: ch, events: ch, threadKey: threadKey, path: path}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return s
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+func (b *Broker) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drop(s)
+}
+
+// drop must be called with mu held.
+func (b *Broker) drop(s *Subscription) {
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.events)
+	}
+}
+
+// Publish delivers ev to every matching subscriber without blocking.
+func (b *Broker) Publish(ev e.CommentEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		if !s.matches(ev) {
+			continue
+		}
+		select {
+		case s.events <- ev:
+		default:
+			b.drop(s)
+		}
+	}
+}
+
+// Listen receives notifications on a dedicated connection until ctx is
+// cancelled, then closes all subscriptions.
+func (b *Broker) Listen(ctx context.Context, dsn string) error {
+	defer b.close()
+
+	l := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("comment events listener: %v", err)
+		}
+	})
+	// Closing the listener also releases Listen while it waits for the
+	// database to come up.
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	if err := l.Listen(Channel); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ping.C:
+			go l.Ping()
+		case n := <-l.Notify:
+			// A nil notification follows a reconnect; whatever happened
+			// in between is lost.
+			if n == nil {
+				continue
+			}
+			b.handle(ctx, n.Extra)
+		}
+	}
+}
+
+func (b *Broker) handle(ctx context.Context, payload string) {
+	var n notification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		log.Printf("comment events: bad payload %q: %v", payload, err)
+		return
+	}
+
+	ev := e.CommentEvent{
+		Type:         n.Type,
+		CommentID:    n.CommentID,
+		ParentID:     n.ParentID,
+		ThreadKey:    n.ThreadKey,
+		Path:         n.Path,
+		OldThreadKey: n.OldThreadKey,
+		OldPath:      n.OldPath,
+	}
+
+	// Hard deletes leave nothing to load.
+	c, err := b.load(ctx, n.CommentID)
+	if err == nil {
+		ev.Comment = c
+	} else if n.Type != e.EventDeleted {
+		log.Printf("comment events: load %s: %v", n.CommentID, err)
+	}
+
+	b.Publish(ev)
+}
+
+func (b *Broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for s := range b.subs {
+		b.drop(s)
+	}
+}
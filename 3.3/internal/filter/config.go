@@ -0,0 +1,24 @@
+package filter
+
+import (
+	e "commentTree/internal/entity"
+)
+
+// FromConfig builds the default pipeline: length and banned words first,
+// since they need no database round trip, then link, flood and duplicate
+// checks.
+func FromConfig(cfg e.FilterConfig, h History) *Pipeline {
+	filters := []Filter{
+		Length{Min: cfg.MinLength, Max: cfg.MaxLength},
+		BannedWords{Words: cfg.BannedWords, Action: ParseAction(cfg.BannedWordsAction, Reject)},
+		LinkLimit{Max: cfg.MaxLinks, Action: ParseAction(cfg.LinksAction, Hold)},
+	}
+	if cfg.FloodLimit > 0 {
+		filters = append(filters, Flood{History: h, Limit: cfg.FloodLimit, Window: cfg.FloodWindow, Action: Reject})
+	}
+	if cfg.DuplicateWindow > 0 {
+		filters = append(filters, Duplicate{History: h, Window: cfg.DuplicateWindow, Action: Reject})
+	}
+
+	return NewPipeline(filters...)
+}
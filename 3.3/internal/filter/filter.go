@@ -0,0 +1,96 @@
+package filter
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Action int
+
+const (
+	Approve Action = iota
+	Hold
+	Reject
+)
+
+func (a Action) String() string {
+	switch a {
+	case Hold:
+		return "hold"
+	case Reject:
+		return "reject"
+	default:
+		return "approve"
+	}
+}
+
+func ParseAction(s string, def Action) Action {
+	switch s {
+	case "approve":
+		return Approve
+	case "hold":
+		return Hold
+	case "reject":
+		return Reject
+	default:
+		return def
+	}
+}
+
+type Candidate struct {
+	AuthorID uuid.UUID
+	ParentID uuid.UUID
+	Body     string
+	// Edit is set when an existing comment gets a new body rather than a
+	// new comment being posted.
+	Edit bool
+}
+
+type Result struct {
+	Action Action
+	Reason string
+}
+
+// Filter inspects a new comment before it is stored.
+type Filter interface {
+	Check(ctx context.Context, c Candidate) (Result, error)
+}
+
+// History gives filters access to what an author posted recently.
+type History interface {
+	RecentComments(ctx context.Context, authorID uuid.UUID, since time.Time) ([]string, error)
+}
+
+type Pipeline struct {
+	filters []Filter
+}
+
+func NewPipeline(filters ...Filter) *Pipeline {
+	return &Pipeline{filters: filters}
+}
+
+// Run applies every filter in order and returns the strictest result. A
+// rejection stops the pipeline straight away.
+func (p *Pipeline) Run(ctx context.Context, c Candidate) (Result, error) {
+	final := Result{Action: Approve}
+	if p == nil {
+		return final, nil
+	}
+
+	for _, f := range p.filters {
+		res, err := f.Check(ctx, c)
+		if err != nil {
+			return Result{}, err
+		}
+		if res.Action > final.Action {
+			final = res
+		}
+		if final.Action == Reject {
+			break
+		}
+	}
+
+	return final, nil
+}
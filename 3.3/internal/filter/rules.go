@@ -0,0 +1,119 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type Length struct {
+	Min int
+	Max int
+}
+
+func (f Length) Check(_ context.Context, c Candidate) (Result, error) {
+	n := utf8.RuneCountInString(strings.TrimSpace(c.Body))
+	if n < f.Min {
+		return Result{Reject, fmt.Sprintf("comment is shorter than %d characters", f.Min)}, nil
+	}
+	if f.Max > 0 && n > f.Max {
+		return Result{Reject, fmt.Sprintf("comment is longer than %d characters", f.Max)}, nil
+	}
+	return Result{Action: Approve}, nil
+}
+
+type BannedWords struct {
+	Words  []string
+	Action Action
+}
+
+var wordSplit = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+func (f BannedWords) Check(_ context.Context, c Candidate) (Result, error) {
+	if len(f.Words) == 0 {
+		return Result{Action: Approve}, nil
+	}
+
+	banned := make(map[string]bool, len(f.Words))
+	for _, w := range f.Words {
+		banned[strings.ToLower(w)] = true
+	}
+
+	for _, w := range wordSplit.FindAllString(strings.ToLower(c.Body), -1) {
+		if banned[w] {
+			return Result{f.Action, "comment contains a banned word"}, nil
+		}
+	}
+	return Result{Action: Approve}, nil
+}
+
+type LinkLimit struct {
+	Max    int
+	Action Action
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+func (f LinkLimit) Check(_ context.Context, c Candidate) (Result, error) {
+	if n := len(linkPattern.FindAllString(c.Body, -1)); n > f.Max {
+		return Result{f.Action, fmt.Sprintf("comment contains %d links, limit is %d", n, f.Max)}, nil
+	}
+	return Result{Action: Approve}, nil
+}
+
+// Flood limits how many comments an author can post within Window.
+type Flood struct {
+	History History
+	Limit   int
+	Window  time.Duration
+	Action  Action
+}
+
+func (f Flood) Check(ctx context.Context, c Candidate) (Result, error) {
+	// An edit posts nothing new.
+	if c.Edit {
+		return Result{Action: Approve}, nil
+	}
+	recent, err := f.History.RecentComments(ctx, c.AuthorID, time.Now().Add(-f.Window))
+	if err != nil {
+		return Result{}, err
+	}
+	if len(recent) >= f.Limit {
+		return Result{f.Action, fmt.Sprintf("more than %d comments in %s", f.Limit, f.Window)}, nil
+	}
+	return Result{Action: Approve}, nil
+}
+
+// Duplicate catches an author reposting the same text within Window.
+type Duplicate struct {
+	History History
+	Window  time.Duration
+	Action  Action
+}
+
+func (f Duplicate) Check(ctx context.Context, c Candidate) (Result, error) {
+	// The recent comments include the one being edited, so an edit that
+	// keeps its text would count as a repost.
+	if c.Edit {
+		return Result{Action: Approve}, nil
+	}
+	recent, err := f.History.RecentComments(ctx, c.AuthorID, time.Now().Add(-f.Window))
+	if err != nil {
+		return Result{}, err
+	}
+
+	body := normalize(c.Body)
+	for _, r := range recent {
+		if normalize(r) == body {
+			return Result{f.Action, "duplicate comment"}, nil
+		}
+	}
+	return Result{Action: Approve}, nil
+}
+
+func normalize(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
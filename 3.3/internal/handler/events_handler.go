@@ -0,0 +1,106 @@
+package handler
+
+import (
+	e "commentTree/internal/entity"
+	"commentTree/internal/events"
+	"commentTree/internal/middleware"
+	"commentTree/internal/service"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// heartbeat keeps idle streams from being closed by proxies.
+const heartbeat = 25 * time.Second
+
+type EventsHandler struct {
+	svc    *service.CommentsService
+	broker *events.Broker
+}
+
+func NewEventsHandler(svc *service.CommentsService, broker *events.Broker) *EventsHandler {
+	return &EventsHandler{
+		svc:    svc,
+		broker: broker,
+	}
+}
+
+// ThreadEvents streams changes to every comment of a thread as server-sent
+// events.
+func (h *EventsHandler) ThreadEvents(w http.ResponseWriter, r *http.Request) {
+	key, err := threadKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.stream(w, r, h.broker.Subscribe(key, ""))
+}
+
+// CommentEvents streams changes to a comment and its subtree.
+func (h *EventsHandler) CommentEvents(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid comment ID: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	c, err := h.svc.GetComment(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	h.stream(w, r, h.broker.Subscribe(c.ThreadKey, c.Path))
+}
+
+func (h *EventsHandler) stream(w http.ResponseWriter, r *http.Request, sub *events.Subscription) {
+	defer h.broker.Unsubscribe(sub)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	viewer := middleware.UserFromContext(r)
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case ev, ok := <-sub.Events:
+			if !ok {
+				return
+			}
+			// The comment is shared by all subscribers; mask a copy.
+			if ev.Comment != nil {
+				masked := []e.Comments{*ev.Comment}
+				service.MaskHidden(masked, viewer)
+				ev.Comment = &masked[0]
+			}
+
+			data, err := json.Marshal(ev)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+		}
+		flusher.Flush()
+	}
+}
@@ -2,9 +2,14 @@ package handler
 
 import (
 	e "commentTree/internal/entity"
+	"commentTree/internal/middleware"
 	"commentTree/internal/service"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -26,15 +31,24 @@ func (h *CommetsHandler) NewComments(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c, err := h.svc.Comments(r.Context(), comments)
+	h.postComment(w, r, comments)
+}
+
+func (h *CommetsHandler) postComment(w http.ResponseWriter, r *http.Request, comments e.CommentResponse) {
+	c, err := h.svc.Comments(r.Context(), comments, middleware.UserFromContext(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 
 	resp := map[string]interface{}{
 		"Comment posted": c.Comment,
 		"Comment ID":     c.CommentID,
+		"Thread":         c.ThreadKey,
+		"Status":         c.Status,
+	}
+	if c.Status == e.StatusPending {
+		resp["Reason"] = c.StatusReason
 	}
 
 	data, err := json.MarshalIndent(resp, "", "  ")
@@ -48,15 +62,44 @@ func (h *CommetsHandler) NewComments(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *CommetsHandler) GetComments(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("parent")
+	query := r.URL.Query()
+	id := query.Get("parent")
 
-	comments, err := h.svc.GetComments(r.Context(), id)
+	depth, err := intParam(query.Get("depth"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := intParam(query.Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	data, err := json.MarshalIndent(comments, "", "  ")
+	srt, err := service.ParseSort(query.Get("sort"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	comments, err := h.svc.GetComments(r.Context(), id, depth, limit, srt)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	service.MaskHidden(comments, middleware.UserFromContext(r))
+
+	var resp interface{} = comments
+	switch query.Get("format") {
+	case "", "flat":
+	case "tree":
+		resp = service.BuildTree(comments)
+	default:
+		http.Error(w, "unknown format, expected flat or tree", http.StatusBadRequest)
+		return
+	}
+
+	data, err := json.MarshalIndent(resp, "", "  ")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -67,11 +110,59 @@ func (h *CommetsHandler) GetComments(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *CommetsHandler) GetAllParentComments(w http.ResponseWriter, r *http.Request) {
-	comments, err := h.svc.GetAllParentComments(r.Context())
+	query := r.URL.Query()
+	limit, err := intParam(query.Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	srt, err := service.ParseSort(query.Get("sort"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	comments, err := h.svc.GetAllParentComments(r.Context(), query.Get("cursor"), limit, srt)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	service.MaskHidden(comments.Comments, middleware.UserFromContext(r))
+
+	data, err := json.MarshalIndent(comments, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (h *CommetsHandler) GetChildren(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	query := r.URL.Query()
+	limit, err := intParam(query.Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	srt, err := service.ParseSort(query.Get("sort"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	comments, err := h.svc.GetChildren(r.Context(), id, query.Get("cursor"), limit, srt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
+	service.MaskHidden(comments.Comments, middleware.UserFromContext(r))
 
 	data, err := json.MarshalIndent(comments, "", "  ")
 	if err != nil {
@@ -83,13 +174,162 @@ func (h *CommetsHandler) GetAllParentComments(w http.ResponseWriter, r *http.Req
 	w.Write(data)
 }
 
+func (h *CommetsHandler) Search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	sq := e.SearchQuery{
+		Query:     query.Get("q"),
+		Author:    query.Get("author"),
+		ThreadKey: query.Get("thread"),
+	}
+
+	var err error
+	if sq.Limit, err = intParam(query.Get("limit")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sq.Offset, err = intParam(query.Get("offset")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sq.From, err = dateParam(query.Get("from"), false); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sq.To, err = dateParam(query.Get("to"), true); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sq.Query == "" {
+		http.Error(w, "query parameter q is required", http.StatusBadRequest)
+		return
+	}
+
+	hits, err := h.svc.Search(r.Context(), sq)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	data, err := json.MarshalIndent(hits, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (h *CommetsHandler) EditComment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var body e.CommentResponse
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c, err := h.svc.EditComment(r.Context(), id, body.Comment, middleware.UserFromContext(r))
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (h *CommetsHandler) Vote(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var vote e.Vote
+	if err := json.NewDecoder(r.Body).Decode(&vote); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c, err := h.svc.Vote(r.Context(), id, vote.Value, middleware.UserFromContext(r))
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, c)
+}
+
+// React sets the caller's reaction with PUT and removes it with DELETE.
+func (h *CommetsHandler) React(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var reaction e.Reaction
+	if r.Method == http.MethodPut {
+		if err := json.NewDecoder(r.Body).Decode(&reaction); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if reaction.Emoji == "" {
+			http.Error(w, "emoji is required", http.StatusBadRequest)
+			return
+		}
+	}
+
+	c, err := h.svc.React(r.Context(), id, reaction.Emoji, middleware.UserFromContext(r))
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, c)
+}
+
+func (h *CommetsHandler) GetRevisions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	revisions, err := h.svc.GetRevisions(r.Context(), id, h.isModerator(r))
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	data, err := json.MarshalIndent(revisions, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// DeleteComments leaves a tombstone in place of the comment so replies
+// survive. ?hard=true removes the whole subtree and is moderator-only.
 func (h *CommetsHandler) DeleteComments(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	err := h.svc.DeleteComments(r.Context(), id)
+	var err error
+	if r.URL.Query().Get("hard") == "true" {
+		if !h.isModerator(r) {
+			http.Error(w, "hard delete is reserved for moderators", http.StatusForbidden)
+			return
+		}
+		err = h.svc.DeleteComments(r.Context(), id)
+	} else {
+		err = h.svc.SoftDeleteComment(r.Context(), id, middleware.UserFromContext(r))
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 
@@ -102,3 +342,75 @@ func (h *CommetsHandler) DeleteComments(w http.ResponseWriter, r *http.Request)
 func (h *CommetsHandler) Index(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "web/index.html")
 }
+
+func intParam(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid number %q", v)
+	}
+	return n, nil
+}
+
+// dateParam accepts RFC 3339 timestamps or plain dates. A plain date used as
+// an upper bound covers the whole day.
+func dateParam(v string, endOfDay bool) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q", v)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+func (h *CommetsHandler) isModerator(r *http.Request) bool {
+	user := middleware.UserFromContext(r)
+	return user != nil && user.Role == e.RoleModerator
+}
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrCommentNotFound), errors.Is(err, service.ErrUserNotFound),
+		errors.Is(err, service.ErrThreadNotFound), errors.Is(err, service.ErrParentNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrCommentDeleted):
+		return http.StatusGone
+	case errors.Is(err, service.ErrForbidden), errors.Is(err, service.ErrUserBanned), errors.Is(err, service.ErrBanModerator),
+		errors.Is(err, service.ErrThreadLocked), errors.Is(err, service.ErrThreadClosed):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrInvalidInput), errors.Is(err, service.ErrInvalidThreadKey), errors.Is(err, service.ErrInvalidEmail),
+		errors.Is(err, service.ErrInvalidParent), errors.Is(err, service.ErrInvalidImport):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrInvalidCredentials):
+		return http.StatusUnauthorized
+	case errors.Is(err, service.ErrUserExists), errors.Is(err, service.ErrNotPending),
+		errors.Is(err, service.ErrParentUnavailable), errors.Is(err, service.ErrTooDeep),
+		errors.Is(err, service.ErrImportConflict):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrRejected), errors.Is(err, service.ErrUnknownReaction):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,213 @@
+package handler
+
+import (
+	e "commentTree/internal/entity"
+	"commentTree/internal/service"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// maxImportSize bounds the body of a thread import.
+const maxImportSize = 32 << 20
+
+type ModerationHandler struct {
+	comments *service.CommentsService
+	users    *service.UsersService
+}
+
+func NewModerationHandler(comments *service.CommentsService, users *service.UsersService) *ModerationHandler {
+	return &ModerationHandler{
+		comments: comments,
+		users:    users,
+	}
+}
+
+// POST hides the comment, DELETE makes it visible again.
+func (h *ModerationHandler) Hide(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	hidden := r.Method == http.MethodPost
+
+	if err := h.comments.SetHidden(r.Context(), id, hidden); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	writeStatus(w, map[string]interface{}{"comment_id": id, "hidden": hidden})
+}
+
+// POST locks the comment and its subtree for new replies, DELETE unlocks.
+func (h *ModerationHandler) Lock(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	locked := r.Method == http.MethodPost
+
+	if err := h.comments.SetLocked(r.Context(), id, locked); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	writeStatus(w, map[string]interface{}{"comment_id": id, "locked": locked})
+}
+
+// POST closes the thread for new comments, DELETE reopens it.
+func (h *ModerationHandler) CloseThread(w http.ResponseWriter, r *http.Request) {
+	key, err := threadKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	closed := r.Method == http.MethodPost
+
+	if err := h.comments.SetThreadClosed(r.Context(), key, closed); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	writeStatus(w, map[string]interface{}{"thread_key": key, "closed": closed})
+}
+
+// POST bans the user, DELETE lifts the ban. Moderators cannot be banned.
+func (h *ModerationHandler) Ban(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	banned := r.Method == http.MethodPost
+
+	if err := h.users.SetBanned(r.Context(), id, banned); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	writeStatus(w, map[string]interface{}{"user_id": id, "banned": banned})
+}
+
+func (h *ModerationHandler) Queue(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, err := intParam(query.Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.comments.GetQueue(r.Context(), query.Get("cursor"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	data, err := json.MarshalIndent(page, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (h *ModerationHandler) Approve(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := h.comments.Approve(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	writeStatus(w, map[string]interface{}{"comment_id": id, "status": e.StatusApproved})
+}
+
+func (h *ModerationHandler) Reject(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := h.comments.Reject(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	writeStatus(w, map[string]interface{}{"comment_id": id, "status": e.StatusRejected})
+}
+
+// Move puts a comment with its replies under the parent given in the body,
+// or makes it a root comment if ParentID is empty.
+func (h *ModerationHandler) Move(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var m e.MoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.comments.MoveComment(r.Context(), id, m); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	resp := map[string]interface{}{"comment_id": id, "parent_id": nil}
+	if m.ParentID != uuid.Nil {
+		resp["parent_id"] = m.ParentID
+	}
+	writeStatus(w, resp)
+}
+
+func (h *ModerationHandler) ExportThread(w http.ResponseWriter, r *http.Request) {
+	key, err := threadKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	doc, err := h.comments.ExportThread(r.Context(), key)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="thread.json"`)
+	writeJSON(w, doc)
+}
+
+// ImportThread loads an export into the thread named in the path. With
+// ?ids=remap the comments get new IDs, by default they keep their own.
+func (h *ModerationHandler) ImportThread(w http.ResponseWriter, r *http.Request) {
+	key, err := threadKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var remap bool
+	switch r.URL.Query().Get("ids") {
+	case "", "preserve":
+	case "remap":
+		remap = true
+	default:
+		http.Error(w, "unknown ids mode, expected preserve or remap", http.StatusBadRequest)
+		return
+	}
+
+	var doc e.ThreadExport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportSize)).Decode(&doc); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.comments.ImportThread(r.Context(), key, doc, remap)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, res)
+}
+
+func writeStatus(w http.ResponseWriter, resp map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	e "commentTree/internal/entity"
+	"commentTree/internal/middleware"
+	"commentTree/internal/notify"
+	"commentTree/internal/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Notifications lists replies to the caller's comments and comments that
+// mention the caller.
+func (h *CommetsHandler) Notifications(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, err := intParam(query.Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.svc.GetNotifications(r.Context(), middleware.UserFromContext(r), query.Get("cursor"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, page)
+}
+
+type NotificationsHandler struct {
+	svc *service.NotificationsService
+}
+
+func NewNotificationsHandler(svc *service.NotificationsService) *NotificationsHandler {
+	return &NotificationsHandler{
+		svc: svc,
+	}
+}
+
+// Settings returns the caller's notification settings on GET and replaces
+// them on PUT.
+func (h *NotificationsHandler) Settings(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r)
+
+	var (
+		st  *e.NotificationSettings
+		err error
+	)
+	if r.Method == http.MethodPut {
+		var req e.NotificationSettings
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		st, err = h.svc.UpdateSettings(r.Context(), user, req)
+	} else {
+		st, err = h.svc.GetSettings(r.Context(), user)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, st)
+}
+
+// Subscription follows a thread on PUT and unfollows it on DELETE.
+func (h *NotificationsHandler) Subscription(w http.ResponseWriter, r *http.Request) {
+	key, err := threadKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	subscribed := r.Method == http.MethodPut
+
+	if err := h.svc.SubscribeThread(r.Context(), middleware.UserFromContext(r), key, subscribed); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	writeStatus(w, map[string]interface{}{"thread_key": key, "subscribed": subscribed})
+}
+
+// Unsubscribe is the target of the link in every notification and works
+// without logging in.
+func (h *NotificationsHandler) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	scope, err := h.svc.Unsubscribe(r.Context(), r.URL.Query().Get("token"))
+	if errors.Is(err, notify.ErrInvalidToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	var what string
+	switch {
+	case scope == notify.ScopeReplies:
+		what = "об ответах на ваши комментарии"
+	case scope == notify.ScopeMentions:
+		what = "об упоминаниях"
+	default:
+		what = fmt.Sprintf("об обсуждении %s", strings.TrimPrefix(scope, notify.ScopeThread))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "Вы отписались от уведомлений %s.\n", what)
+}
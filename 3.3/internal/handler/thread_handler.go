@@ -0,0 +1,114 @@
+package handler
+
+import (
+	e "commentTree/internal/entity"
+	"commentTree/internal/middleware"
+	"commentTree/internal/service"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+)
+
+// ThreadComments returns one page of root comments of a thread.
+func (h *CommetsHandler) ThreadComments(w http.ResponseWriter, r *http.Request) {
+	key, err := threadKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	limit, err := intParam(query.Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	srt, err := service.ParseSort(query.Get("sort"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.svc.GetThreadComments(r.Context(), key, query.Get("cursor"), limit, srt)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	service.MaskHidden(page.Comments, middleware.UserFromContext(r))
+
+	writeJSON(w, page)
+}
+
+// NewThreadComment posts a comment to the thread named in the path.
+func (h *CommetsHandler) NewThreadComment(w http.ResponseWriter, r *http.Request) {
+	key, err := threadKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var comments e.CommentResponse
+	if err := json.NewDecoder(r.Body).Decode(&comments); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	comments.ThreadKey = key
+
+	h.postComment(w, r, comments)
+}
+
+func (h *CommetsHandler) GetThread(w http.ResponseWriter, r *http.Request) {
+	key, err := threadKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t, err := h.svc.GetThread(r.Context(), key)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, t)
+}
+
+// ThreadCounts returns comment counts for every key= parameter.
+func (h *CommetsHandler) ThreadCounts(w http.ResponseWriter, r *http.Request) {
+	keys := r.URL.Query()["key"]
+	if len(keys) > service.MaxPageSize {
+		http.Error(w, "too many thread keys", http.StatusBadRequest)
+		return
+	}
+
+	counts, err := h.svc.ThreadCounts(r.Context(), keys)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, counts)
+}
+
+// Embed serves the comment widget for one thread. It is meant to be loaded
+// in an iframe by web/embed.js.
+func (h *CommetsHandler) Embed(w http.ResponseWriter, r *http.Request) {
+	if _, err := threadKey(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.ServeFile(w, r, "web/index.html")
+}
+
+func (h *CommetsHandler) EmbedScript(w http.ResponseWriter, r *http.Request) {
+	http.ServeFile(w, r, "web/embed.js")
+}
+
+// threadKey reads the key path variable. The router matches on the encoded
+// path so that keys may contain slashes, e.g. page URLs.
+func threadKey(r *http.Request) (string, error) {
+	return url.PathUnescape(mux.Vars(r)["key"])
+}
@@ -0,0 +1,121 @@
+// Package markdown renders comment bodies to HTML. Only a small Markdown
+// subset survives: emphasis, strikethrough, code, quotes, lists and links.
+// Raw HTML in the source is dropped and the result is passed through a
+// whitelist sanitiser, so the output is safe to insert into a page.
+package markdown
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	goldhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Resolver maps mentioned names, lower-cased, to the exact names of existing
+// users. Names missing from the result are rendered as plain text.
+type Resolver func(names []string) (map[string]string, error)
+
+type Result struct {
+	HTML string
+	// Mentions holds the resolved user names in order of appearance.
+	Mentions []string
+}
+
+type Renderer struct {
+	md     goldmark.Markdown
+	policy *bluemonday.Policy
+}
+
+func New() *Renderer {
+	md := goldmark.New(
+		goldmark.WithExtensions(extension.Linkify, extension.Strikethrough),
+		goldmark.WithParserOptions(
+			parser.WithInlineParsers(util.Prioritized(mentionParser{}, 500)),
+		),
+		goldmark.WithRendererOptions(
+			goldhtml.WithHardWraps(),
+			renderer.WithNodeRenderers(util.Prioritized(mentionRenderer{}, 500)),
+		),
+	)
+
+	return &Renderer{md: md, policy: policy()}
+}
+
+func policy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "strong", "em", "del", "code", "pre", "blockquote", "ul", "ol", "li")
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^mention$`)).OnElements("span")
+	return p
+}
+
+// Render converts src to sanitised HTML. resolve is called once with every
+// distinct name mentioned outside of code; it may be nil.
+func (r *Renderer) Render(src string, resolve Resolver) (Result, error) {
+	source := []byte(src)
+	doc := r.md.Parser().Parse(text.NewReader(source))
+
+	var mentions []*Mention
+	var names []string
+	seen := map[string]bool{}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if m, ok := n.(*Mention); ok && entering {
+			mentions = append(mentions, m)
+			key := strings.ToLower(m.Name)
+			if !seen[key] {
+				seen[key] = true
+				names = append(names, key)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	var res Result
+	if len(names) > 0 && resolve != nil {
+		known, err := resolve(names)
+		if err != nil {
+			return Result{}, err
+		}
+
+		added := map[string]bool{}
+		for _, m := range mentions {
+			name, ok := known[strings.ToLower(m.Name)]
+			if !ok {
+				continue
+			}
+			m.Name, m.Known = name, true
+			if !added[name] {
+				added[name] = true
+				res.Mentions = append(res.Mentions, name)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := r.md.Renderer().Render(&buf, source, doc); err != nil {
+		return Result{}, err
+	}
+	res.HTML = r.policy.Sanitize(buf.String())
+	return res, nil
+}
+
+// Plain renders text that is not Markdown, such as placeholders, in the
+// same shape as Render.
+func Plain(s string) string {
+	return "<p>" + html.EscapeString(s) + "</p>"
+}
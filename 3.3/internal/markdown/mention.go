@@ -0,0 +1,91 @@
+package markdown
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var KindMention = ast.NewNodeKind("Mention")
+
+// Mention is an @name reference. Known is set once the name has been
+// resolved to an existing user.
+type Mention struct {
+	ast.BaseInline
+	Name  string
+	Known bool
+}
+
+func (n *Mention) Kind() ast.NodeKind {
+	return KindMention
+}
+
+func (n *Mention) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Name": n.Name}, nil)
+}
+
+// isNameRune reports whether r may appear in a mentioned user name.
+func isNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+}
+
+type mentionParser struct{}
+
+func (mentionParser) Trigger() []byte {
+	return []byte{'@'}
+}
+
+func (mentionParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	// Skip the @ in e-mail addresses and the like.
+	if isNameRune(block.PrecendingCharacter()) {
+		return nil
+	}
+
+	line, _ := block.PeekLine()
+	i := 1
+	for i < len(line) {
+		r, size := utf8.DecodeRune(line[i:])
+		if !isNameRune(r) {
+			break
+		}
+		i += size
+	}
+	// A trailing dot ends the sentence, not the name.
+	for i > 1 && (line[i-1] == '.' || line[i-1] == '-') {
+		i--
+	}
+	if i == 1 {
+		return nil
+	}
+
+	block.Advance(i)
+	return &Mention{Name: string(line[1:i])}
+}
+
+type mentionRenderer struct{}
+
+func (r mentionRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMention, r.render)
+}
+
+func (mentionRenderer) render(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	n := node.(*Mention)
+	if n.Known {
+		w.WriteString(`<span class="mention">@`)
+		w.Write(util.EscapeHTML([]byte(n.Name)))
+		w.WriteString(`</span>`)
+	} else {
+		w.WriteByte('@')
+		w.Write(util.EscapeHTML([]byte(n.Name)))
+	}
+	return ast.WalkSkipChildren, nil
+}
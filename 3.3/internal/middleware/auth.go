@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"commentTree/internal/auth"
+	e "commentTree/internal/entity"
+	"context"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+const userCtxKey contextKey = "user"
+
+// Auth attaches the user from a bearer token to the request context. Requests
+// without a token pass through anonymously so that reads stay public.
+func Auth(tp *auth.TokenProvider) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			tokenStr, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok {
+				http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			user, err := tp.Parse(tokenStr)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userCtxKey, user)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func UserFromContext(r *http.Request) *e.User {
+	user, _ := r.Context().Value(userCtxKey).(*e.User)
+	return user
+}
+
+func RequireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if UserFromContext(r) == nil {
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func RequireRole(role e.Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := UserFromContext(r)
+		if user == nil {
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		if user.Role != role {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
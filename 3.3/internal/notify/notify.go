@@ -0,0 +1,223 @@
+// Package notify delivers comment notifications to users. The service only
+// talks to the Sender interface, so the transport can be swapped in config.
+package notify
+
+import (
+	"bytes"
+	e "commentTree/internal/entity"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Message is one notification for one user.
+type Message struct {
+	UserID   uuid.UUID
+	UserName string
+	Email    string
+	// Kind is reply, mention or thread.
+	Kind           string
+	Comment        e.Comments
+	Link           string
+	UnsubscribeURL string
+}
+
+func (m Message) Subject() string {
+	switch m.Kind {
+	case e.NotifyReply:
+		return fmt.Sprintf("%s ответил на ваш комментарий", m.Comment.UserName)
+	case e.NotifyMention:
+		return fmt.Sprintf("%s упомянул вас в комментарии", m.Comment.UserName)
+	default:
+		return fmt.Sprintf("Новый комментарий от %s", m.Comment.UserName)
+	}
+}
+
+type Sender interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// SendTimeout bounds a single send by the webhook and SMTP senders, from
+// dialling to the last reply.
+const SendTimeout = 10 * time.Second
+
+// LogSender writes notifications to the service log. It is the default and
+// is meant for development.
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, m Message) error {
+	log.Printf("notify %s (%s): %s: %s, unsubscribe: %s", m.UserName, m.Kind, m.Subject(), m.Link, m.UnsubscribeURL)
+	return nil
+}
+
+// WebhookSender posts every message as JSON to a fixed URL, leaving the
+// choice of channel to the receiving system.
+type WebhookSender struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s WebhookSender) Send(ctx context.Context, m Message) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"UserID":         m.UserID,
+		"UserName":       m.UserName,
+		"Email":          m.Email,
+		"Kind":           m.Kind,
+		"Subject":        m.Subject(),
+		"Comment":        m.Comment,
+		"Link":           m.Link,
+		"UnsubscribeURL": m.UnsubscribeURL,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// SMTPSender sends plain-text e-mail to the address from the user's
+// notification settings.
+type SMTPSender struct {
+	Addr     string
+	From     string
+	User     string
+	Password string
+}
+
+// ErrNoAddress is returned for users who have not set an e-mail address.
+var ErrNoAddress = errors.New("user has no e-mail address")
+
+func (s SMTPSender) Send(ctx context.Context, m Message) error {
+	if m.Email == "" {
+		return ErrNoAddress
+	}
+
+	var auth smtp.Auth
+	if s.User != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.User, s.Password, host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", m.Email)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", m.Subject()))
+	fmt.Fprintf(&msg, "List-Unsubscribe: <%s>\r\n", m.UnsubscribeURL)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s:\r\n\r\n%s\r\n\r\n%s\r\n\r\n", m.Subject(), m.Comment.Comment, m.Link)
+	fmt.Fprintf(&msg, "Отписаться: %s\r\n", m.UnsubscribeURL)
+
+	return s.send(ctx, auth, m.Email, msg.String())
+}
+
+// send does what smtp.SendMail does, on a connection that gives up after
+// SendTimeout or once ctx is done.
+func (s SMTPSender) send(ctx context.Context, auth smtp.Auth, to, msg string) error {
+	ctx, cancel := context.WithTimeout(ctx, SendTimeout)
+	defer cancel()
+
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+	d := net.Dialer{Timeout: SendTimeout}
+	conn, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// FromConfig picks the sender named in cfg.Sender.
+func FromConfig(cfg e.NotifyConfig) (Sender, error) {
+	switch cfg.Sender {
+	case "", "log":
+		return LogSender{}, nil
+	case "webhook":
+		if cfg.WebhookURL == "" {
+			return nil, fmt.Errorf("NOTIFY_WEBHOOK_URL is required for the webhook sender")
+		}
+		return WebhookSender{URL: cfg.WebhookURL, Client: &http.Client{Timeout: SendTimeout}}, nil
+	case "smtp":
+		if cfg.SMTPAddr == "" || cfg.SMTPFrom == "" {
+			return nil, fmt.Errorf("SMTP_ADDR and SMTP_FROM are required for the smtp sender")
+		}
+		return SMTPSender{Addr: cfg.SMTPAddr, From: cfg.SMTPFrom, User: cfg.SMTPUser, Password: cfg.SMTPPassword}, nil
+	default:
+		return nil, fmt.Errorf("unknown notification sender %q", cfg.Sender)
+	}
+}
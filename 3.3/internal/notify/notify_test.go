@@ -0,0 +1,37 @@
+package notify
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSMTPSenderGivesUpOnHungServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// The server accepts but never sends its greeting.
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = SMTPSender{Addr: ln.Addr().String(), From: "noreply@example.com"}.Send(ctx, Message{Email: "user@example.com"})
+	if err == nil {
+		t.Fatal("send to a hung server succeeded")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("send returned after %s, want it to stop with ctx", d)
+	}
+}
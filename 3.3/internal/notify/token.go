@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidToken = errors.New("invalid unsubscribe token")
+
+// Unsubscribe scopes. A thread scope is "thread:" followed by the key.
+const (
+	ScopeReplies  = "replies"
+	ScopeMentions = "mentions"
+	ScopeThread   = "thread:"
+)
+
+// UnsubscribeToken signs a user ID and scope so that the link in a
+// notification works without logging in.
+func UnsubscribeToken(secret []byte, userID uuid.UUID, scope string) string {
+	payload := userID.String() + "|" + scope
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(sign(secret, payload))
+}
+
+// ParseUnsubscribeToken checks the signature and returns what was signed.
+func ParseUnsubscribeToken(secret []byte, token string) (uuid.UUID, string, error) {
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return uuid.Nil, "", ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return uuid.Nil, "", ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil || !hmac.Equal(sig, sign(secret, string(payload))) {
+		return uuid.Nil, "", ErrInvalidToken
+	}
+
+	rawID, scope, ok := strings.Cut(string(payload), "|")
+	if !ok {
+		return uuid.Nil, "", ErrInvalidToken
+	}
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		return uuid.Nil, "", ErrInvalidToken
+	}
+	return id, scope, nil
+}
+
+func sign(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("unsubscribe|" + payload))
+	return mac.Sum(nil)
+}
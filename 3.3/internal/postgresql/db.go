@@ -9,9 +9,14 @@ import (
 )
 
 func InitDB(cfg *e.Config) (*sql.DB, error) {
-	dsn := fmt.Sprintf(
+	return sql.Open("postgres", DSN(cfg))
+}
+
+// DSN is also used to open the dedicated LISTEN connection for comment
+// events.
+func DSN(cfg *e.Config) string {
+	return fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		cfg.PostgresHost, cfg.PostgresPort, cfg.PostgresUser, cfg.PostgresPassword, cfg.PostgresDB,
 	)
-	return sql.Open("postgres", dsn)
 }
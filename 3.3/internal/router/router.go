@@ -1,21 +1,62 @@
 package router
 
 import (
+	"commentTree/internal/auth"
+	e "commentTree/internal/entity"
 	"commentTree/internal/handler"
 	"commentTree/internal/middleware"
 
 	"github.com/gorilla/mux"
 )
 
-func NewRouter(h *handler.CommetsHandler) *mux.Router {
+func NewRouter(h *handler.CommetsHandler, ah *handler.AuthHandler, mh *handler.ModerationHandler, eh *handler.EventsHandler, nh *handler.NotificationsHandler, tp *auth.TokenProvider) *mux.Router {
 	r := mux.NewRouter()
+	// Thread keys are often URLs and arrive with escaped slashes, which
+	// only survive route matching on the encoded path.
+	r.UseEncodedPath()
 	r.Use(middleware.Logger)
+	r.Use(middleware.Auth(tp))
 
 	r.HandleFunc("/", h.Index)
+	r.HandleFunc("/register", ah.Register).Methods("POST")
+	r.HandleFunc("/login", ah.Login).Methods("POST")
+
+	r.HandleFunc("/embed.js", h.EmbedScript).Methods("GET")
+	r.HandleFunc("/embed/{key}", h.Embed).Methods("GET")
+
+	r.HandleFunc("/threads", h.ThreadCounts).Methods("GET")
+	r.HandleFunc("/threads/{key}", h.GetThread).Methods("GET")
+	r.HandleFunc("/threads/{key}/comments", h.ThreadComments).Methods("GET")
+	r.HandleFunc("/threads/{key}/events", eh.ThreadEvents).Methods("GET")
+	r.HandleFunc("/threads/{key}/subscription", middleware.RequireAuth(nh.Subscription)).Methods("PUT", "DELETE")
+	r.HandleFunc("/threads/{key}/comments", middleware.RequireAuth(h.NewThreadComment)).Methods("POST")
+
 	r.HandleFunc("/allComments", h.GetAllParentComments).Methods("GET")
-	r.HandleFunc("/comments", h.NewComments).Methods("POST")
+	r.HandleFunc("/comments", middleware.RequireAuth(h.NewComments)).Methods("POST")
 	r.HandleFunc("/comments", h.GetComments).Methods("GET")
-	r.HandleFunc("/comments/{id}", h.DeleteComments).Methods("DELETE")
+	r.HandleFunc("/comments/search", h.Search).Methods("GET")
+	r.HandleFunc("/comments/{id}/children", h.GetChildren).Methods("GET")
+	r.HandleFunc("/comments/{id}/revisions", h.GetRevisions).Methods("GET")
+	r.HandleFunc("/comments/{id}/events", eh.CommentEvents).Methods("GET")
+	r.HandleFunc("/comments/{id}/vote", middleware.RequireAuth(h.Vote)).Methods("PUT")
+	r.HandleFunc("/comments/{id}/reaction", middleware.RequireAuth(h.React)).Methods("PUT", "DELETE")
+	r.HandleFunc("/comments/{id}/move", middleware.RequireRole(e.RoleModerator, mh.Move)).Methods("POST")
+	r.HandleFunc("/comments/{id}", middleware.RequireAuth(h.EditComment)).Methods("PUT")
+	r.HandleFunc("/comments/{id}", middleware.RequireAuth(h.DeleteComments)).Methods("DELETE")
+
+	r.HandleFunc("/notifications", middleware.RequireAuth(h.Notifications)).Methods("GET")
+	r.HandleFunc("/notifications/settings", middleware.RequireAuth(nh.Settings)).Methods("GET", "PUT")
+	r.HandleFunc("/notifications/unsubscribe", nh.Unsubscribe).Methods("GET", "POST")
+
+	r.HandleFunc("/moderation/queue", middleware.RequireRole(e.RoleModerator, mh.Queue)).Methods("GET")
+	r.HandleFunc("/moderation/comments/{id}/approve", middleware.RequireRole(e.RoleModerator, mh.Approve)).Methods("POST")
+	r.HandleFunc("/moderation/comments/{id}/reject", middleware.RequireRole(e.RoleModerator, mh.Reject)).Methods("POST")
+	r.HandleFunc("/moderation/comments/{id}/hide", middleware.RequireRole(e.RoleModerator, mh.Hide)).Methods("POST", "DELETE")
+	r.HandleFunc("/moderation/comments/{id}/lock", middleware.RequireRole(e.RoleModerator, mh.Lock)).Methods("POST", "DELETE")
+	r.HandleFunc("/moderation/threads/{key}/close", middleware.RequireRole(e.RoleModerator, mh.CloseThread)).Methods("POST", "DELETE")
+	r.HandleFunc("/moderation/threads/{key}/export", middleware.RequireRole(e.RoleModerator, mh.ExportThread)).Methods("GET")
+	r.HandleFunc("/moderation/threads/{key}/import", middleware.RequireRole(e.RoleModerator, mh.ImportThread)).Methods("POST")
+	r.HandleFunc("/moderation/users/{id}/ban", middleware.RequireRole(e.RoleModerator, mh.Ban)).Methods("POST", "DELETE")
 
 	return r
 }
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// cursor points at the last comment of a page. Chronological pages are
+// ordered by (date, comment_id), so the pair is unique and stable under
+// inserts; ranked pages carry a plain offset.
+type cursor struct {
+	Date   time.Time
+	ID     uuid.UUID
+	Offset int
+}
+
+func encodeCursor(date time.Time, id uuid.UUID) string {
+	raw := date.UTC().Format(time.RFC3339Nano) + "|" + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func encodeOffsetCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset|" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cursor", ErrInvalidInput)
+	}
+
+	dateStr, idStr, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, fmt.Errorf("%w: cursor", ErrInvalidInput)
+	}
+
+	if dateStr == "offset" {
+		offset, err := strconv.Atoi(idStr)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("%w: cursor", ErrInvalidInput)
+		}
+		return &cursor{Offset: offset}, nil
+	}
+
+	date, err := time.Parse(time.RFC3339Nano, dateStr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cursor", ErrInvalidInput)
+	}
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cursor", ErrInvalidInput)
+	}
+
+	return &cursor{Date: date, ID: id}, nil
+}
+
+func normalizeLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return limit
+}
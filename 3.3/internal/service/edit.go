@@ -0,0 +1,122 @@
+package service
+
+import (
+	e "commentTree/internal/entity"
+	"commentTree/internal/filter"
+	"commentTree/internal/markdown"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const Tombstone = "[deleted]"
+
+var (
+	ErrCommentNotFound = errors.New("comment not found")
+	ErrCommentDeleted  = errors.New("comment is deleted")
+	ErrForbidden       = errors.New("forbidden")
+	// ErrInvalidInput wraps every error caused by a malformed request,
+	// such as an unparsable ID or an empty body.
+	ErrInvalidInput = errors.New("invalid input")
+)
+
+// canModify reports whether the user may edit or delete a comment written
+// by authorID. Comments posted before accounts existed have no author and
+// can only be changed by moderators.
+func canModify(u *e.User, authorID *uuid.UUID, allowModerator bool) bool {
+	if u == nil {
+		return false
+	}
+	if allowModerator && u.Role == e.RoleModerator {
+		return true
+	}
+	return authorID != nil && *authorID == u.UserID
+}
+
+// EditComment replaces the body of a comment and keeps the previous one in
+// comment_revisions. Only the author may edit. The new body goes through
+// the filter pipeline like a new comment: it may be rejected, or send the
+// comment back to the moderation queue.
+func (s *CommentsService) EditComment(ctx context.Context, stringID string, body string, u *e.User) (*e.Comments, error) {
+	id, err := uuid.Parse(stringID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: comment ID: %v", ErrInvalidInput, err)
+	}
+	if strings.TrimSpace(body) == "" {
+		return nil, fmt.Errorf("%w: empty comment", ErrInvalidInput)
+	}
+
+	if err := s.checkNotBanned(ctx, u); err != nil {
+		return nil, err
+	}
+
+	verdict := filter.Result{Action: filter.Approve}
+	if u.Role != e.RoleModerator {
+		verdict, err = s.filters.Run(ctx, filter.Candidate{AuthorID: u.UserID, Body: body, Edit: true})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if verdict.Action == filter.Reject {
+		return nil, fmt.Errorf("%w: %s", ErrRejected, verdict.Reason)
+	}
+	var status string
+	if verdict.Action == filter.Hold {
+		status = e.StatusPending
+	}
+
+	html, mentions, err := s.render(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repo.Edit(ctx, id, body, html, mentions, status, verdict.Reason, func(c *e.Comments) error {
+		if c.Deleted {
+			return ErrCommentDeleted
+		}
+		if !canModify(u, c.AuthorID, false) {
+			return ErrForbidden
+		}
+		return nil
+	})
+}
+
+// SoftDeleteComment replaces the body with a tombstone. Replies stay in
+// place and the original text is kept as the last revision. The author or a
+// moderator may delete.
+func (s *CommentsService) SoftDeleteComment(ctx context.Context, stringID string, u *e.User) error {
+	id, err := uuid.Parse(stringID)
+	if err != nil {
+		return fmt.Errorf("%w: comment ID: %v", ErrInvalidInput, err)
+	}
+
+	return s.repo.SoftDelete(ctx, id, Tombstone, markdown.Plain(Tombstone), func(c *e.Comments) error {
+		if !canModify(u, c.AuthorID, true) {
+			return ErrForbidden
+		}
+		return nil
+	})
+}
+
+// GetRevisions lists earlier versions of a comment. The history of a
+// deleted or hidden comment holds its removed text, so only moderators may
+// read it.
+func (s *CommentsService) GetRevisions(ctx context.Context, stringID string, moderator bool) ([]e.Revision, error) {
+	id, err := uuid.Parse(stringID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: comment ID: %v", ErrInvalidInput, err)
+	}
+
+	c, err := s.repo.Find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if (c.Deleted || c.Hidden) && !moderator {
+		return nil, ErrCommentDeleted
+	}
+
+	return s.repo.Revisions(ctx, id)
+}
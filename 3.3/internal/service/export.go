@@ -0,0 +1,159 @@
+package service
+
+import (
+	e "commentTree/internal/entity"
+	"commentTree/internal/markdown"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ExportVersion is written to every export; imports of other versions are
+// refused.
+const ExportVersion = 1
+
+// MaxImportComments bounds the size of one import.
+const MaxImportComments = 10000
+
+var ErrInvalidImport = errors.New("invalid import")
+
+// ExportThread returns a thread with every comment in it, including
+// deleted, hidden and unmoderated ones.
+func (s *CommentsService) ExportThread(ctx context.Context, key string) (*e.ThreadExport, error) {
+	if err := validThreadKey(key); err != nil {
+		return nil, err
+	}
+
+	t, comments, err := s.repo.Export(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &e.ThreadExport{
+		Version:    ExportVersion,
+		ThreadKey:  t.ThreadKey,
+		Closed:     t.Closed,
+		ExportedAt: time.Now().UTC(),
+		Comments:   make([]e.ExportedComment, 0, len(comments)),
+	}
+	for _, c := range comments {
+		out.Comments = append(out.Comments, e.ExportedComment{
+			CommentID:    c.CommentID,
+			ParentID:     c.ParentID,
+			UserName:     c.UserName,
+			AuthorID:     c.AuthorID,
+			Comment:      c.Comment,
+			Date:         c.Date,
+			EditedAt:     c.EditedAt,
+			Deleted:      c.Deleted,
+			Hidden:       c.Hidden,
+			Locked:       c.Locked,
+			Status:       c.Status,
+			StatusReason: c.StatusReason,
+		})
+	}
+	return out, nil
+}
+
+// ImportThread stores an exported thread under key, which may differ from
+// the key it was exported with. With remap every comment gets a new ID,
+// so a thread can be imported next to its original; otherwise the IDs are
+// kept and the import fails if any of them is taken. HTML and mentions are
+// rendered anew, votes are not carried over and nobody is notified.
+func (s *CommentsService) ImportThread(ctx context.Context, key string, doc e.ThreadExport, remap bool) (*e.ImportResult, error) {
+	if err := validThreadKey(key); err != nil {
+		return nil, err
+	}
+	if doc.Version != ExportVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidImport, doc.Version)
+	}
+	if len(doc.Comments) > MaxImportComments {
+		return nil, fmt.Errorf("%w: more than %d comments", ErrInvalidImport, MaxImportComments)
+	}
+
+	res := &e.ImportResult{ThreadKey: key, IDs: make(map[uuid.UUID]uuid.UUID, len(doc.Comments))}
+	paths := make(map[uuid.UUID]string, len(doc.Comments))
+	comments := make([]e.Comments, 0, len(doc.Comments))
+	mentions := map[uuid.UUID][]uuid.UUID{}
+
+	for i, x := range doc.Comments {
+		if x.CommentID == uuid.Nil {
+			return nil, fmt.Errorf("%w: comment %d has no ID", ErrInvalidImport, i)
+		}
+		if _, ok := res.IDs[x.CommentID]; ok {
+			return nil, fmt.Errorf("%w: duplicate comment %s", ErrInvalidImport, x.CommentID)
+		}
+		if x.Comment == "" {
+			return nil, fmt.Errorf("%w: comment %s is empty", ErrInvalidImport, x.CommentID)
+		}
+
+		c := e.Comments{
+			CommentID:    x.CommentID,
+			UserName:     x.UserName,
+			Comment:      x.Comment,
+			ThreadKey:    key,
+			Date:         x.Date,
+			Deleted:      x.Deleted,
+			EditedAt:     x.EditedAt,
+			AuthorID:     x.AuthorID,
+			Hidden:       x.Hidden,
+			Locked:       x.Locked,
+			Status:       x.Status,
+			StatusReason: x.StatusReason,
+		}
+		switch c.Status {
+		case "":
+			c.Status = e.StatusApproved
+		case e.StatusApproved, e.StatusPending, e.StatusRejected:
+		default:
+			return nil, fmt.Errorf("%w: comment %s has unknown status %q", ErrInvalidImport, x.CommentID, x.Status)
+		}
+		if c.Date.IsZero() {
+			c.Date = time.Now().UTC()
+		}
+		if remap {
+			c.CommentID = uuid.New()
+		}
+
+		// Parents come first, which also rules out cycles.
+		label := strings.ReplaceAll(uuid.NewString(), "-", "")
+		if x.ParentID == nil {
+			c.Path = label
+		} else {
+			parentID, ok := res.IDs[*x.ParentID]
+			if !ok {
+				return nil, fmt.Errorf("%w: comment %s comes before its parent", ErrInvalidImport, x.CommentID)
+			}
+			c.ParentID = &parentID
+			c.Path = paths[parentID] + "." + label
+		}
+
+		if c.Deleted {
+			c.HTML = markdown.Plain(c.Comment)
+		} else {
+			html, users, err := s.render(ctx, c.Comment)
+			if err != nil {
+				return nil, err
+			}
+			c.HTML = html
+			if len(users) > 0 {
+				mentions[c.CommentID] = users
+			}
+		}
+
+		res.IDs[x.CommentID] = c.CommentID
+		paths[c.CommentID] = c.Path
+		comments = append(comments, c)
+	}
+
+	err := s.repo.Import(ctx, &e.Thread{ThreadKey: key, Closed: doc.Closed}, comments, mentions)
+	if err != nil {
+		return nil, err
+	}
+	res.Imported = len(comments)
+	return res, nil
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"commentTree/internal/filter"
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type commentHistory struct {
+	db *sql.DB
+}
+
+// NewCommentHistory lets the flood and duplicate filters look up what an
+// author posted recently, including comments still held for moderation.
+func NewCommentHistory(db *sql.DB) filter.History {
+	return &commentHistory{db: db}
+}
+
+func (h *commentHistory) RecentComments(ctx context.Context, authorID uuid.UUID, since time.Time) ([]string, error) {
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT COALESCE(comment, '')
+		FROM comments
+		WHERE author_id = $1 AND date >= $2
+		ORDER BY date DESC;
+	`, authorID, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bodies []string
+	for rows.Next() {
+		var body string
+		if err := rows.Scan(&body); err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, body)
+	}
+	return bodies, rows.Err()
+}
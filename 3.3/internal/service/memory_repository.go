@@ -0,0 +1,782 @@
+package service
+
+import (
+	e "commentTree/internal/entity"
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryRepository keeps the comment tree in memory. It follows the same
+// contract as PostgresRepository and is meant for tests.
+type MemoryRepository struct {
+	mu        sync.RWMutex
+	maxDepth  int
+	comments  map[uuid.UUID]*e.Comments
+	threads   map[string]bool
+	closed    map[string]bool
+	mentions  map[uuid.UUID][]uuid.UUID
+	revisions map[uuid.UUID][]e.Revision
+	votes     map[voteKey]int
+	reactions map[voteKey]string
+	users     map[uuid.UUID]e.User
+
+	// Now stamps new comments; tests may replace it to control ordering.
+	Now func() time.Time
+}
+
+func NewMemoryRepository(maxDepth int) *MemoryRepository {
+	return &MemoryRepository{
+		maxDepth:  maxDepth,
+		comments:  map[uuid.UUID]*e.Comments{},
+		threads:   map[string]bool{},
+		closed:    map[string]bool{},
+		mentions:  map[uuid.UUID][]uuid.UUID{},
+		revisions: map[uuid.UUID][]e.Revision{},
+		votes:     map[voteKey]int{},
+		reactions: map[voteKey]string{},
+		users:     map[uuid.UUID]e.User{},
+		Now:       time.Now,
+	}
+}
+
+// voteKey identifies the vote or reaction of one user on one comment.
+type voteKey struct {
+	CommentID uuid.UUID
+	UserID    uuid.UUID
+}
+
+func (r *MemoryRepository) Parent(ctx context.Context, id uuid.UUID) (*Parent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.comments[id]
+	if !ok {
+		return nil, ErrParentNotFound
+	}
+	if c.Status != e.StatusApproved {
+		return nil, ErrParentUnavailable
+	}
+
+	p := &Parent{
+		CommentID: id,
+		ThreadKey: c.ThreadKey,
+		Path:      c.Path,
+		Depth:     pathDepth(c.Path),
+		Closed:    r.closed[c.ThreadKey],
+	}
+	for _, other := range r.comments {
+		if other.Locked && isAncestorPath(other.Path, c.Path) {
+			p.Locked = true
+			break
+		}
+	}
+	return p, nil
+}
+
+func (r *MemoryRepository) OpenThread(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed[key] {
+		return ErrThreadClosed
+	}
+	r.threads[key] = true
+	return nil
+}
+
+func (r *MemoryRepository) Insert(ctx context.Context, c *e.Comments, mentions []uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c.CommentID = uuid.New()
+	c.Date = r.Now()
+	label := strings.ReplaceAll(c.CommentID.String(), "-", "")
+
+	if c.ParentID == nil {
+		c.Path = label
+	} else {
+		parent, ok := r.comments[*c.ParentID]
+		if !ok {
+			return ErrParentNotFound
+		}
+		if parent.Status != e.StatusApproved {
+			return ErrParentUnavailable
+		}
+		if r.maxDepth > 0 && pathDepth(parent.Path)+1 > r.maxDepth {
+			return ErrTooDeep
+		}
+		c.ThreadKey = parent.ThreadKey
+		c.Path = parent.Path + "." + label
+	}
+
+	stored := *c
+	r.comments[c.CommentID] = &stored
+	if len(mentions) > 0 {
+		r.mentions[c.CommentID] = append([]uuid.UUID(nil), mentions...)
+	}
+	return nil
+}
+
+func (r *MemoryRepository) Get(ctx context.Context, id uuid.UUID) (*e.Comments, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.comments[id]
+	if !ok || c.Status != e.StatusApproved {
+		return nil, ErrCommentNotFound
+	}
+	out := r.view(c, pathDepth(c.Path))
+	return &out, nil
+}
+
+func (r *MemoryRepository) Find(ctx context.Context, id uuid.UUID) (*e.Comments, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.comments[id]
+	if !ok {
+		return nil, ErrCommentNotFound
+	}
+	out := clone(c)
+	return &out, nil
+}
+
+func (r *MemoryRepository) Subtree(ctx context.Context, id uuid.UUID, maxDepth, perNode int, srt Sort) ([]e.Comments, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	root, ok := r.comments[id]
+	if !ok || root.Status != e.StatusApproved {
+		return nil, ErrCommentNotFound
+	}
+
+	base := pathDepth(root.Path)
+	all := []e.Comments{}
+	for _, c := range r.comments {
+		if c.CommentID == id || c.Status != e.StatusApproved || !isAncestorPath(root.Path, c.Path) {
+			continue
+		}
+		depth := pathDepth(c.Path) - base
+		if maxDepth > 0 && depth > maxDepth {
+			continue
+		}
+		all = append(all, r.view(c, depth))
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Path < all[j].Path })
+	if perNode <= 0 {
+		return all, nil
+	}
+
+	// Pick the first perNode replies of every node in srt order.
+	kept := map[uuid.UUID]bool{}
+	siblings := map[uuid.UUID][]*e.Comments{}
+	for i := range all {
+		c := &all[i]
+		siblings[*c.ParentID] = append(siblings[*c.ParentID], c)
+	}
+	for _, list := range siblings {
+		sort.Slice(list, func(i, j int) bool { return less(srt, list[i], list[j]) })
+		for i := 0; i < len(list) && i < perNode; i++ {
+			kept[list[i].CommentID] = true
+		}
+	}
+
+	out := []e.Comments{}
+	included := map[uuid.UUID]bool{id: true}
+	for _, c := range all {
+		// A parent always comes before its replies in path order, so a
+		// reply is kept only if its parent made it in.
+		if !kept[c.CommentID] || !included[*c.ParentID] {
+			continue
+		}
+		out = append(out, c)
+		included[c.CommentID] = true
+	}
+	return out, nil
+}
+
+func (r *MemoryRepository) Children(ctx context.Context, id uuid.UUID, p Page) ([]e.Comments, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	parent, ok := r.comments[id]
+	if !ok || parent.Status != e.StatusApproved {
+		return nil, ErrCommentNotFound
+	}
+
+	return r.page(p, 1, func(c *e.Comments) bool {
+		return c.ParentID != nil && *c.ParentID == id
+	}), nil
+}
+
+func (r *MemoryRepository) Roots(ctx context.Context, threadKey string, p Page) ([]e.Comments, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.page(p, 0, func(c *e.Comments) bool {
+		return c.ParentID == nil && c.ThreadKey == threadKey
+	}), nil
+}
+
+func (r *MemoryRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	root, ok := r.comments[id]
+	if !ok {
+		return ErrCommentNotFound
+	}
+
+	path := root.Path
+	for cid, c := range r.comments {
+		if isAncestorPath(path, c.Path) {
+			delete(r.comments, cid)
+			delete(r.mentions, cid)
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) Move(ctx context.Context, id uuid.UUID, parentID *uuid.UUID, threadKey string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.comments[id]
+	if !ok {
+		return ErrCommentNotFound
+	}
+	oldPath := c.Path
+
+	newPath := oldPath[strings.LastIndexByte(oldPath, '.')+1:]
+	if parentID != nil {
+		parent, ok := r.comments[*parentID]
+		if !ok {
+			return ErrParentNotFound
+		}
+		if parent.Status != e.StatusApproved {
+			return ErrParentUnavailable
+		}
+		if isAncestorPath(oldPath, parent.Path) {
+			return fmt.Errorf("%w: a comment cannot be moved below itself", ErrInvalidParent)
+		}
+		threadKey = parent.ThreadKey
+		newPath = parent.Path + "." + newPath
+	} else if threadKey == "" {
+		threadKey = c.ThreadKey
+	}
+
+	height := 0
+	for _, other := range r.comments {
+		if isAncestorPath(oldPath, other.Path) {
+			height = max(height, pathDepth(other.Path)-pathDepth(oldPath))
+		}
+	}
+	if r.maxDepth > 0 && pathDepth(newPath)+height > r.maxDepth {
+		return ErrTooDeep
+	}
+
+	for _, other := range r.comments {
+		if isAncestorPath(oldPath, other.Path) {
+			other.Path = newPath + strings.TrimPrefix(other.Path, oldPath)
+			other.ThreadKey = threadKey
+		}
+	}
+	if parentID != nil {
+		pid := *parentID
+		c.ParentID = &pid
+	} else {
+		c.ParentID = nil
+	}
+	return nil
+}
+
+func (r *MemoryRepository) Export(ctx context.Context, key string) (*e.Thread, []e.Comments, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	comments := []e.Comments{}
+	for _, c := range r.comments {
+		if c.ThreadKey == key {
+			comments = append(comments, *c)
+		}
+	}
+	if len(comments) == 0 && !r.threads[key] {
+		return nil, nil, ErrThreadNotFound
+	}
+
+	sort.Slice(comments, func(i, j int) bool { return comments[i].Path < comments[j].Path })
+	for i := range comments {
+		comments[i].Depth = pathDepth(comments[i].Path)
+	}
+	return &e.Thread{ThreadKey: key, Closed: r.closed[key]}, comments, nil
+}
+
+func (r *MemoryRepository) Import(ctx context.Context, t *e.Thread, comments []e.Comments, mentions map[uuid.UUID][]uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range comments {
+		if _, ok := r.comments[c.CommentID]; ok {
+			return fmt.Errorf("%w: %s", ErrImportConflict, c.CommentID)
+		}
+		if r.maxDepth > 0 && pathDepth(c.Path) > r.maxDepth {
+			return ErrTooDeep
+		}
+	}
+
+	r.threads[t.ThreadKey] = true
+	r.closed[t.ThreadKey] = t.Closed
+	for _, c := range comments {
+		stored := c
+		stored.ThreadKey = t.ThreadKey
+		r.comments[c.CommentID] = &stored
+		if len(mentions[c.CommentID]) > 0 {
+			r.mentions[c.CommentID] = append([]uuid.UUID(nil), mentions[c.CommentID]...)
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) Edit(ctx context.Context, id uuid.UUID, body, html string, mentions []uuid.UUID, status, reason string, check func(c *e.Comments) error) (*e.Comments, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.comments[id]
+	if !ok {
+		return nil, ErrCommentNotFound
+	}
+	old := clone(c)
+	if err := check(&old); err != nil {
+		return nil, err
+	}
+
+	now := r.Now()
+	r.addRevision(c, now)
+	c.Comment, c.HTML, c.EditedAt = body, html, &now
+	if status != "" {
+		c.Status, c.StatusReason = status, reason
+	}
+	r.setMentions(id, mentions)
+
+	out := clone(c)
+	return &out, nil
+}
+
+func (r *MemoryRepository) SoftDelete(ctx context.Context, id uuid.UUID, body, html string, check func(c *e.Comments) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.comments[id]
+	if !ok {
+		return ErrCommentNotFound
+	}
+	old := clone(c)
+	if err := check(&old); err != nil {
+		return err
+	}
+	if c.Deleted {
+		return nil
+	}
+
+	r.addRevision(c, r.Now())
+	c.Comment, c.HTML, c.Deleted = body, html, true
+	r.setMentions(id, nil)
+	return nil
+}
+
+func (r *MemoryRepository) Revisions(ctx context.Context, id uuid.UUID) ([]e.Revision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]e.Revision{}, r.revisions[id]...), nil
+}
+
+func (r *MemoryRepository) Vote(ctx context.Context, id, userID uuid.UUID, value int) (*e.Comments, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, err := r.votable(id)
+	if err != nil {
+		return nil, err
+	}
+
+	key := voteKey{CommentID: id, UserID: userID}
+	old := r.votes[key]
+	if value == 0 {
+		delete(r.votes, key)
+	} else {
+		r.votes[key] = value
+	}
+	c.Upvotes += btoi(value == 1) - btoi(old == 1)
+	c.Downvotes += btoi(value == -1) - btoi(old == -1)
+	c.Score = c.Upvotes - c.Downvotes
+
+	out := clone(c)
+	return &out, nil
+}
+
+func (r *MemoryRepository) React(ctx context.Context, id, userID uuid.UUID, emoji string) (*e.Comments, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, err := r.votable(id)
+	if err != nil {
+		return nil, err
+	}
+
+	key := voteKey{CommentID: id, UserID: userID}
+	old := r.reactions[key]
+	if old != emoji {
+		counts := e.ReactionCounts{}
+		for k, n := range c.Reactions {
+			counts[k] = n
+		}
+		if old != "" {
+			if counts[old]--; counts[old] <= 0 {
+				delete(counts, old)
+			}
+		}
+		if emoji == "" {
+			delete(r.reactions, key)
+		} else {
+			r.reactions[key] = emoji
+			counts[emoji]++
+		}
+		c.Reactions = counts
+	}
+
+	out := clone(c)
+	return &out, nil
+}
+
+// votable returns a published comment that is not deleted. Must be called
+// with mu held.
+func (r *MemoryRepository) votable(id uuid.UUID) (*e.Comments, error) {
+	c, ok := r.comments[id]
+	if !ok || c.Status != e.StatusApproved {
+		return nil, ErrCommentNotFound
+	}
+	if c.Deleted {
+		return nil, ErrCommentDeleted
+	}
+	return c, nil
+}
+
+func (r *MemoryRepository) SetHidden(ctx context.Context, id uuid.UUID, hidden bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.comments[id]
+	if !ok {
+		return ErrCommentNotFound
+	}
+	c.Hidden = hidden
+	return nil
+}
+
+func (r *MemoryRepository) SetLocked(ctx context.Context, id uuid.UUID, locked bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.comments[id]
+	if !ok {
+		return ErrCommentNotFound
+	}
+	c.Locked = locked
+	return nil
+}
+
+func (r *MemoryRepository) Pending(ctx context.Context, p Page) ([]e.Comments, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := []e.Comments{}
+	for _, c := range r.comments {
+		if c.Status == e.StatusPending {
+			out := clone(c)
+			out.Depth = pathDepth(c.Path)
+			list = append(list, out)
+		}
+	}
+	return cut(list, Page{Sort: SortOldest, After: p.After, Limit: p.Limit}), nil
+}
+
+func (r *MemoryRepository) Resolve(ctx context.Context, id uuid.UUID, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.comments[id]
+	if !ok {
+		return ErrCommentNotFound
+	}
+	if c.Status != e.StatusPending {
+		return ErrNotPending
+	}
+	c.Status = status
+	return nil
+}
+
+func (r *MemoryRepository) Notifications(ctx context.Context, userID uuid.UUID, p Page) ([]e.Notification, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	kinds := map[uuid.UUID]string{}
+	list := []e.Comments{}
+	for _, c := range r.comments {
+		if c.Status != e.StatusApproved || c.Deleted || c.Hidden || isAuthor(c, userID) {
+			continue
+		}
+		switch {
+		case c.ParentID != nil && isAuthor(r.comments[*c.ParentID], userID):
+			kinds[c.CommentID] = e.NotifyReply
+		case slices.Contains(r.mentions[c.CommentID], userID):
+			kinds[c.CommentID] = e.NotifyMention
+		default:
+			continue
+		}
+		out := clone(c)
+		out.Depth = pathDepth(c.Path)
+		list = append(list, out)
+	}
+
+	out := []e.Notification{}
+	for _, c := range cut(list, Page{Sort: SortNewest, After: p.After, Limit: p.Limit}) {
+		out = append(out, e.Notification{Kind: kinds[c.CommentID], Comments: c})
+	}
+	return out, nil
+}
+
+func isAuthor(c *e.Comments, userID uuid.UUID) bool {
+	return c != nil && c.AuthorID != nil && *c.AuthorID == userID
+}
+
+func (r *MemoryRepository) Thread(ctx context.Context, key string) (*e.Thread, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if !r.threads[key] {
+		return nil, ErrThreadNotFound
+	}
+	t := &e.Thread{ThreadKey: key, Closed: r.closed[key]}
+	for _, c := range r.comments {
+		if c.ThreadKey == key && c.Status == e.StatusApproved {
+			t.CommentCount++
+			if c.ParentID == nil {
+				t.RootCount++
+			}
+		}
+	}
+	return t, nil
+}
+
+func (r *MemoryRepository) ThreadCounts(ctx context.Context, keys []string) (map[string]int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := map[string]int{}
+	for _, c := range r.comments {
+		if c.Status == e.StatusApproved && slices.Contains(keys, c.ThreadKey) {
+			counts[c.ThreadKey]++
+		}
+	}
+	return counts, nil
+}
+
+func (r *MemoryRepository) SetThreadClosed(ctx context.Context, key string, closed bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.threads[key] = true
+	r.closed[key] = closed
+	return nil
+}
+
+// Search matches comments that contain every word of the query, ignoring
+// case, newest first. It has no ranking or word forms.
+func (r *MemoryRepository) Search(ctx context.Context, sq e.SearchQuery) ([]e.SearchHit, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	words := strings.Fields(strings.ToLower(sq.Query))
+	hits := []e.SearchHit{}
+	for _, c := range r.comments {
+		if c.Status != e.StatusApproved || c.Deleted || c.Hidden {
+			continue
+		}
+		if sq.Author != "" && !strings.EqualFold(c.UserName, sq.Author) {
+			continue
+		}
+		if sq.ThreadKey != "" && c.ThreadKey != sq.ThreadKey {
+			continue
+		}
+		if (sq.From != nil && c.Date.Before(*sq.From)) || (sq.To != nil && !c.Date.Before(*sq.To)) {
+			continue
+		}
+		text := strings.ToLower(c.UserName + " " + c.Comment)
+		if !allContained(text, words) {
+			continue
+		}
+
+		h := e.SearchHit{Comments: clone(c), Rank: 1, Snippet: c.Comment}
+		h.Depth = pathDepth(c.Path)
+		for _, w := range words {
+			re := regexp.MustCompile("(?i)" + regexp.QuoteMeta(w))
+			h.Snippet = re.ReplaceAllString(h.Snippet, "<mark>$0</mark>")
+		}
+		rootLabel, _, _ := strings.Cut(c.Path, ".")
+		for _, root := range r.comments {
+			if root.Path == rootLabel {
+				h.RootID, h.RootUserName, h.RootComment = root.CommentID, root.UserName, root.Comment
+			}
+		}
+		hits = append(hits, h)
+	}
+
+	sort.Slice(hits, func(i, j int) bool { return less(SortNewest, &hits[i].Comments, &hits[j].Comments) })
+	offset := min(sq.Offset, len(hits))
+	hits = hits[offset:]
+	if sq.Limit > 0 && len(hits) > sq.Limit {
+		hits = hits[:sq.Limit]
+	}
+	return hits, nil
+}
+
+func allContained(text string, words []string) bool {
+	for _, w := range words {
+		if !strings.Contains(text, w) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *MemoryRepository) UsersByName(ctx context.Context, names []string) ([]e.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var users []e.User
+	for _, u := range r.users {
+		if slices.Contains(names, strings.ToLower(u.UserName)) {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+func (r *MemoryRepository) Banned(ctx context.Context, userID uuid.UUID) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	u, ok := r.users[userID]
+	if !ok {
+		return false, ErrUserNotFound
+	}
+	return u.Banned, nil
+}
+
+// AddUser and SetStatus stand in for registration and for the filters
+// holding a comment, which are not part of the repository.
+func (r *MemoryRepository) AddUser(u e.User) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users[u.UserID] = u
+}
+
+func (r *MemoryRepository) SetStatus(id uuid.UUID, status string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.comments[id]; ok {
+		c.Status = status
+	}
+}
+
+// Mentions returns the users recorded as mentioned by a comment.
+func (r *MemoryRepository) Mentions(id uuid.UUID) []uuid.UUID {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]uuid.UUID(nil), r.mentions[id]...)
+}
+
+// page filters published comments with match, orders them like the SQL
+// ORDER BY of p.Sort and cuts out one page.
+func (r *MemoryRepository) page(p Page, depth int, match func(c *e.Comments) bool) []e.Comments {
+	var list []e.Comments
+	for _, c := range r.comments {
+		if c.Status == e.StatusApproved && match(c) {
+			list = append(list, r.view(c, depth))
+		}
+	}
+	return cut(list, p)
+}
+
+// cut orders comments by p.Sort and returns the page p selects.
+func cut(list []e.Comments, p Page) []e.Comments {
+	sort.Slice(list, func(i, j int) bool { return less(p.Sort, &list[i], &list[j]) })
+
+	if p.Sort.keyset() && p.After != nil {
+		i := sort.Search(len(list), func(i int) bool {
+			return less(p.Sort, &e.Comments{Date: p.After.Date, CommentID: p.After.ID}, &list[i])
+		})
+		list = list[i:]
+	}
+
+	offset := p.offset()
+	if offset > len(list) {
+		offset = len(list)
+	}
+	list = list[offset:]
+	if p.Limit > 0 && len(list) > p.Limit {
+		list = list[:p.Limit]
+	}
+	return append([]e.Comments{}, list...)
+}
+
+// addRevision keeps the current body of c as a revision. Must be called
+// with mu held.
+func (r *MemoryRepository) addRevision(c *e.Comments, date time.Time) {
+	r.revisions[c.CommentID] = append(r.revisions[c.CommentID], e.Revision{
+		RevisionID: uuid.New(),
+		CommentID:  c.CommentID,
+		Comment:    c.Comment,
+		Date:       date,
+	})
+}
+
+// setMentions replaces the recorded mentions of a comment. Must be called
+// with mu held.
+func (r *MemoryRepository) setMentions(id uuid.UUID, users []uuid.UUID) {
+	if len(users) == 0 {
+		delete(r.mentions, id)
+		return
+	}
+	r.mentions[id] = append([]uuid.UUID(nil), users...)
+}
+
+// clone copies a stored comment so callers cannot change it through the
+// reactions map.
+func clone(c *e.Comments) e.Comments {
+	out := *c
+	out.Reactions = maps.Clone(c.Reactions)
+	return out
+}
+
+// view copies a stored comment with the computed read fields set. Must be
+// called with mu held.
+func (r *MemoryRepository) view(c *e.Comments, depth int) e.Comments {
+	out := clone(c)
+	out.Depth = depth
+	out.ReplyCount = 0
+	for _, other := range r.comments {
+		if other.ParentID != nil && *other.ParentID == c.CommentID && other.Status == e.StatusApproved {
+			out.ReplyCount++
+		}
+	}
+	return out
+}
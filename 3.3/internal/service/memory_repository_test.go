@@ -0,0 +1,298 @@
+package service
+
+import (
+	e "commentTree/internal/entity"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newTestRepo returns a repository whose clock advances by a second on
+// every insert, so comments are ordered by the order they were posted in.
+func newTestRepo(maxDepth int) *MemoryRepository {
+	repo := NewMemoryRepository(maxDepth)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo.Now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	return repo
+}
+
+func post(t *testing.T, repo *MemoryRepository, parent *e.Comments, text string) *e.Comments {
+	t.Helper()
+	c := &e.Comments{UserName: "user", Comment: text, ThreadKey: DefaultThread, Status: e.StatusApproved}
+	if parent != nil {
+		c.ParentID = &parent.CommentID
+	}
+	if err := repo.Insert(context.Background(), c, nil); err != nil {
+		t.Fatalf("insert %q: %v", text, err)
+	}
+	return c
+}
+
+func TestInsertSetsPathAndThread(t *testing.T) {
+	repo := newTestRepo(0)
+	root := &e.Comments{Comment: "root", ThreadKey: "post-1", Status: e.StatusApproved}
+	if err := repo.Insert(context.Background(), root, nil); err != nil {
+		t.Fatal(err)
+	}
+	reply := post(t, repo, root, "reply")
+
+	if root.CommentID == uuid.Nil || root.Date.IsZero() {
+		t.Fatalf("insert did not fill in ID and date: %+v", root)
+	}
+	if got := parentPath(reply.Path); got != root.Path {
+		t.Errorf("reply path %q is not below %q", reply.Path, root.Path)
+	}
+	if reply.ThreadKey != "post-1" {
+		t.Errorf("reply thread = %q, want the thread of its parent", reply.ThreadKey)
+	}
+}
+
+func TestInsertUnknownParent(t *testing.T) {
+	repo := newTestRepo(0)
+	missing := uuid.New()
+	c := &e.Comments{Comment: "orphan", Status: e.StatusApproved, ParentID: &missing}
+
+	if err := repo.Insert(context.Background(), c, nil); !errors.Is(err, ErrParentNotFound) {
+		t.Fatalf("err = %v, want ErrParentNotFound", err)
+	}
+	if _, err := repo.Parent(context.Background(), missing); !errors.Is(err, ErrParentNotFound) {
+		t.Fatalf("Parent err = %v, want ErrParentNotFound", err)
+	}
+}
+
+func TestInsertUnderPendingParent(t *testing.T) {
+	repo := newTestRepo(0)
+	root := post(t, repo, nil, "root")
+	repo.SetStatus(root.CommentID, e.StatusPending)
+
+	c := &e.Comments{Comment: "reply", Status: e.StatusApproved, ParentID: &root.CommentID}
+	if err := repo.Insert(context.Background(), c, nil); !errors.Is(err, ErrParentUnavailable) {
+		t.Fatalf("err = %v, want ErrParentUnavailable", err)
+	}
+}
+
+func TestInsertDepthLimit(t *testing.T) {
+	repo := newTestRepo(2)
+	root := post(t, repo, nil, "root")
+	one := post(t, repo, root, "one")
+	two := post(t, repo, one, "two")
+
+	c := &e.Comments{Comment: "three", Status: e.StatusApproved, ParentID: &two.CommentID}
+	if err := repo.Insert(context.Background(), c, nil); !errors.Is(err, ErrTooDeep) {
+		t.Fatalf("err = %v, want ErrTooDeep", err)
+	}
+}
+
+func TestParentLockedAndClosed(t *testing.T) {
+	repo := newTestRepo(0)
+	root := post(t, repo, nil, "root")
+	reply := post(t, repo, root, "reply")
+
+	repo.SetLocked(context.Background(), root.CommentID, true)
+	p, err := repo.Parent(context.Background(), reply.CommentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Locked || p.Depth != 1 {
+		t.Errorf("parent = %+v, want locked at depth 1", p)
+	}
+
+	repo.SetThreadClosed(context.Background(), DefaultThread, true)
+	if err := repo.OpenThread(context.Background(), DefaultThread); !errors.Is(err, ErrThreadClosed) {
+		t.Errorf("OpenThread err = %v, want ErrThreadClosed", err)
+	}
+	if p, _ := repo.Parent(context.Background(), reply.CommentID); !p.Closed {
+		t.Error("parent in a closed thread is not marked closed")
+	}
+}
+
+func TestInsertRecordsMentions(t *testing.T) {
+	repo := newTestRepo(0)
+	user := uuid.New()
+	c := &e.Comments{Comment: "hi @bob", Status: e.StatusApproved}
+	if err := repo.Insert(context.Background(), c, []uuid.UUID{user}); err != nil {
+		t.Fatal(err)
+	}
+	if got := repo.Mentions(c.CommentID); len(got) != 1 || got[0] != user {
+		t.Errorf("mentions = %v, want [%v]", got, user)
+	}
+}
+
+func TestSubtreeOrderAndDepth(t *testing.T) {
+	repo := newTestRepo(0)
+	root := post(t, repo, nil, "root")
+	a := post(t, repo, root, "a")
+	b := post(t, repo, root, "b")
+	a1 := post(t, repo, a, "a1")
+	post(t, repo, a1, "a1x")
+
+	list, err := repo.Subtree(context.Background(), root.CommentID, 0, 0, SortOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 4 {
+		t.Fatalf("got %d comments, want 4", len(list))
+	}
+	seen := map[string]bool{root.Path: true}
+	for _, c := range list {
+		if !seen[parentPath(c.Path)] {
+			t.Errorf("%q comes before its parent", c.Comment)
+		}
+		seen[c.Path] = true
+	}
+
+	limited, err := repo.Subtree(context.Background(), root.CommentID, 1, 0, SortOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limited) != 2 {
+		t.Fatalf("depth 1: got %d comments, want 2", len(limited))
+	}
+	for _, c := range limited {
+		if c.Depth != 1 {
+			t.Errorf("%q has depth %d, want 1", c.Comment, c.Depth)
+		}
+		if c.CommentID == a.CommentID && c.ReplyCount != 1 {
+			t.Errorf("a has %d replies, want 1", c.ReplyCount)
+		}
+		if c.CommentID == b.CommentID && c.ReplyCount != 0 {
+			t.Errorf("b has %d replies, want 0", c.ReplyCount)
+		}
+	}
+}
+
+func TestSubtreeSkipsUnpublished(t *testing.T) {
+	repo := newTestRepo(0)
+	root := post(t, repo, nil, "root")
+	held := post(t, repo, root, "held")
+	repo.SetStatus(held.CommentID, e.StatusPending)
+
+	list, err := repo.Subtree(context.Background(), root.CommentID, 0, 0, SortOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 0 {
+		t.Errorf("got %d comments, want none", len(list))
+	}
+	if _, err := repo.Subtree(context.Background(), held.CommentID, 0, 0, SortOldest); !errors.Is(err, ErrCommentNotFound) {
+		t.Errorf("subtree of a held comment: err = %v, want ErrCommentNotFound", err)
+	}
+}
+
+func TestDeleteRemovesSubtree(t *testing.T) {
+	repo := newTestRepo(0)
+	root := post(t, repo, nil, "root")
+	a := post(t, repo, root, "a")
+	a1 := post(t, repo, a, "a1")
+	b := post(t, repo, root, "b")
+
+	if err := repo.Delete(context.Background(), a.CommentID); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*e.Comments{a, a1} {
+		if _, err := repo.Get(context.Background(), c.CommentID); !errors.Is(err, ErrCommentNotFound) {
+			t.Errorf("%q still there after deleting its subtree", c.Comment)
+		}
+	}
+	if _, err := repo.Get(context.Background(), b.CommentID); err != nil {
+		t.Errorf("sibling was deleted too: %v", err)
+	}
+	if err := repo.Delete(context.Background(), a.CommentID); !errors.Is(err, ErrCommentNotFound) {
+		t.Errorf("second delete: err = %v, want ErrCommentNotFound", err)
+	}
+}
+
+func TestMoveRewritesSubtree(t *testing.T) {
+	repo := newTestRepo(0)
+	a := post(t, repo, nil, "a")
+	a1 := post(t, repo, a, "a1")
+	a1x := post(t, repo, a1, "a1x")
+	b := &e.Comments{Comment: "b", ThreadKey: "other", Status: e.StatusApproved}
+	if err := repo.Insert(context.Background(), b, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Move(context.Background(), a1.CommentID, &b.CommentID, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := repo.Get(context.Background(), a1.CommentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *moved.ParentID != b.CommentID || parentPath(moved.Path) != b.Path || moved.ThreadKey != "other" {
+		t.Errorf("moved comment = %+v, want it below b in thread other", moved)
+	}
+	child, err := repo.Get(context.Background(), a1x.CommentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parentPath(child.Path) != moved.Path || child.ThreadKey != "other" || child.Depth != 2 {
+		t.Errorf("reply did not follow: %+v", child)
+	}
+	if list, _ := repo.Subtree(context.Background(), a.CommentID, 0, 0, SortOldest); len(list) != 0 {
+		t.Errorf("old parent still has %d comments below it", len(list))
+	}
+}
+
+func TestMoveToRoot(t *testing.T) {
+	repo := newTestRepo(0)
+	a := post(t, repo, nil, "a")
+	a1 := post(t, repo, a, "a1")
+
+	if err := repo.Move(context.Background(), a1.CommentID, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	moved, err := repo.Get(context.Background(), a1.CommentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.ParentID != nil || moved.Depth != 0 || moved.ThreadKey != DefaultThread {
+		t.Errorf("moved comment = %+v, want a root of the same thread", moved)
+	}
+}
+
+func TestMoveRefusesCyclesAndDepth(t *testing.T) {
+	repo := newTestRepo(3)
+	a := post(t, repo, nil, "a")
+	a1 := post(t, repo, a, "a1")
+	a1x := post(t, repo, a1, "a1x")
+	b := post(t, repo, nil, "b")
+	b1 := post(t, repo, b, "b1")
+	b1x := post(t, repo, b1, "b1x")
+
+	if err := repo.Move(context.Background(), a.CommentID, &a1x.CommentID, ""); !errors.Is(err, ErrInvalidParent) {
+		t.Errorf("move below own reply: err = %v, want ErrInvalidParent", err)
+	}
+	// a1 with its reply would end up at depths 3 and 4.
+	if err := repo.Move(context.Background(), a1.CommentID, &b1x.CommentID, ""); !errors.Is(err, ErrTooDeep) {
+		t.Errorf("move past the depth limit: err = %v, want ErrTooDeep", err)
+	}
+	missing := uuid.New()
+	if err := repo.Move(context.Background(), a1.CommentID, &missing, ""); !errors.Is(err, ErrParentNotFound) {
+		t.Errorf("move below unknown parent: err = %v, want ErrParentNotFound", err)
+	}
+}
+
+func TestSubtreePerNode(t *testing.T) {
+	repo := newTestRepo(0)
+	root := post(t, repo, nil, "root")
+	a := post(t, repo, root, "a")
+	b := post(t, repo, root, "b")
+	post(t, repo, a, "a1")
+	post(t, repo, b, "b1")
+
+	list, err := repo.Subtree(context.Background(), root.CommentID, 0, 1, SortNewest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b", "b1"}; !equal(texts(list), want) {
+		t.Errorf("got %v, want %v", texts(list), want)
+	}
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	e "commentTree/internal/entity"
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// render converts a comment body to HTML and returns the users it mentions.
+func (s *CommentsService) render(ctx context.Context, body string) (string, []uuid.UUID, error) {
+	ids := map[string]uuid.UUID{}
+	res, err := s.md.Render(body, func(names []string) (map[string]string, error) {
+		users, err := s.repo.UsersByName(ctx, names)
+		if err != nil {
+			return nil, err
+		}
+
+		known := map[string]string{}
+		for _, u := range users {
+			known[strings.ToLower(u.UserName)] = u.UserName
+			ids[u.UserName] = u.UserID
+		}
+		return known, nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	mentioned := make([]uuid.UUID, 0, len(res.Mentions))
+	for _, name := range res.Mentions {
+		mentioned = append(mentioned, ids[name])
+	}
+	return res.HTML, mentioned, nil
+}
+
+// GetNotifications returns, newest first, published replies to comments of
+// u and comments that mention u. A user's own comments are left out.
+func (s *CommentsService) GetNotifications(ctx context.Context, u *e.User, after string, limit int) (*e.NotificationsPage, error) {
+	if u == nil {
+		return nil, ErrForbidden
+	}
+	cur, err := decodeCursor(after)
+	if err != nil {
+		return nil, err
+	}
+	limit = normalizeLimit(limit)
+
+	list, err := s.repo.Notifications(ctx, u.UserID, Page{Sort: SortNewest, After: cur, Limit: limit + 1})
+	if err != nil {
+		return nil, err
+	}
+
+	page := &e.NotificationsPage{Notifications: append([]e.Notification{}, list...)}
+	if len(page.Notifications) > limit {
+		page.Notifications = page.Notifications[:limit]
+		last := page.Notifications[limit-1]
+		page.NextCursor = encodeCursor(last.Date, last.CommentID)
+	}
+	return page, nil
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	e "commentTree/internal/entity"
+	"commentTree/internal/markdown"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrThreadLocked = errors.New("thread is locked")
+	ErrRejected     = errors.New("comment rejected")
+	ErrNotPending   = errors.New("comment is not awaiting moderation")
+)
+
+// HiddenPlaceholder replaces the body of hidden comments for everyone but
+// moderators.
+const HiddenPlaceholder = "[hidden by moderator]"
+
+func (s *CommentsService) SetHidden(ctx context.Context, stringID string, hidden bool) error {
+	id, err := uuid.Parse(stringID)
+	if err != nil {
+		return fmt.Errorf("%w: comment ID: %v", ErrInvalidInput, err)
+	}
+	return s.repo.SetHidden(ctx, id, hidden)
+}
+
+// SetLocked locks a comment for new replies. The lock covers the whole
+// subtree below it, so locking a root comment locks the thread.
+func (s *CommentsService) SetLocked(ctx context.Context, stringID string, locked bool) error {
+	id, err := uuid.Parse(stringID)
+	if err != nil {
+		return fmt.Errorf("%w: comment ID: %v", ErrInvalidInput, err)
+	}
+	return s.repo.SetLocked(ctx, id, locked)
+}
+
+// GetQueue returns one page of comments held for moderation, oldest first.
+func (s *CommentsService) GetQueue(ctx context.Context, after string, limit int) (*e.CommentsPage, error) {
+	cur, err := decodeCursor(after)
+	if err != nil {
+		return nil, err
+	}
+	limit = normalizeLimit(limit)
+
+	list, err := s.repo.Pending(ctx, Page{Sort: SortOldest, After: cur, Limit: limit + 1})
+	if err != nil {
+		return nil, err
+	}
+	return makePage(list, limit, SortOldest, 0), nil
+}
+
+// Approve publishes a held comment. Reject keeps it out of every listing
+// but leaves the row for the record.
+func (s *CommentsService) Approve(ctx context.Context, stringID string) error {
+	return s.resolvePending(ctx, stringID, e.StatusApproved)
+}
+
+func (s *CommentsService) Reject(ctx context.Context, stringID string) error {
+	return s.resolvePending(ctx, stringID, e.StatusRejected)
+}
+
+func (s *CommentsService) resolvePending(ctx context.Context, stringID string, status string) error {
+	id, err := uuid.Parse(stringID)
+	if err != nil {
+		return fmt.Errorf("%w: comment ID: %v", ErrInvalidInput, err)
+	}
+	return s.repo.Resolve(ctx, id, status)
+}
+
+func (s *CommentsService) checkNotBanned(ctx context.Context, u *e.User) error {
+	if u == nil {
+		return ErrForbidden
+	}
+
+	banned, err := s.repo.Banned(ctx, u.UserID)
+	if err != nil {
+		return err
+	}
+	if banned {
+		return ErrUserBanned
+	}
+	return nil
+}
+
+// MaskHidden replaces the bodies of hidden comments unless the viewer is a
+// moderator.
+func MaskHidden(comments []e.Comments, viewer *e.User) {
+	if viewer != nil && viewer.Role == e.RoleModerator {
+		return
+	}
+	for i := range comments {
+		if comments[i].Hidden {
+			comments[i].Comment = HiddenPlaceholder
+			comments[i].HTML = markdown.Plain(HiddenPlaceholder)
+		}
+	}
+}
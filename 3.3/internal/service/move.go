@@ -0,0 +1,42 @@
+package service
+
+import (
+	e "commentTree/internal/entity"
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// MoveComment puts a comment with all of its replies under another parent
+// or makes it a root comment. It is a moderator tool, so locks and closed
+// threads do not stop it.
+func (s *CommentsService) MoveComment(ctx context.Context, stringID string, m e.MoveRequest) error {
+	id, err := uuid.Parse(stringID)
+	if err != nil {
+		return fmt.Errorf("%w: comment ID: %v", ErrInvalidInput, err)
+	}
+
+	if m.ParentID == uuid.Nil {
+		if m.ThreadKey != "" {
+			if err := validThreadKey(m.ThreadKey); err != nil {
+				return err
+			}
+		}
+		return s.repo.Move(ctx, id, nil, m.ThreadKey)
+	}
+
+	if m.ParentID == id {
+		return fmt.Errorf("%w: a comment cannot be moved below itself", ErrInvalidParent)
+	}
+	if m.ThreadKey != "" {
+		parent, err := s.repo.Parent(ctx, m.ParentID)
+		if err != nil {
+			return err
+		}
+		if parent.ThreadKey != m.ThreadKey {
+			return fmt.Errorf("%w: it belongs to another thread", ErrInvalidParent)
+		}
+	}
+	return s.repo.Move(ctx, id, &m.ParentID, "")
+}
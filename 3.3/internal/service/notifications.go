@@ -0,0 +1,340 @@
+package service
+
+import (
+	e "commentTree/internal/entity"
+	"commentTree/internal/notify"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// dispatchBatch is how many notifications one dispatch pass sends at most.
+const dispatchBatch = 50
+
+// dispatchLease is how long a claimed batch is reserved for its
+// dispatcher. Senders give up after notify.SendTimeout, so it covers a
+// batch of sends that all run into it.
+const dispatchLease = 15 * time.Minute
+
+var ErrInvalidEmail = errors.New("invalid e-mail address")
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// enqueueNotifications queues notifications for a comment that has just
+// been published: to the author of the parent, to mentioned users and to
+// subscribers of the thread. Everyone gets at most one notification per
+// comment and nobody is notified about their own comment.
+func enqueueNotifications(ctx context.Context, ex execer, commentID uuid.UUID) error {
+	_, err := ex.ExecContext(ctx, `
+		INSERT INTO notification_outbox (user_id, comment_id, kind)
+		SELECT DISTINCT ON (r.user_id) r.user_id, c.comment_id, r.kind
+		FROM comments c
+		JOIN (
+			SELECT p.author_id AS user_id, 'reply' AS kind, 1 AS prio
+			FROM comments c JOIN comments p ON p.comment_id = c.parent_id
+			WHERE c.comment_id = $1
+			UNION ALL
+			SELECT user_id, 'mention', 2 FROM comment_mentions WHERE comment_id = $1
+			UNION ALL
+			SELECT s.user_id, 'thread', 3
+			FROM comments c JOIN thread_subscriptions s ON s.thread_key = c.thread_key
+			WHERE c.comment_id = $1
+		) r ON r.user_id IS NOT NULL AND r.user_id IS DISTINCT FROM c.author_id
+		LEFT JOIN notification_settings ns ON ns.user_id = r.user_id
+		WHERE c.comment_id = $1
+		  AND CASE r.kind
+		          WHEN 'reply' THEN COALESCE(ns.replies, TRUE)
+		          WHEN 'mention' THEN COALESCE(ns.mentions, TRUE)
+		          ELSE TRUE
+		      END
+		ORDER BY r.user_id, r.prio
+		ON CONFLICT DO NOTHING;
+	`, commentID)
+	return err
+}
+
+// NotificationsService manages notification preferences and delivers the
+// queued notifications through a notify.Sender.
+type NotificationsService struct {
+	db     *sql.DB
+	sender notify.Sender
+	secret []byte
+	cfg    e.NotifyConfig
+}
+
+func NewNotificationsService(db *sql.DB, sender notify.Sender, secret []byte, cfg e.NotifyConfig) *NotificationsService {
+	return &NotificationsService{
+		db:     db,
+		sender: sender,
+		secret: secret,
+		cfg:    cfg,
+	}
+}
+
+func (s *NotificationsService) GetSettings(ctx context.Context, u *e.User) (*e.NotificationSettings, error) {
+	if u == nil {
+		return nil, ErrForbidden
+	}
+
+	st := &e.NotificationSettings{Replies: true, Mentions: true, Threads: []string{}}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT email, replies, mentions FROM notification_settings WHERE user_id = $1;
+	`, u.UserID).Scan(&st.Email, &st.Replies, &st.Mentions)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT thread_key FROM thread_subscriptions WHERE user_id = $1 ORDER BY thread_key;
+	`, u.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		st.Threads = append(st.Threads, key)
+	}
+	return st, rows.Err()
+}
+
+// UpdateSettings stores the e-mail address and the reply and mention
+// switches. Thread subscriptions are changed with SubscribeThread.
+func (s *NotificationsService) UpdateSettings(ctx context.Context, u *e.User, st e.NotificationSettings) (*e.NotificationSettings, error) {
+	if u == nil {
+		return nil, ErrForbidden
+	}
+	st.Email = strings.TrimSpace(st.Email)
+	if st.Email != "" {
+		addr, err := mail.ParseAddress(st.Email)
+		if err != nil || addr.Name != "" {
+			return nil, ErrInvalidEmail
+		}
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO notification_settings (user_id, email, replies, mentions)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET email = EXCLUDED.email, replies = EXCLUDED.replies, mentions = EXCLUDED.mentions;
+	`, u.UserID, st.Email, st.Replies, st.Mentions)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetSettings(ctx, u)
+}
+
+// SubscribeThread follows or unfollows every new comment in a thread.
+func (s *NotificationsService) SubscribeThread(ctx context.Context, u *e.User, key string, subscribed bool) error {
+	if u == nil {
+		return ErrForbidden
+	}
+	if err := validThreadKey(key); err != nil {
+		return err
+	}
+
+	if !subscribed {
+		_, err := s.db.ExecContext(ctx, `
+			DELETE FROM thread_subscriptions WHERE thread_key = $1 AND user_id = $2;
+		`, key, u.UserID)
+		return err
+	}
+
+	// A thread may be followed before its first comment.
+	_, err := s.db.ExecContext(ctx, "INSERT INTO threads (thread_key) VALUES ($1) ON CONFLICT DO NOTHING", key)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO thread_subscriptions (thread_key, user_id) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING;
+	`, key, u.UserID)
+	return err
+}
+
+// Unsubscribe applies a signed unsubscribe link and returns its scope.
+func (s *NotificationsService) Unsubscribe(ctx context.Context, token string) (string, error) {
+	userID, scope, err := notify.ParseUnsubscribeToken(s.secret, token)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case scope == notify.ScopeReplies, scope == notify.ScopeMentions:
+		_, err = s.db.ExecContext(ctx, `
+			INSERT INTO notification_settings (user_id, replies, mentions)
+			SELECT user_id, $2, $3 FROM users WHERE user_id = $1
+			ON CONFLICT (user_id) DO UPDATE
+			SET replies = notification_settings.replies AND EXCLUDED.replies,
+			    mentions = notification_settings.mentions AND EXCLUDED.mentions;
+		`, userID, scope != notify.ScopeReplies, scope != notify.ScopeMentions)
+	case strings.HasPrefix(scope, notify.ScopeThread):
+		_, err = s.db.ExecContext(ctx, `
+			DELETE FROM thread_subscriptions WHERE thread_key = $1 AND user_id = $2;
+		`, strings.TrimPrefix(scope, notify.ScopeThread), userID)
+	default:
+		return "", notify.ErrInvalidToken
+	}
+	if err != nil {
+		return "", err
+	}
+	return scope, nil
+}
+
+// Run delivers queued notifications every cfg.Interval until ctx is
+// cancelled.
+func (s *NotificationsService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Keep going while full batches get through; failures wait
+			// for the next tick.
+			for {
+				n, err := s.Dispatch(ctx)
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Printf("dispatch notifications: %v", err)
+					}
+					break
+				}
+				if n < dispatchBatch {
+					break
+				}
+			}
+		}
+	}
+}
+
+type pendingNotification struct {
+	id       uuid.UUID
+	attempts int
+	msg      notify.Message
+}
+
+// Dispatch sends one batch of queued notifications and returns how many
+// were delivered. The batch is claimed with a lease and an attempt before
+// anything is sent, so several instances can dispatch at the same time and
+// no lock is held during the sends. Each result is recorded as it comes in;
+// a dispatcher that dies mid-batch only leaves its unrecorded rows to be
+// sent again once the lease runs out. Failed sends are retried on the next
+// pass until cfg.MaxAttempts is reached.
+func (s *NotificationsService) Dispatch(ctx context.Context) (int, error) {
+	batch, err := s.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, p := range batch {
+		c := p.msg.Comment
+		var sendErr error
+		attempts := p.attempts
+		if c.Status != e.StatusApproved || c.Deleted || c.Hidden {
+			// The comment was taken down before we got to it.
+			sendErr = errors.New("comment is no longer visible")
+			attempts = s.cfg.MaxAttempts
+		} else {
+			p.msg.Link = s.cfg.BaseURL + "/?thread=" + url.QueryEscape(c.ThreadKey)
+			p.msg.UnsubscribeURL = s.cfg.BaseURL + "/notifications/unsubscribe?token=" +
+				notify.UnsubscribeToken(s.secret, p.msg.UserID, unsubscribeScope(p.msg.Kind, c.ThreadKey))
+			sendErr = s.sender.Send(ctx, p.msg)
+			if errors.Is(sendErr, notify.ErrNoAddress) {
+				attempts = s.cfg.MaxAttempts
+			}
+		}
+
+		if sendErr == nil {
+			sent++
+			_, err = s.db.ExecContext(ctx, `
+				UPDATE notification_outbox SET sent_at = NOW(), last_error = '', locked_until = NULL
+				WHERE notification_id = $1;
+			`, p.id)
+		} else {
+			_, err = s.db.ExecContext(ctx, `
+				UPDATE notification_outbox SET attempts = $2, last_error = $3, locked_until = NULL
+				WHERE notification_id = $1;
+			`, p.id, attempts, sendErr.Error())
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+
+	return sent, nil
+}
+
+// claim reserves a batch of unsent notifications and counts the attempt
+// up front, so a notification whose dispatcher keeps dying still runs out
+// of attempts.
+func (s *NotificationsService) claim(ctx context.Context) ([]pendingNotification, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		WITH claimed AS (
+			UPDATE notification_outbox
+			SET attempts = attempts + 1, locked_until = NOW() + make_interval(secs => $2)
+			WHERE notification_id IN (
+				SELECT notification_id
+				FROM notification_outbox
+				WHERE sent_at IS NULL AND attempts < $1 AND (locked_until IS NULL OR locked_until < NOW())
+				ORDER BY created_at
+				LIMIT `+fmt.Sprintf("%d", dispatchBatch)+`
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING notification_id, attempts, user_id, comment_id, kind, created_at
+		)
+		SELECT o.notification_id, o.attempts, o.user_id, u.user_name, COALESCE(ns.email, ''), o.kind,
+		       `+commentColumns+`
+		FROM claimed o
+		JOIN users u ON u.user_id = o.user_id
+		LEFT JOIN notification_settings ns ON ns.user_id = o.user_id
+		JOIN comments c ON c.comment_id = o.comment_id
+		ORDER BY o.created_at;
+	`, s.cfg.MaxAttempts, dispatchLease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []pendingNotification
+	for rows.Next() {
+		var p pendingNotification
+		err := rows.Scan(append([]any{&p.id, &p.attempts, &p.msg.UserID, &p.msg.UserName, &p.msg.Email, &p.msg.Kind},
+			commentFields(&p.msg.Comment)...)...)
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, p)
+	}
+	return batch, rows.Err()
+}
+
+func unsubscribeScope(kind, threadKey string) string {
+	switch kind {
+	case e.NotifyReply:
+		return notify.ScopeReplies
+	case e.NotifyMention:
+		return notify.ScopeMentions
+	default:
+		return notify.ScopeThread + threadKey
+	}
+}
@@ -0,0 +1,976 @@
+package service
+
+import (
+	e "commentTree/internal/entity"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// commentColumns is the select list matching commentFields; queries alias
+// the comments table as c.
+const commentColumns = `c.comment_id, c.parent_id, c.user_name, c.comment, c.comment_html, c.path, c.thread_key, c.date,
+		       c.deleted, c.edited_at, c.author_id, c.hidden, c.locked, c.status, c.status_reason,
+		       c.upvotes, c.downvotes, c.score, c.reactions`
+
+func commentFields(c *e.Comments) []any {
+	return []any{&c.CommentID, &c.ParentID, &c.UserName, &c.Comment, &c.HTML, &c.Path, &c.ThreadKey, &c.Date,
+		&c.Deleted, &c.EditedAt, &c.AuthorID, &c.Hidden, &c.Locked, &c.Status, &c.StatusReason,
+		&c.Upvotes, &c.Downvotes, &c.Score, &c.Reactions}
+}
+
+// replyCount is the select column counting published direct replies of c.
+const replyCount = `(SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.comment_id AND r.status = 'approved') AS reply_count`
+
+type PostgresRepository struct {
+	db       *sql.DB
+	maxDepth int
+}
+
+// NewPostgresRepository returns a repository that refuses replies nested
+// deeper than maxDepth levels below the root; zero means no limit.
+func NewPostgresRepository(db *sql.DB, maxDepth int) *PostgresRepository {
+	return &PostgresRepository{
+		db:       db,
+		maxDepth: maxDepth,
+	}
+}
+
+func (r *PostgresRepository) Parent(ctx context.Context, id uuid.UUID) (*Parent, error) {
+	var (
+		p      = Parent{CommentID: id}
+		status string
+	)
+	err := r.db.QueryRowContext(ctx, `
+		SELECT p.thread_key, p.path, nlevel(p.path) - 1, p.status, bool_or(l.locked), bool_or(t.closed)
+		FROM comments p
+		JOIN comments l ON l.path @> p.path
+		JOIN threads t ON t.thread_key = p.thread_key
+		WHERE p.comment_id = $1
+		GROUP BY p.comment_id;
+	`, id).Scan(&p.ThreadKey, &p.Path, &p.Depth, &status, &p.Locked, &p.Closed)
+	if err == sql.ErrNoRows {
+		return nil, ErrParentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != e.StatusApproved {
+		return nil, ErrParentUnavailable
+	}
+	return &p, nil
+}
+
+func (r *PostgresRepository) OpenThread(ctx context.Context, key string) error {
+	var closed bool
+	err := r.db.QueryRowContext(ctx, `
+		INSERT INTO threads (thread_key) VALUES ($1)
+		ON CONFLICT (thread_key) DO UPDATE SET thread_key = EXCLUDED.thread_key
+		RETURNING closed;
+	`, key).Scan(&closed)
+	if err != nil {
+		return err
+	}
+	if closed {
+		return ErrThreadClosed
+	}
+	return nil
+}
+
+// Insert runs in one transaction with the mentions and notifications of
+// the comment. The parent row is share-locked, so it cannot be deleted
+// between the checks and the insert.
+func (r *PostgresRepository) Insert(ctx context.Context, c *e.Comments, mentions []uuid.UUID) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if c.ParentID == nil {
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO comments (user_name, comment, comment_html, author_id, status, status_reason, thread_key, path)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, REPLACE(gen_random_uuid()::text, '-', '')::ltree)
+			RETURNING comment_id, path, date;
+		`, c.UserName, c.Comment, c.HTML, c.AuthorID, c.Status, c.StatusReason, c.ThreadKey).
+			Scan(&c.CommentID, &c.Path, &c.Date)
+	} else {
+		var (
+			status string
+			depth  int
+		)
+		err = tx.QueryRowContext(ctx, `
+			SELECT thread_key, status, nlevel(path) FROM comments WHERE comment_id = $1 FOR SHARE;
+		`, *c.ParentID).Scan(&c.ThreadKey, &status, &depth)
+		if err == sql.ErrNoRows {
+			return ErrParentNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if status != e.StatusApproved {
+			return ErrParentUnavailable
+		}
+		if r.maxDepth > 0 && depth > r.maxDepth {
+			return ErrTooDeep
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO comments (user_name, comment, comment_html, author_id, status, status_reason, parent_id, thread_key, path)
+			SELECT $2, $3, $4, $5, $6, $7, $1, thread_key, path || REPLACE(gen_random_uuid()::text, '-', '')::ltree
+			FROM comments WHERE comment_id = $1
+			RETURNING comment_id, path, date;
+		`, *c.ParentID, c.UserName, c.Comment, c.HTML, c.AuthorID, c.Status, c.StatusReason).
+			Scan(&c.CommentID, &c.Path, &c.Date)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := saveMentions(ctx, tx, c.CommentID, mentions); err != nil {
+		return err
+	}
+	if c.Status == e.StatusApproved {
+		if err := enqueueNotifications(ctx, tx, c.CommentID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *PostgresRepository) Get(ctx context.Context, id uuid.UUID) (*e.Comments, error) {
+	q := `
+		SELECT ` + commentColumns + `,
+		       nlevel(c.path) - 1 AS depth,
+		       ` + replyCount + `
+		FROM comments c
+		WHERE c.comment_id = $1 AND c.status = 'approved';
+	`
+
+	var c e.Comments
+	err := r.db.QueryRowContext(ctx, q, id).Scan(append(commentFields(&c), &c.Depth, &c.ReplyCount)...)
+	if err == sql.ErrNoRows {
+		return nil, ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Subtree with perNode set walks down from the comment and follows only the
+// first perNode replies of every node, so replies that are cut off are never
+// read.
+func (r *PostgresRepository) Subtree(ctx context.Context, id uuid.UUID, maxDepth, perNode int, srt Sort) ([]e.Comments, error) {
+	path, err := r.path(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if perNode <= 0 {
+		q := `
+			SELECT ` + commentColumns + `,
+			       nlevel(c.path) - nlevel($1::ltree) AS depth,
+			       ` + replyCount + `
+			FROM comments c
+			WHERE c.path <@ $1::ltree
+			  AND c.comment_id != $2
+			  AND c.status = 'approved'
+			  AND ($3 = 0 OR nlevel(c.path) - nlevel($1::ltree) <= $3)
+			ORDER BY c.path;
+		`
+		return r.query(ctx, q, path, id, maxDepth)
+	}
+
+	q := `
+		WITH RECURSIVE kept AS (
+			SELECT $1::uuid AS comment_id, 0 AS depth
+			UNION ALL
+			SELECT top.comment_id, k.depth + 1
+			FROM kept k
+			CROSS JOIN LATERAL (
+				SELECT c.comment_id
+				FROM comments c
+				WHERE c.parent_id = k.comment_id AND c.status = 'approved'
+				ORDER BY ` + srt.orderBy() + `
+				LIMIT $3
+			) top
+			WHERE $2 = 0 OR k.depth < $2
+		)
+		SELECT ` + commentColumns + `,
+		       k.depth,
+		       ` + replyCount + `
+		FROM kept k
+		JOIN comments c ON c.comment_id = k.comment_id
+		WHERE k.depth > 0
+		ORDER BY c.path;
+	`
+	return r.query(ctx, q, id, maxDepth, perNode)
+}
+
+func (r *PostgresRepository) Children(ctx context.Context, id uuid.UUID, p Page) ([]e.Comments, error) {
+	path, err := r.path(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args, offset := p.Sort.pageFilter(p.After, 2)
+	q := `
+		SELECT ` + commentColumns + `,
+		       1 AS depth,
+		       ` + replyCount + `
+		FROM comments c
+		WHERE c.path ~ ($1 || '.*{1}')::lquery
+		  AND c.status = 'approved'
+		  AND ` + where + `
+		ORDER BY ` + p.Sort.orderBy() + `
+		LIMIT ` + fmt.Sprintf("%d OFFSET %d", p.Limit, offset) + `;
+	`
+
+	return r.query(ctx, q, append([]any{path}, args...)...)
+}
+
+func (r *PostgresRepository) Roots(ctx context.Context, threadKey string, p Page) ([]e.Comments, error) {
+	where, args, offset := p.Sort.pageFilter(p.After, 2)
+	q := `
+		SELECT ` + commentColumns + `,
+		       0 AS depth,
+		       ` + replyCount + `
+		FROM comments c
+		WHERE c.thread_key = $1
+		  AND c.parent_id IS NULL
+		  AND c.status = 'approved'
+		  AND ` + where + `
+		ORDER BY ` + p.Sort.orderBy() + `
+		LIMIT ` + fmt.Sprintf("%d OFFSET %d", p.Limit, offset) + `;
+	`
+
+	return r.query(ctx, q, append([]any{threadKey}, args...)...)
+}
+
+func (r *PostgresRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM comments WHERE comment_id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrCommentNotFound
+	}
+	return nil
+}
+
+func (r *PostgresRepository) Find(ctx context.Context, id uuid.UUID) (*e.Comments, error) {
+	c := &e.Comments{}
+	err := r.db.QueryRowContext(ctx, "SELECT "+commentColumns+" FROM comments c WHERE c.comment_id = $1", id).
+		Scan(commentFields(c)...)
+	if err == sql.ErrNoRows {
+		return nil, ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// path returns the ltree path of a published comment.
+func (r *PostgresRepository) path(ctx context.Context, id uuid.UUID) (string, error) {
+	var path string
+	err := r.db.QueryRowContext(ctx, `
+		SELECT path FROM comments WHERE comment_id = $1 AND status = 'approved';
+	`, id).Scan(&path)
+	if err == sql.ErrNoRows {
+		return "", ErrCommentNotFound
+	}
+	return path, err
+}
+
+// query scans rows selected as commentColumns, depth, reply_count.
+func (r *PostgresRepository) query(ctx context.Context, q string, args ...any) ([]e.Comments, error) {
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []e.Comments{}
+	for rows.Next() {
+		var c e.Comments
+		if err := rows.Scan(append(commentFields(&c), &c.Depth, &c.ReplyCount)...); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// Move rewrites the paths of the whole subtree in a single UPDATE.
+func (r *PostgresRepository) Move(ctx context.Context, id uuid.UUID, parentID *uuid.UUID, threadKey string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldPath, oldThread string
+	err = tx.QueryRowContext(ctx, `
+		SELECT path, thread_key FROM comments WHERE comment_id = $1 FOR UPDATE;
+	`, id).Scan(&oldPath, &oldThread)
+	if err == sql.ErrNoRows {
+		return ErrCommentNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// Locking the subtree waits for replies that are being posted into it
+	// and keeps new ones out until the paths are rewritten.
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM comments WHERE path <@ $1::ltree FOR UPDATE", oldPath); err != nil {
+		return err
+	}
+
+	newPath := oldPath[strings.LastIndexByte(oldPath, '.')+1:]
+	if parentID != nil {
+		var parentPath, status string
+		err = tx.QueryRowContext(ctx, `
+			SELECT path, thread_key, status FROM comments WHERE comment_id = $1 FOR SHARE;
+		`, *parentID).Scan(&parentPath, &threadKey, &status)
+		if err == sql.ErrNoRows {
+			return ErrParentNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if status != e.StatusApproved {
+			return ErrParentUnavailable
+		}
+		if isAncestorPath(oldPath, parentPath) {
+			return fmt.Errorf("%w: a comment cannot be moved below itself", ErrInvalidParent)
+		}
+		newPath = parentPath + "." + newPath
+	} else {
+		if threadKey == "" {
+			threadKey = oldThread
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO threads (thread_key) VALUES ($1) ON CONFLICT DO NOTHING", threadKey); err != nil {
+			return err
+		}
+	}
+
+	if r.maxDepth > 0 {
+		var height int
+		err = tx.QueryRowContext(ctx, `
+			SELECT MAX(nlevel(path)) - nlevel($1::ltree) FROM comments WHERE path <@ $1::ltree;
+		`, oldPath).Scan(&height)
+		if err != nil {
+			return err
+		}
+		if pathDepth(newPath)+height > r.maxDepth {
+			return ErrTooDeep
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE comments
+		SET path = CASE WHEN path = $1::ltree THEN $2::ltree ELSE $2::ltree || subpath(path, nlevel($1::ltree)) END,
+		    thread_key = $3,
+		    parent_id = CASE WHEN comment_id = $4 THEN $5 ELSE parent_id END
+		WHERE path <@ $1::ltree;
+	`, oldPath, newPath, threadKey, id, parentID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *PostgresRepository) Export(ctx context.Context, key string) (*e.Thread, []e.Comments, error) {
+	var t e.Thread
+	err := r.db.QueryRowContext(ctx, `
+		SELECT thread_key, closed, created_at FROM threads WHERE thread_key = $1;
+	`, key).Scan(&t.ThreadKey, &t.Closed, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrThreadNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	comments, err := r.query(ctx, `
+		SELECT `+commentColumns+`,
+		       nlevel(c.path) - 1 AS depth,
+		       0 AS reply_count
+		FROM comments c
+		WHERE c.thread_key = $1
+		ORDER BY c.path;
+	`, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &t, comments, nil
+}
+
+// Import keeps the author only if the account exists here; the name is
+// stored either way.
+func (r *PostgresRepository) Import(ctx context.Context, t *e.Thread, comments []e.Comments, mentions map[uuid.UUID][]uuid.UUID) error {
+	if r.maxDepth > 0 {
+		for _, c := range comments {
+			if pathDepth(c.Path) > r.maxDepth {
+				return ErrTooDeep
+			}
+		}
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO threads (thread_key, closed) VALUES ($1, $2)
+		ON CONFLICT (thread_key) DO UPDATE SET closed = EXCLUDED.closed;
+	`, t.ThreadKey, t.Closed)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range comments {
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO comments (comment_id, parent_id, user_name, comment, comment_html, author_id, date, edited_at,
+			                      deleted, hidden, locked, status, status_reason, thread_key, path)
+			VALUES ($1, $2, $3, $4, $5, (SELECT user_id FROM users WHERE user_id = $6), $7, $8,
+			        $9, $10, $11, $12, $13, $14, $15::ltree)
+			ON CONFLICT DO NOTHING;
+		`, c.CommentID, c.ParentID, c.UserName, c.Comment, c.HTML, c.AuthorID, c.Date, c.EditedAt,
+			c.Deleted, c.Hidden, c.Locked, c.Status, c.StatusReason, t.ThreadKey, c.Path)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("%w: %s", ErrImportConflict, c.CommentID)
+		}
+
+		if len(mentions[c.CommentID]) > 0 {
+			if err := saveMentions(ctx, tx, c.CommentID, mentions[c.CommentID]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *PostgresRepository) Edit(ctx context.Context, id uuid.UUID, body, html string, mentions []uuid.UUID, status, reason string, check func(c *e.Comments) error) (*e.Comments, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	old, err := lockComment(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := check(old); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO comment_revisions (comment_id, comment) VALUES ($1, $2);
+	`, id, old.Comment)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &e.Comments{}
+	err = tx.QueryRowContext(ctx, `
+		UPDATE comments AS c SET comment = $2, comment_html = $3, edited_at = NOW(),
+			status = COALESCE(NULLIF($4, ''), c.status),
+			status_reason = CASE WHEN $4 = '' THEN c.status_reason ELSE $5 END
+		WHERE comment_id = $1
+		RETURNING `+commentColumns+`;
+	`, id, body, html, status, reason).Scan(commentFields(c)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := saveMentions(ctx, tx, id, mentions); err != nil {
+		return nil, err
+	}
+
+	return c, tx.Commit()
+}
+
+func (r *PostgresRepository) SoftDelete(ctx context.Context, id uuid.UUID, body, html string, check func(c *e.Comments) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	old, err := lockComment(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if err := check(old); err != nil {
+		return err
+	}
+	if old.Deleted {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO comment_revisions (comment_id, comment) VALUES ($1, $2);
+	`, id, old.Comment)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE comments SET comment = $2, comment_html = $3, deleted = TRUE WHERE comment_id = $1;
+	`, id, body, html)
+	if err != nil {
+		return err
+	}
+
+	if err := saveMentions(ctx, tx, id, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockComment reads a comment of any status and locks its row until tx ends.
+func lockComment(ctx context.Context, tx *sql.Tx, id uuid.UUID) (*e.Comments, error) {
+	c := &e.Comments{}
+	err := tx.QueryRowContext(ctx, "SELECT "+commentColumns+" FROM comments c WHERE c.comment_id = $1 FOR UPDATE", id).
+		Scan(commentFields(c)...)
+	if err == sql.ErrNoRows {
+		return nil, ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (r *PostgresRepository) Revisions(ctx context.Context, id uuid.UUID) ([]e.Revision, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT revision_id, comment_id, COALESCE(comment, ''), date
+		FROM comment_revisions
+		WHERE comment_id = $1
+		ORDER BY date;
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []e.Revision{}
+	for rows.Next() {
+		var rev e.Revision
+		if err := rows.Scan(&rev.RevisionID, &rev.CommentID, &rev.Comment, &rev.Date); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, rows.Err()
+}
+
+// Vote keeps the counters on the comment row in step with comment_votes, so
+// sorting never needs an aggregate.
+func (r *PostgresRepository) Vote(ctx context.Context, id, userID uuid.UUID, value int) (*e.Comments, error) {
+	return r.withVotableComment(ctx, id, func(tx *sql.Tx) error {
+		var old int
+		err := tx.QueryRowContext(ctx, `
+			SELECT value FROM comment_votes WHERE comment_id = $1 AND user_id = $2 FOR UPDATE;
+		`, id, userID).Scan(&old)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if old == value {
+			return nil
+		}
+
+		if value == 0 {
+			_, err = tx.ExecContext(ctx, "DELETE FROM comment_votes WHERE comment_id = $1 AND user_id = $2", id, userID)
+		} else {
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO comment_votes (comment_id, user_id, value) VALUES ($1, $2, $3)
+				ON CONFLICT (comment_id, user_id) DO UPDATE SET value = EXCLUDED.value;
+			`, id, userID, value)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE comments
+			SET upvotes = upvotes + $2, downvotes = downvotes + $3
+			WHERE comment_id = $1;
+		`, id, btoi(value == 1)-btoi(old == 1), btoi(value == -1)-btoi(old == -1))
+		return err
+	})
+}
+
+func (r *PostgresRepository) React(ctx context.Context, id, userID uuid.UUID, emoji string) (*e.Comments, error) {
+	return r.withVotableComment(ctx, id, func(tx *sql.Tx) error {
+		var old string
+		err := tx.QueryRowContext(ctx, `
+			SELECT emoji FROM comment_reactions WHERE comment_id = $1 AND user_id = $2 FOR UPDATE;
+		`, id, userID).Scan(&old)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if old == emoji {
+			return nil
+		}
+
+		if old != "" {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE comments
+				SET reactions = CASE
+					WHEN (reactions->>$2::text)::int <= 1 THEN reactions - $2::text
+					ELSE jsonb_set(reactions, ARRAY[$2::text], to_jsonb((reactions->>$2::text)::int - 1))
+				END
+				WHERE comment_id = $1;
+			`, id, old)
+			if err != nil {
+				return err
+			}
+		}
+
+		if emoji == "" {
+			_, err = tx.ExecContext(ctx, "DELETE FROM comment_reactions WHERE comment_id = $1 AND user_id = $2", id, userID)
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO comment_reactions (comment_id, user_id, emoji) VALUES ($1, $2, $3)
+			ON CONFLICT (comment_id, user_id) DO UPDATE SET emoji = EXCLUDED.emoji;
+		`, id, userID, emoji)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE comments
+			SET reactions = jsonb_set(reactions, ARRAY[$2::text], to_jsonb(COALESCE((reactions->>$2::text)::int, 0) + 1))
+			WHERE comment_id = $1;
+		`, id, emoji)
+		return err
+	})
+}
+
+// withVotableComment locks a published, not deleted comment, runs fn and
+// returns the comment with its updated counters.
+func (r *PostgresRepository) withVotableComment(ctx context.Context, id uuid.UUID, fn func(tx *sql.Tx) error) (*e.Comments, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var deleted bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT deleted FROM comments WHERE comment_id = $1 AND status = 'approved' FOR UPDATE;
+	`, id).Scan(&deleted)
+	if err == sql.ErrNoRows {
+		return nil, ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deleted {
+		return nil, ErrCommentDeleted
+	}
+
+	if err := fn(tx); err != nil {
+		return nil, err
+	}
+
+	c := &e.Comments{}
+	err = tx.QueryRowContext(ctx, "SELECT "+commentColumns+" FROM comments c WHERE c.comment_id = $1", id).
+		Scan(commentFields(c)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, tx.Commit()
+}
+
+func (r *PostgresRepository) SetHidden(ctx context.Context, id uuid.UUID, hidden bool) error {
+	return r.setFlag(ctx, id, "hidden", hidden)
+}
+
+func (r *PostgresRepository) SetLocked(ctx context.Context, id uuid.UUID, locked bool) error {
+	return r.setFlag(ctx, id, "locked", locked)
+}
+
+func (r *PostgresRepository) setFlag(ctx context.Context, id uuid.UUID, column string, value bool) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE comments SET "+column+" = $2 WHERE comment_id = $1", id, value)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrCommentNotFound
+	}
+	return nil
+}
+
+func (r *PostgresRepository) Pending(ctx context.Context, p Page) ([]e.Comments, error) {
+	where, args, _ := SortOldest.pageFilter(p.After, 1)
+	q := `
+		SELECT ` + commentColumns + `,
+		       nlevel(c.path) - 1 AS depth,
+		       0 AS reply_count
+		FROM comments c
+		WHERE c.status = 'pending'
+		  AND ` + where + `
+		ORDER BY ` + SortOldest.orderBy() + `
+		LIMIT ` + fmt.Sprintf("%d", p.Limit) + `;
+	`
+
+	return r.query(ctx, q, args...)
+}
+
+func (r *PostgresRepository) Resolve(ctx context.Context, id uuid.UUID, status string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE comments SET status = $2
+		WHERE comment_id = $1 AND status = 'pending'
+		RETURNING comment_id;
+	`, id, status).Scan(&id)
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM comments WHERE comment_id = $1)", id).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrCommentNotFound
+		}
+		return ErrNotPending
+	}
+	if err != nil {
+		return err
+	}
+
+	// Held comments notify nobody until they are published.
+	if status == e.StatusApproved {
+		if err := enqueueNotifications(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresRepository) Notifications(ctx context.Context, userID uuid.UUID, p Page) ([]e.Notification, error) {
+	where, args, _ := SortNewest.pageFilter(p.After, 2)
+	q := `
+		SELECT ` + commentColumns + `,
+		       nlevel(c.path) - 1 AS depth,
+		       CASE WHEN p.author_id = $1 THEN 'reply' ELSE 'mention' END AS kind
+		FROM comments c
+		LEFT JOIN comments p ON p.comment_id = c.parent_id
+		WHERE c.status = 'approved'
+		  AND NOT c.deleted
+		  AND NOT c.hidden
+		  AND c.author_id IS DISTINCT FROM $1
+		  AND (p.author_id = $1
+		       OR EXISTS (SELECT 1 FROM comment_mentions m WHERE m.comment_id = c.comment_id AND m.user_id = $1))
+		  AND ` + where + `
+		ORDER BY ` + SortNewest.orderBy() + `
+		LIMIT ` + fmt.Sprintf("%d", p.Limit) + `;
+	`
+
+	rows, err := r.db.QueryContext(ctx, q, append([]any{userID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []e.Notification{}
+	for rows.Next() {
+		var n e.Notification
+		if err := rows.Scan(append(commentFields(&n.Comments), &n.Depth, &n.Kind)...); err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return list, rows.Err()
+}
+
+func (r *PostgresRepository) Thread(ctx context.Context, key string) (*e.Thread, error) {
+	var t e.Thread
+	err := r.db.QueryRowContext(ctx, `
+		SELECT t.thread_key, t.closed, t.created_at,
+		       COUNT(c.comment_id),
+		       COUNT(c.comment_id) FILTER (WHERE c.parent_id IS NULL)
+		FROM threads t
+		LEFT JOIN comments c ON c.thread_key = t.thread_key AND c.status = 'approved'
+		WHERE t.thread_key = $1
+		GROUP BY t.thread_key;
+	`, key).Scan(&t.ThreadKey, &t.Closed, &t.CreatedAt, &t.CommentCount, &t.RootCount)
+	if err == sql.ErrNoRows {
+		return nil, ErrThreadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *PostgresRepository) ThreadCounts(ctx context.Context, keys []string) (map[string]int, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT thread_key, COUNT(*)
+		FROM comments
+		WHERE thread_key = ANY($1) AND status = 'approved'
+		GROUP BY thread_key;
+	`, pq.Array(keys))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var key string
+		var n int
+		if err := rows.Scan(&key, &n); err != nil {
+			return nil, err
+		}
+		counts[key] = n
+	}
+	return counts, rows.Err()
+}
+
+func (r *PostgresRepository) SetThreadClosed(ctx context.Context, key string, closed bool) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO threads (thread_key, closed) VALUES ($1, $2)
+		ON CONFLICT (thread_key) DO UPDATE SET closed = EXCLUDED.closed;
+	`, key, closed)
+	return err
+}
+
+// Search parses the query with the Russian, English and simple
+// configurations so that word forms of either language match.
+func (r *PostgresRepository) Search(ctx context.Context, sq e.SearchQuery) ([]e.SearchHit, error) {
+	q := `
+		WITH query AS (
+			SELECT websearch_to_tsquery('russian', $1) || websearch_to_tsquery('english', $1)
+			       || websearch_to_tsquery('simple', $1) AS tsq
+		)
+		SELECT ` + commentColumns + `,
+		       nlevel(c.path) - 1 AS depth,
+		       ts_rank(c.search_vector, query.tsq) AS rank,
+		       ts_headline('russian', COALESCE(c.comment, ''), query.tsq,
+		                   'StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=10, MaxFragments=2'),
+		       root.comment_id, COALESCE(root.user_name, ''), COALESCE(root.comment, '')
+		FROM comments c
+		CROSS JOIN query
+		JOIN comments root ON root.path = subpath(c.path, 0, 1)
+		WHERE c.search_vector @@ query.tsq
+		  AND NOT c.deleted
+		  AND NOT c.hidden
+		  AND c.status = 'approved'
+		  AND ($2 = '' OR lower(c.user_name) = lower($2))
+		  AND ($3::timestamp IS NULL OR c.date >= $3)
+		  AND ($4::timestamp IS NULL OR c.date < $4)
+		  AND ($7 = '' OR c.thread_key = $7)
+		ORDER BY rank DESC, c.date DESC
+		LIMIT $5 OFFSET $6;
+	`
+
+	rows, err := r.db.QueryContext(ctx, q, sq.Query, sq.Author, sq.From, sq.To, sq.Limit, sq.Offset, sq.ThreadKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := []e.SearchHit{}
+	for rows.Next() {
+		var h e.SearchHit
+		err = rows.Scan(append(commentFields(&h.Comments),
+			&h.Depth, &h.Rank, &h.Snippet, &h.RootID, &h.RootUserName, &h.RootComment)...)
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
+
+func (r *PostgresRepository) UsersByName(ctx context.Context, names []string) ([]e.User, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT user_id, user_name FROM users WHERE lower(user_name) = ANY($1);
+	`, pq.Array(names))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []e.User
+	for rows.Next() {
+		var u e.User
+		if err := rows.Scan(&u.UserID, &u.UserName); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (r *PostgresRepository) Banned(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var banned bool
+	err := r.db.QueryRowContext(ctx, "SELECT banned FROM users WHERE user_id = $1", userID).Scan(&banned)
+	if err == sql.ErrNoRows {
+		return false, ErrUserNotFound
+	}
+	return banned, err
+}
+
+// saveMentions replaces the recorded mentions of a comment.
+func saveMentions(ctx context.Context, tx *sql.Tx, commentID uuid.UUID, users []uuid.UUID) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM comment_mentions WHERE comment_id = $1", commentID); err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(users))
+	for i, id := range users {
+		ids[i] = id.String()
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO comment_mentions (comment_id, user_id)
+		SELECT $1, unnest($2::uuid[])
+		ON CONFLICT DO NOTHING;
+	`, commentID, pq.Array(ids))
+	return err
+}
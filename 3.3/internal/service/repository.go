@@ -0,0 +1,160 @@
+package service
+
+import (
+	e "commentTree/internal/entity"
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrParentNotFound = errors.New("parent comment not found")
+	ErrInvalidParent  = errors.New("invalid parent comment")
+	// ErrParentUnavailable is returned for replies to comments that exist
+	// but are not published, i.e. held for moderation or rejected.
+	ErrParentUnavailable = errors.New("parent comment does not accept replies")
+	ErrTooDeep           = errors.New("reply nesting limit reached")
+	ErrImportConflict    = errors.New("comment ID already exists")
+)
+
+// CommentRepository stores the comment tree. CommentsService builds and
+// reads trees only through it; PostgresRepository is the production store
+// and MemoryRepository lets the tree logic run without a database.
+//
+// Reads only return published comments. Depth is counted from the comment
+// a read starts at: for Subtree and Children from the given comment, for
+// Roots and Get from the thread root. ReplyCount counts published direct
+// replies.
+type CommentRepository interface {
+	// Parent describes the comment a reply is about to be posted under.
+	Parent(ctx context.Context, id uuid.UUID) (*Parent, error)
+
+	// OpenThread creates the thread on its first comment and fails with
+	// ErrThreadClosed for a closed one.
+	OpenThread(ctx context.Context, key string) error
+
+	// Insert stores a new comment and records who it mentions. CommentID,
+	// Path and Date are filled in, and a reply takes the thread of its
+	// parent. Published comments also queue their notifications.
+	Insert(ctx context.Context, c *e.Comments, mentions []uuid.UUID) error
+
+	Get(ctx context.Context, id uuid.UUID) (*e.Comments, error)
+
+	// Find returns a comment whatever its status, without Depth and
+	// ReplyCount.
+	Find(ctx context.Context, id uuid.UUID) (*e.Comments, error)
+
+	// Subtree returns everything below a comment in path order, down to
+	// maxDepth levels when maxDepth is positive. With perNode set only the
+	// first perNode replies of every node in the order of srt are kept,
+	// together with what is below them.
+	Subtree(ctx context.Context, id uuid.UUID, maxDepth, perNode int, srt Sort) ([]e.Comments, error)
+
+	// Children and Roots return one page of direct replies or of thread
+	// roots in the order of p.Sort.
+	Children(ctx context.Context, id uuid.UUID, p Page) ([]e.Comments, error)
+	Roots(ctx context.Context, threadKey string, p Page) ([]e.Comments, error)
+
+	// Delete removes a comment together with its subtree.
+	Delete(ctx context.Context, id uuid.UUID) error
+
+	// Move re-attaches a comment with its whole subtree under parentID, or
+	// makes it a root comment of threadKey when parentID is nil. The
+	// subtree follows the comment into the thread of its new parent.
+	Move(ctx context.Context, id uuid.UUID, parentID *uuid.UUID, threadKey string) error
+
+	// Edit replaces the body of a comment, keeps the previous one as a
+	// revision and records the new mentions. A non-empty status replaces
+	// the moderation status and reason of the comment. check sees the
+	// locked row first and can refuse the edit with an error.
+	Edit(ctx context.Context, id uuid.UUID, body, html string, mentions []uuid.UUID, status, reason string, check func(c *e.Comments) error) (*e.Comments, error)
+
+	// SoftDelete replaces the body of a comment like Edit does and marks it
+	// deleted. Deleting a deleted comment again changes nothing.
+	SoftDelete(ctx context.Context, id uuid.UUID, body, html string, check func(c *e.Comments) error) error
+
+	// Revisions lists the earlier bodies of a comment, oldest first.
+	Revisions(ctx context.Context, id uuid.UUID) ([]e.Revision, error)
+
+	// Vote and React replace the vote or the reaction of userID on a
+	// published comment that is not deleted and return the comment with its
+	// updated counters. A zero value or an empty emoji withdraws it.
+	Vote(ctx context.Context, id, userID uuid.UUID, value int) (*e.Comments, error)
+	React(ctx context.Context, id, userID uuid.UUID, emoji string) (*e.Comments, error)
+
+	SetHidden(ctx context.Context, id uuid.UUID, hidden bool) error
+	SetLocked(ctx context.Context, id uuid.UUID, locked bool) error
+
+	// Pending returns one page of comments held for moderation, oldest
+	// first.
+	Pending(ctx context.Context, p Page) ([]e.Comments, error)
+
+	// Resolve gives a held comment its final status and fails with
+	// ErrNotPending if it is not held. Approving queues its notifications.
+	Resolve(ctx context.Context, id uuid.UUID, status string) error
+
+	// Notifications returns one page of published replies to comments of
+	// userID and of comments mentioning userID, newest first. Their own
+	// comments are left out.
+	Notifications(ctx context.Context, userID uuid.UUID, p Page) ([]e.Notification, error)
+
+	// Thread returns a thread with its counts of published comments.
+	Thread(ctx context.Context, key string) (*e.Thread, error)
+
+	// ThreadCounts returns the number of published comments of the threads
+	// that have any.
+	ThreadCounts(ctx context.Context, keys []string) (map[string]int, error)
+
+	// SetThreadClosed creates the thread if needed.
+	SetThreadClosed(ctx context.Context, key string, closed bool) error
+
+	// Search returns published comments matching sq, best match first.
+	// Snippet holds the matched text with <mark> around the hits, not yet
+	// escaped.
+	Search(ctx context.Context, sq e.SearchQuery) ([]e.SearchHit, error)
+
+	// UsersByName looks up accounts by lower-cased name for mentions.
+	UsersByName(ctx context.Context, names []string) ([]e.User, error)
+
+	// Banned reports whether an account is banned and fails with
+	// ErrUserNotFound if it does not exist.
+	Banned(ctx context.Context, userID uuid.UUID) (bool, error)
+
+	// Export returns a thread and all of its comments, whatever their
+	// status, in path order.
+	Export(ctx context.Context, key string) (*e.Thread, []e.Comments, error)
+
+	// Import stores comments with IDs and paths already assigned, parents
+	// first, in one transaction. The thread is created if it does not
+	// exist and takes the closed flag of t either way. It fails with ErrImportConflict if an ID is taken and queues no
+	// notifications.
+	Import(ctx context.Context, t *e.Thread, comments []e.Comments, mentions map[uuid.UUID][]uuid.UUID) error
+}
+
+// Parent is what a reply needs to know about the comment it answers.
+type Parent struct {
+	CommentID uuid.UUID
+	ThreadKey string
+	Path      string
+	Depth     int
+	// Locked is set if the parent or any comment above it is locked.
+	Locked bool
+	// Closed is set if the thread is closed.
+	Closed bool
+}
+
+// Page selects the rows of one page. Limit is the number of rows to fetch.
+type Page struct {
+	Sort  Sort
+	After *cursor
+	Limit int
+}
+
+// offset is the number of rows ranked orders skip.
+func (p Page) offset() int {
+	if p.Sort.keyset() || p.After == nil {
+		return 0
+	}
+	return p.After.Offset
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	e "commentTree/internal/entity"
+	"context"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Search runs a full-text query over comment bodies and author names. The
+// query is parsed with both the Russian and English configurations so that
+// word forms of either language match.
+func (s *CommentsService) Search(ctx context.Context, sq e.SearchQuery) ([]e.SearchHit, error) {
+	sq.Query = strings.TrimSpace(sq.Query)
+	if sq.Query == "" {
+		return nil, fmt.Errorf("%w: empty search query", ErrInvalidInput)
+	}
+	sq.Limit = normalizeLimit(sq.Limit)
+
+	hits, err := s.repo.Search(ctx, sq)
+	if err != nil {
+		return nil, err
+	}
+	for i := range hits {
+		hits[i].Snippet = escapeSnippet(hits[i].Snippet)
+	}
+	return hits, nil
+}
+
+// escapeSnippet escapes the raw comment text in a ts_headline fragment and
+// keeps only the <mark> highlighting.
+func escapeSnippet(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, "&lt;mark&gt;", "<mark>")
+	return strings.ReplaceAll(s, "&lt;/mark&gt;", "</mark>")
+}
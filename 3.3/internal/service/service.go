@@ -2,139 +2,203 @@ package service
 
 import (
 	e "commentTree/internal/entity"
+	"commentTree/internal/filter"
+	"commentTree/internal/markdown"
 	"context"
-	"database/sql"
 	"fmt"
 
 	"github.com/google/uuid"
 )
 
 type CommentsService struct {
-	db *sql.DB
+	repo    CommentRepository
+	filters *filter.Pipeline
+	md      *markdown.Renderer
 }
 
-func NewCommentsService(db *sql.DB) *CommentsService {
+func NewCommentsService(repo CommentRepository, filters *filter.Pipeline) *CommentsService {
 	return &CommentsService{
-		db: db,
+		repo:    repo,
+		filters: filters,
+		md:      markdown.New(),
 	}
 }
 
-func (s *CommentsService) Comments(ctx context.Context, c e.CommentResponse) (*e.Comments, error) {
-	if c.UserName == "" || c.Comment == "" {
-		return nil, fmt.Errorf("not suitable username or comment")
+// Comments posts a new comment on behalf of u. The author name is taken from
+// the account, not from the request body. The comment goes through the
+// filter pipeline first and may be rejected or held for moderation;
+// moderators skip the filters.
+func (s *CommentsService) Comments(ctx context.Context, c e.CommentResponse, u *e.User) (*e.Comments, error) {
+	if u == nil {
+		return nil, ErrForbidden
 	}
-
-	var err error
-	comments := &e.Comments{
-		UserName: c.UserName,
-		Comment:  c.Comment,
+	if c.Comment == "" {
+		return nil, fmt.Errorf("%w: empty comment", ErrInvalidInput)
+	}
+	if err := s.checkNotBanned(ctx, u); err != nil {
+		return nil, err
 	}
+
+	// Root comments open or join the thread named in the request; replies
+	// always stay in the thread of their parent.
+	threadKey := c.ThreadKey
 	if c.ParentID == uuid.Nil {
-		q := `
-			INSERT INTO comments (user_name, comment, path)
-			VALUES ($1, $2, REPLACE(gen_random_uuid()::text, '-', '')::ltree)
-			RETURNING comment_id, path;
-		`
-		err = s.db.QueryRowContext(ctx, q, c.UserName, c.Comment).
-			Scan(&comments.CommentID, &comments.Path)
+		if threadKey == "" {
+			threadKey = DefaultThread
+		}
+		if err := validThreadKey(threadKey); err != nil {
+			return nil, err
+		}
+		if err := s.repo.OpenThread(ctx, threadKey); err != nil {
+			return nil, err
+		}
 	} else {
-		q := `
-			WITH parent AS (SELECT path FROM comments WHERE comment_id = $1)
-			INSERT INTO comments (user_name, comment, parent_id, path)
-			SELECT $2, $3, $1, parent.path || REPLACE(gen_random_uuid()::text, '-', '')::ltree
-			FROM parent
-			RETURNING comment_id, parent_id;
-		`
-		err = s.db.QueryRowContext(ctx, q, c.ParentID, c.UserName, c.Comment).
-			Scan(&comments.CommentID, &comments.ParentID)
+		parent, err := s.repo.Parent(ctx, c.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		if threadKey != "" && threadKey != parent.ThreadKey {
+			return nil, fmt.Errorf("%w: it belongs to another thread", ErrInvalidParent)
+		}
+		if parent.Locked {
+			return nil, ErrThreadLocked
+		}
+		if parent.Closed {
+			return nil, ErrThreadClosed
+		}
+		threadKey = parent.ThreadKey
+	}
+
+	verdict := filter.Result{Action: filter.Approve}
+	if u.Role != e.RoleModerator {
+		var err error
+		verdict, err = s.filters.Run(ctx, filter.Candidate{AuthorID: u.UserID, ParentID: c.ParentID, Body: c.Comment})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if verdict.Action == filter.Reject {
+		return nil, fmt.Errorf("%w: %s", ErrRejected, verdict.Reason)
+	}
+
+	status := e.StatusApproved
+	if verdict.Action == filter.Hold {
+		status = e.StatusPending
 	}
 
+	body, mentions, err := s.render(ctx, c.Comment)
 	if err != nil {
 		return nil, err
 	}
 
-	return comments, err
+	comment := &e.Comments{
+		UserName:     u.UserName,
+		Comment:      c.Comment,
+		HTML:         body,
+		ThreadKey:    threadKey,
+		AuthorID:     &u.UserID,
+		Status:       status,
+		StatusReason: verdict.Reason,
+	}
+	if c.ParentID != uuid.Nil {
+		parentID := c.ParentID
+		comment.ParentID = &parentID
+	}
+
+	if err := s.repo.Insert(ctx, comment, mentions); err != nil {
+		return nil, err
+	}
+	return comment, nil
 }
 
-func (s *CommentsService) GetComments(ctx context.Context, stringID string) ([]e.Comments, error) {
+// GetComments returns the subtree below a comment as a flat list in which
+// every reply follows its parent and siblings are ordered by srt. With
+// maxDepth or perNode set, only the first perNode replies of each node down
+// to maxDepth levels are returned, and MoreReplies tells how many children
+// of a node were left out.
+func (s *CommentsService) GetComments(ctx context.Context, stringID string, maxDepth, perNode int, srt Sort) ([]e.Comments, error) {
 	id, err := uuid.Parse(stringID)
 	if err != nil {
-		return nil, fmt.Errorf("invalid comment ID: %w", err)
+		return nil, fmt.Errorf("%w: comment ID: %v", ErrInvalidInput, err)
 	}
 
-	q := `
-		SELECT *
-		FROM comments
-		WHERE path <@ (SELECT path FROM comments WHERE comment_id = $1)
-		  AND comment_id != $1
-		ORDER BY path;
-	`
-
-	rows, err := s.db.QueryContext(ctx, q, id)
+	comments, err := s.repo.Subtree(ctx, id, maxDepth, perNode, srt)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var comments []e.Comments
-	for rows.Next() {
-		var c e.Comments
-		if err := rows.Scan(&c.CommentID, &c.ParentID, &c.UserName, &c.Comment, &c.Path, &c.Date); err != nil {
-			return nil, err
+	for i := range comments {
+		c := &comments[i]
+		shown := c.ReplyCount
+		if maxDepth > 0 && c.Depth >= maxDepth {
+			shown = 0
+		} else if perNode > 0 && shown > perNode {
+			shown = perNode
 		}
-		comments = append(comments, c)
+		c.MoreReplies = c.ReplyCount - shown
 	}
 
-	if rows.Err() != nil {
-		return nil, rows.Err()
-	}
+	return sortSubtree(comments, srt), nil
+}
 
-	return comments, nil
+// GetComment returns a single published comment.
+func (s *CommentsService) GetComment(ctx context.Context, id uuid.UUID) (*e.Comments, error) {
+	return s.repo.Get(ctx, id)
 }
 
-func (s *CommentsService) DeleteComments(ctx context.Context, stringID string) error {
+// GetChildren returns one page of direct replies to a comment.
+func (s *CommentsService) GetChildren(ctx context.Context, stringID string, after string, limit int, srt Sort) (*e.CommentsPage, error) {
 	id, err := uuid.Parse(stringID)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%w: comment ID: %v", ErrInvalidInput, err)
 	}
-	q := `
-		DELETE FROM comments WHERE comment_id = $1;
-	`
-	_, err = s.db.ExecContext(ctx, q, id)
+
+	cur, err := decodeCursor(after)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	limit = normalizeLimit(limit)
 
-	return nil
+	p := Page{Sort: srt, After: cur, Limit: limit + 1}
+	list, err := s.repo.Children(ctx, id, p)
+	if err != nil {
+		return nil, err
+	}
+	return makePage(list, limit, srt, p.offset()), nil
 }
 
-func (s *CommentsService) GetAllParentComments(ctx context.Context) ([]e.Comments, error) {
-	q := `
-		SELECT *
-		FROM comments
-		WHERE parent_id IS NULL
-		ORDER BY date;
-	`
-
-	rows, err := s.db.QueryContext(ctx, q)
+func (s *CommentsService) DeleteComments(ctx context.Context, stringID string) error {
+	id, err := uuid.Parse(stringID)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("%w: comment ID: %v", ErrInvalidInput, err)
 	}
-	defer rows.Close()
+	return s.repo.Delete(ctx, id)
+}
 
-	var comments []e.Comments
-	for rows.Next() {
-		var c e.Comments
-		err = rows.Scan(&c.CommentID, &c.ParentID, &c.UserName, &c.Comment, &c.Path, &c.Date)
-		if err != nil {
-			return nil, err
-		}
-		comments = append(comments, c)
+// GetAllParentComments returns one page of root comments of the default
+// thread.
+func (s *CommentsService) GetAllParentComments(ctx context.Context, after string, limit int, srt Sort) (*e.CommentsPage, error) {
+	return s.GetThreadComments(ctx, DefaultThread, after, limit, srt)
+}
+
+// makePage turns up to limit+1 fetched comments into a page and sets the
+// cursor of the next one if the extra comment is there.
+func makePage(list []e.Comments, limit int, srt Sort, offset int) *e.CommentsPage {
+	page := &e.CommentsPage{Comments: []e.Comments{}}
+	for _, c := range list {
+		c.MoreReplies = c.ReplyCount
+		page.Comments = append(page.Comments, c)
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, err
+	if len(page.Comments) > limit {
+		page.Comments = page.Comments[:limit]
+		last := page.Comments[limit-1]
+		if srt.keyset() {
+			page.NextCursor = encodeCursor(last.Date, last.CommentID)
+		} else {
+			page.NextCursor = encodeOffsetCursor(offset + limit)
+		}
 	}
 
-	return comments, nil
+	return page
 }
@@ -0,0 +1,505 @@
+package service
+
+import (
+	e "commentTree/internal/entity"
+	"commentTree/internal/filter"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CommentsService only goes through the repository, so it is built
+// without a database.
+func newTestService(repo CommentRepository) *CommentsService {
+	return NewCommentsService(repo, nil)
+}
+
+func texts(list []e.Comments) []string {
+	out := make([]string, len(list))
+	for i, c := range list {
+		out[i] = c.Comment
+	}
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGetCommentsSortsSiblings(t *testing.T) {
+	repo := newTestRepo(0)
+	root := post(t, repo, nil, "root")
+	a := post(t, repo, root, "a")
+	b := post(t, repo, root, "b")
+	post(t, repo, a, "a1")
+	post(t, repo, b, "b1")
+	post(t, repo, a, "a2")
+	svc := newTestService(repo)
+
+	got, err := svc.GetComments(context.Background(), root.CommentID.String(), 0, 0, SortOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "a1", "a2", "b", "b1"}; !equal(texts(got), want) {
+		t.Errorf("oldest = %v, want %v", texts(got), want)
+	}
+
+	got, err = svc.GetComments(context.Background(), root.CommentID.String(), 0, 0, SortNewest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b", "b1", "a", "a2", "a1"}; !equal(texts(got), want) {
+		t.Errorf("newest = %v, want %v", texts(got), want)
+	}
+}
+
+func TestLessBreaksTiesLikeSQL(t *testing.T) {
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	low := &e.Comments{CommentID: uuid.MustParse("00000000-0000-0000-0000-000000000001"), Date: date}
+	high := &e.Comments{CommentID: uuid.MustParse("ffffffff-0000-0000-0000-000000000001"), Date: date}
+
+	// orderBy: oldest, top and best tie on comment_id, newest on
+	// comment_id DESC.
+	for srt, lowFirst := range map[Sort]bool{SortOldest: true, SortNewest: false, SortTop: true, SortBest: true} {
+		if got := less(srt, low, high); got != lowFirst {
+			t.Errorf("%s: less(low, high) = %v, want %v", srt, got, lowFirst)
+		}
+		if got := less(srt, high, low); got == lowFirst {
+			t.Errorf("%s: less(high, low) = %v, want %v", srt, got, !lowFirst)
+		}
+	}
+}
+
+func TestGetCommentsDepthAndPerNode(t *testing.T) {
+	repo := newTestRepo(0)
+	root := post(t, repo, nil, "root")
+	a := post(t, repo, root, "a")
+	post(t, repo, root, "b")
+	post(t, repo, root, "c")
+	a1 := post(t, repo, a, "a1")
+	post(t, repo, a, "a2")
+	post(t, repo, a1, "a1x")
+	svc := newTestService(repo)
+
+	got, err := svc.GetComments(context.Background(), root.CommentID.String(), 2, 1, SortOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "a1"}; !equal(texts(got), want) {
+		t.Fatalf("got %v, want %v", texts(got), want)
+	}
+	// a shows one of its two replies; a1 sits at the depth limit, so its
+	// reply is left out as well.
+	if got[0].MoreReplies != 1 || got[1].MoreReplies != 1 {
+		t.Errorf("more replies = %d, %d, want 1, 1", got[0].MoreReplies, got[1].MoreReplies)
+	}
+
+	tree := BuildTree(got)
+	if len(tree) != 1 || len(tree[0].Children) != 1 || tree[0].Children[0].Comment != "a1" {
+		t.Errorf("tree is not a -> a1: %+v", tree)
+	}
+}
+
+func TestGetCommentsUnknownComment(t *testing.T) {
+	svc := newTestService(newTestRepo(0))
+
+	_, err := svc.GetComments(context.Background(), "00000000-0000-0000-0000-000000000001", 0, 0, SortOldest)
+	if !errors.Is(err, ErrCommentNotFound) {
+		t.Errorf("err = %v, want ErrCommentNotFound", err)
+	}
+}
+
+func TestGetChildrenPages(t *testing.T) {
+	repo := newTestRepo(0)
+	root := post(t, repo, nil, "root")
+	for _, text := range []string{"1", "2", "3", "4", "5"} {
+		post(t, repo, root, text)
+	}
+	svc := newTestService(repo)
+
+	for _, tc := range []struct {
+		srt  Sort
+		want []string
+	}{
+		{SortOldest, []string{"1", "2", "3", "4", "5"}},
+		{SortNewest, []string{"5", "4", "3", "2", "1"}},
+		{SortTop, []string{"5", "4", "3", "2", "1"}},
+	} {
+		var got []string
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > 5 {
+				t.Fatalf("%s: pagination does not stop", tc.srt)
+			}
+			page, err := svc.GetChildren(context.Background(), root.CommentID.String(), cursor, 2, tc.srt)
+			if err != nil {
+				t.Fatalf("%s: %v", tc.srt, err)
+			}
+			got = append(got, texts(page.Comments)...)
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+		if !equal(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.srt, got, tc.want)
+		}
+	}
+}
+
+func TestGetThreadCommentsOnlyRoots(t *testing.T) {
+	repo := newTestRepo(0)
+	a := post(t, repo, nil, "a")
+	post(t, repo, a, "a1")
+	post(t, repo, nil, "b")
+	other := &e.Comments{Comment: "elsewhere", ThreadKey: "other", Status: e.StatusApproved}
+	if err := repo.Insert(context.Background(), other, nil); err != nil {
+		t.Fatal(err)
+	}
+	svc := newTestService(repo)
+
+	page, err := svc.GetThreadComments(context.Background(), DefaultThread, "", 10, SortOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b"}; !equal(texts(page.Comments), want) {
+		t.Errorf("got %v, want %v", texts(page.Comments), want)
+	}
+	if page.Comments[0].MoreReplies != 1 {
+		t.Errorf("a: more replies = %d, want 1", page.Comments[0].MoreReplies)
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	src := newTestRepo(0)
+	root := post(t, src, nil, "root")
+	reply := post(t, src, root, "**reply**")
+	post(t, src, reply, "deeper")
+	held := post(t, src, root, "held")
+	src.SetStatus(held.CommentID, e.StatusPending)
+
+	doc, err := newTestService(src).ExportThread(context.Background(), DefaultThread)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Comments) != 4 {
+		t.Fatalf("exported %d comments, want 4 including the held one", len(doc.Comments))
+	}
+
+	for _, remap := range []bool{false, true} {
+		dst := newTestRepo(0)
+		svc := newTestService(dst)
+		res, err := svc.ImportThread(context.Background(), "imported", *doc, remap)
+		if err != nil {
+			t.Fatalf("remap=%v: %v", remap, err)
+		}
+		if res.Imported != 4 {
+			t.Errorf("remap=%v: imported %d comments, want 4", remap, res.Imported)
+		}
+		if changed := res.IDs[root.CommentID] != root.CommentID; changed != remap {
+			t.Errorf("remap=%v: root ID %s -> %s", remap, root.CommentID, res.IDs[root.CommentID])
+		}
+
+		got, err := svc.GetComments(context.Background(), res.IDs[root.CommentID].String(), 0, 0, SortOldest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"**reply**", "deeper"}; !equal(texts(got), want) {
+			t.Errorf("remap=%v: got %v, want %v", remap, texts(got), want)
+		}
+		if got[0].ThreadKey != "imported" || got[0].HTML != "<p><strong>reply</strong></p>\n" {
+			t.Errorf("remap=%v: imported reply = %+v", remap, got[0])
+		}
+	}
+}
+
+func TestImportConflictsAndOrder(t *testing.T) {
+	repo := newTestRepo(0)
+	root := post(t, repo, nil, "root")
+	svc := newTestService(repo)
+
+	doc, err := svc.ExportThread(context.Background(), DefaultThread)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.ImportThread(context.Background(), "copy", *doc, false); !errors.Is(err, ErrImportConflict) {
+		t.Errorf("import with taken IDs: err = %v, want ErrImportConflict", err)
+	}
+	if _, err := svc.ImportThread(context.Background(), "copy", *doc, true); err != nil {
+		t.Errorf("import with remapped IDs: %v", err)
+	}
+
+	orphan := *doc
+	orphan.Comments = []e.ExportedComment{{CommentID: uuid.New(), ParentID: &root.CommentID, Comment: "orphan"}}
+	if _, err := svc.ImportThread(context.Background(), "copy", orphan, true); !errors.Is(err, ErrInvalidImport) {
+		t.Errorf("reply before its parent: err = %v, want ErrInvalidImport", err)
+	}
+}
+
+func TestEditAndSoftDelete(t *testing.T) {
+	repo := newTestRepo(0)
+	author := e.User{UserID: uuid.New(), UserName: "alice", Role: e.RoleUser}
+	other := e.User{UserID: uuid.New(), UserName: "bob", Role: e.RoleUser}
+	repo.AddUser(author)
+	repo.AddUser(other)
+	svc := newTestService(repo)
+	ctx := context.Background()
+
+	c, err := svc.Comments(ctx, e.CommentResponse{Comment: "first"}, &author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := c.CommentID.String()
+
+	if _, err := svc.EditComment(ctx, id, "changed", &other); !errors.Is(err, ErrForbidden) {
+		t.Errorf("edit by another user: err = %v, want ErrForbidden", err)
+	}
+	edited, err := svc.EditComment(ctx, id, "hi @Bob", &author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.EditedAt == nil || edited.HTML == "" {
+		t.Errorf("edited comment = %+v, want edited_at and HTML set", edited)
+	}
+	if got := repo.Mentions(c.CommentID); len(got) != 1 || got[0] != other.UserID {
+		t.Errorf("mentions = %v, want [%v]", got, other.UserID)
+	}
+
+	if err := svc.SoftDeleteComment(ctx, id, &author); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.EditComment(ctx, id, "again", &author); !errors.Is(err, ErrCommentDeleted) {
+		t.Errorf("edit after delete: err = %v, want ErrCommentDeleted", err)
+	}
+	if _, err := svc.GetRevisions(ctx, id, false); !errors.Is(err, ErrCommentDeleted) {
+		t.Errorf("revisions of a deleted comment: err = %v, want ErrCommentDeleted", err)
+	}
+
+	revisions, err := svc.GetRevisions(ctx, id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bodies []string
+	for _, r := range revisions {
+		bodies = append(bodies, r.Comment)
+	}
+	if want := []string{"first", "hi @Bob"}; !equal(bodies, want) {
+		t.Errorf("revisions = %v, want %v", bodies, want)
+	}
+	if got := repo.Mentions(c.CommentID); len(got) != 0 {
+		t.Errorf("deleted comment still mentions %v", got)
+	}
+}
+
+func TestEditRunsFilters(t *testing.T) {
+	repo := newTestRepo(0)
+	author := e.User{UserID: uuid.New(), UserName: "alice", Role: e.RoleUser}
+	repo.AddUser(author)
+	svc := NewCommentsService(repo, filter.NewPipeline(
+		filter.BannedWords{Words: []string{"spam"}, Action: filter.Reject},
+		filter.LinkLimit{Max: 0, Action: filter.Hold},
+	))
+	ctx := context.Background()
+
+	c, err := svc.Comments(ctx, e.CommentResponse{Comment: "hello"}, &author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := c.CommentID.String()
+
+	if _, err := svc.EditComment(ctx, id, "buy spam", &author); !errors.Is(err, ErrRejected) {
+		t.Errorf("edit with a banned word: err = %v, want ErrRejected", err)
+	}
+	edited, err := svc.EditComment(ctx, id, "see https://example.com", &author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.Status != e.StatusPending || edited.StatusReason == "" {
+		t.Errorf("edit with a link: status = %q (%q), want pending", edited.Status, edited.StatusReason)
+	}
+
+	if err := svc.Approve(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	edited, err = svc.EditComment(ctx, id, "hello again", &author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.Status != e.StatusApproved {
+		t.Errorf("clean edit: status = %q, want approved", edited.Status)
+	}
+}
+
+func TestInvalidInput(t *testing.T) {
+	repo := newTestRepo(0)
+	author := e.User{UserID: uuid.New(), UserName: "alice", Role: e.RoleUser}
+	repo.AddUser(author)
+	svc := newTestService(repo)
+	ctx := context.Background()
+
+	for name, err := range map[string]error{
+		"comment with an empty body": func() error {
+			_, err := svc.Comments(ctx, e.CommentResponse{}, &author)
+			return err
+		}(),
+		"edit of a bad ID": func() error {
+			_, err := svc.EditComment(ctx, "not-a-uuid", "text", &author)
+			return err
+		}(),
+		"edit with an empty body": func() error {
+			_, err := svc.EditComment(ctx, uuid.NewString(), " ", &author)
+			return err
+		}(),
+		"children with a bad cursor": func() error {
+			_, err := svc.GetChildren(ctx, uuid.NewString(), "!", 10, SortOldest)
+			return err
+		}(),
+		"delete of a bad ID": svc.DeleteComments(ctx, "42"),
+	} {
+		if !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("%s: err = %v, want ErrInvalidInput", name, err)
+		}
+	}
+}
+
+func TestVoteAndReact(t *testing.T) {
+	repo := newTestRepo(0)
+	root := post(t, repo, nil, "root")
+	u := e.User{UserID: uuid.New(), UserName: "alice"}
+	repo.AddUser(u)
+	svc := newTestService(repo)
+	ctx := context.Background()
+	id := root.CommentID.String()
+
+	for _, tc := range []struct {
+		value    int
+		up, down int
+	}{
+		{1, 1, 0},
+		{1, 1, 0},
+		{-1, 0, 1},
+		{0, 0, 0},
+	} {
+		c, err := svc.Vote(ctx, id, tc.value, &u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Upvotes != tc.up || c.Downvotes != tc.down {
+			t.Errorf("vote %d: up %d down %d, want %d %d", tc.value, c.Upvotes, c.Downvotes, tc.up, tc.down)
+		}
+	}
+
+	if _, err := svc.React(ctx, id, "👍", &u); err != nil {
+		t.Fatal(err)
+	}
+	c, err := svc.React(ctx, id, "🔥", &u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Reactions) != 1 || c.Reactions["🔥"] != 1 {
+		t.Errorf("reactions = %v, want only 🔥", c.Reactions)
+	}
+
+	banned := e.User{UserID: uuid.New(), UserName: "mallory", Banned: true}
+	repo.AddUser(banned)
+	if _, err := svc.Vote(ctx, id, 1, &banned); !errors.Is(err, ErrUserBanned) {
+		t.Errorf("vote by banned user: err = %v, want ErrUserBanned", err)
+	}
+}
+
+func TestQueueAndNotifications(t *testing.T) {
+	repo := newTestRepo(0)
+	alice := e.User{UserID: uuid.New(), UserName: "alice"}
+	bob := e.User{UserID: uuid.New(), UserName: "bob"}
+	repo.AddUser(alice)
+	repo.AddUser(bob)
+	svc := newTestService(repo)
+	ctx := context.Background()
+
+	root, err := svc.Comments(ctx, e.CommentResponse{Comment: "root"}, &alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := svc.Comments(ctx, e.CommentResponse{Comment: "reply", ParentID: root.CommentID}, &bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.SetStatus(reply.CommentID, e.StatusPending)
+
+	queue, err := svc.GetQueue(ctx, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"reply"}; !equal(texts(queue.Comments), want) {
+		t.Errorf("queue = %v, want %v", texts(queue.Comments), want)
+	}
+
+	page, err := svc.GetNotifications(ctx, &alice, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Notifications) != 0 {
+		t.Errorf("held reply notified: %+v", page.Notifications)
+	}
+
+	if err := svc.Approve(ctx, reply.CommentID.String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Approve(ctx, reply.CommentID.String()); !errors.Is(err, ErrNotPending) {
+		t.Errorf("second approve: err = %v, want ErrNotPending", err)
+	}
+
+	page, err = svc.GetNotifications(ctx, &alice, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Notifications) != 1 || page.Notifications[0].Kind != e.NotifyReply {
+		t.Errorf("notifications = %+v, want one reply", page.Notifications)
+	}
+
+	counts, err := svc.ThreadCounts(ctx, []string{DefaultThread, "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[DefaultThread] != 2 || counts["other"] != 0 {
+		t.Errorf("counts = %v, want 2 in the default thread", counts)
+	}
+}
+
+func TestImportKeepsClosedFlag(t *testing.T) {
+	repo := newTestRepo(0)
+	post(t, repo, nil, "root")
+	svc := newTestService(repo)
+	ctx := context.Background()
+
+	doc, err := svc.ExportThread(ctx, DefaultThread)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.Closed = true
+
+	if err := repo.OpenThread(ctx, "target"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.ImportThread(ctx, "target", *doc, true); err != nil {
+		t.Fatal(err)
+	}
+	th, err := svc.GetThread(ctx, "target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !th.Closed {
+		t.Error("import into an existing thread dropped the closed flag")
+	}
+}
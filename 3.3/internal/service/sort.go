@@ -0,0 +1,151 @@
+package service
+
+import (
+	e "commentTree/internal/entity"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Sort string
+
+const (
+	SortOldest Sort = "oldest"
+	SortNewest Sort = "newest"
+	SortTop    Sort = "top"
+	SortBest   Sort = "best"
+)
+
+func ParseSort(s string) (Sort, error) {
+	switch Sort(s) {
+	case "":
+		return SortOldest, nil
+	case SortOldest, SortNewest, SortTop, SortBest:
+		return Sort(s), nil
+	default:
+		return "", fmt.Errorf("%w: unknown sort %q, expected oldest, newest, top or best", ErrInvalidInput, s)
+	}
+}
+
+func (srt Sort) orderBy() string {
+	switch srt {
+	case SortNewest:
+		return "c.date DESC, c.comment_id DESC"
+	case SortTop:
+		return "c.score DESC, c.date DESC, c.comment_id"
+	case SortBest:
+		return "c.wilson DESC, c.date DESC, c.comment_id"
+	default:
+		return "c.date, c.comment_id"
+	}
+}
+
+// keyset reports whether pages in this order are addressed by the last
+// (date, comment_id) pair; ranked orders change as votes come in, so they
+// page by offset instead.
+func (srt Sort) keyset() bool {
+	return srt == SortOldest || srt == SortNewest
+}
+
+// pageFilter returns the WHERE fragment and arguments that skip everything up
+// to the cursor. Placeholders start at $n.
+func (srt Sort) pageFilter(cur *cursor, n int) (string, []any, int) {
+	if !srt.keyset() {
+		offset := 0
+		if cur != nil {
+			offset = cur.Offset
+		}
+		return "TRUE", nil, offset
+	}
+
+	op := ">"
+	if srt == SortNewest {
+		op = "<"
+	}
+
+	var curDate *time.Time
+	var curID uuid.UUID
+	if cur != nil {
+		curDate, curID = &cur.Date, cur.ID
+	}
+
+	where := fmt.Sprintf("($%d::timestamp IS NULL OR (c.date, c.comment_id) %s ($%d, $%d))", n, op, n, n+1)
+	return where, []any{curDate, curID}, 0
+}
+
+// wilson is the lower bound of the 95% Wilson score interval for the share
+// of upvotes. It must match the wilson column in storage/model.sql.
+func wilson(up, down int) float64 {
+	n := float64(up + down)
+	if n == 0 {
+		return 0
+	}
+	const z = 1.96
+	p := float64(up) / n
+	return (p + z*z/(2*n) - z*math.Sqrt((p*(1-p)+z*z/(4*n))/n)) / (1 + z*z/n)
+}
+
+// less orders comments the way orderBy does in SQL.
+func less(srt Sort, a, b *e.Comments) bool {
+	switch srt {
+	case SortNewest:
+		if !a.Date.Equal(b.Date) {
+			return a.Date.After(b.Date)
+		}
+	case SortTop:
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if !a.Date.Equal(b.Date) {
+			return a.Date.After(b.Date)
+		}
+	case SortBest:
+		if wa, wb := wilson(a.Upvotes, a.Downvotes), wilson(b.Upvotes, b.Downvotes); wa != wb {
+			return wa > wb
+		}
+		if !a.Date.Equal(b.Date) {
+			return a.Date.After(b.Date)
+		}
+	default:
+		if !a.Date.Equal(b.Date) {
+			return a.Date.Before(b.Date)
+		}
+	}
+	// Ties break on the ID in the same direction as orderBy.
+	if srt == SortNewest {
+		return a.CommentID.String() > b.CommentID.String()
+	}
+	return a.CommentID.String() < b.CommentID.String()
+}
+
+// sortSubtree reorders a parent-before-child list so that siblings follow
+// srt while every reply still comes right after its parent's subtree starts.
+func sortSubtree(comments []e.Comments, srt Sort) []e.Comments {
+	roots := BuildTree(comments)
+
+	var sortNodes func(nodes []*e.CommentNode)
+	sortNodes = func(nodes []*e.CommentNode) {
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return less(srt, &nodes[i].Comments, &nodes[j].Comments)
+		})
+		for _, n := range nodes {
+			sortNodes(n.Children)
+		}
+	}
+	sortNodes(roots)
+
+	out := make([]e.Comments, 0, len(comments))
+	var walk func(nodes []*e.CommentNode)
+	walk = func(nodes []*e.CommentNode) {
+		for _, n := range nodes {
+			out = append(out, n.Comments)
+			walk(n.Children)
+		}
+	}
+	walk(roots)
+
+	return out
+}
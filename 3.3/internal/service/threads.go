@@ -0,0 +1,98 @@
+package service
+
+import (
+	e "commentTree/internal/entity"
+	"context"
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultThread holds comments posted without a thread key, including all
+// comments created before threads were introduced.
+const DefaultThread = "default"
+
+// MaxThreadKeyLength is long enough for a page URL.
+const MaxThreadKeyLength = 512
+
+var (
+	ErrThreadNotFound   = errors.New("thread not found")
+	ErrThreadClosed     = errors.New("thread is closed")
+	ErrInvalidThreadKey = errors.New("invalid thread key")
+)
+
+func validThreadKey(key string) error {
+	if key == "" || len(key) > MaxThreadKeyLength || !utf8.ValidString(key) {
+		return ErrInvalidThreadKey
+	}
+	if strings.IndexFunc(key, unicode.IsControl) >= 0 {
+		return ErrInvalidThreadKey
+	}
+	return nil
+}
+
+// GetThreadComments returns one page of root comments of a thread. A thread
+// nobody has commented on yet is simply empty.
+func (s *CommentsService) GetThreadComments(ctx context.Context, key string, after string, limit int, srt Sort) (*e.CommentsPage, error) {
+	if err := validThreadKey(key); err != nil {
+		return nil, err
+	}
+	cur, err := decodeCursor(after)
+	if err != nil {
+		return nil, err
+	}
+	limit = normalizeLimit(limit)
+
+	p := Page{Sort: srt, After: cur, Limit: limit + 1}
+	list, err := s.repo.Roots(ctx, key, p)
+	if err != nil {
+		return nil, err
+	}
+	return makePage(list, limit, srt, p.offset()), nil
+}
+
+// GetThread returns a thread together with the number of published
+// comments in it.
+func (s *CommentsService) GetThread(ctx context.Context, key string) (*e.Thread, error) {
+	if err := validThreadKey(key); err != nil {
+		return nil, err
+	}
+
+	return s.repo.Thread(ctx, key)
+}
+
+// ThreadCounts returns the number of published comments for each of keys,
+// e.g. to show counters next to a list of articles. Unknown keys count as
+// zero.
+func (s *CommentsService) ThreadCounts(ctx context.Context, keys []string) (map[string]int, error) {
+	counts := make(map[string]int, len(keys))
+	for _, key := range keys {
+		if err := validThreadKey(key); err != nil {
+			return nil, err
+		}
+		counts[key] = 0
+	}
+	if len(keys) == 0 {
+		return counts, nil
+	}
+
+	found, err := s.repo.ThreadCounts(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	for key, n := range found {
+		counts[key] = n
+	}
+	return counts, nil
+}
+
+// SetThreadClosed closes a thread for new comments or reopens it. A thread
+// can be closed before anyone has commented on it.
+func (s *CommentsService) SetThreadClosed(ctx context.Context, key string, closed bool) error {
+	if err := validThreadKey(key); err != nil {
+		return err
+	}
+
+	return s.repo.SetThreadClosed(ctx, key, closed)
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	e "commentTree/internal/entity"
+	"strings"
+)
+
+// BuildTree nests a flat, path-ordered list of comments using their ltree
+// paths. A comment whose parent path is not in the list becomes a top-level
+// node, so any subtree or depth-limited slice of one can be passed in.
+func BuildTree(comments []e.Comments) []*e.CommentNode {
+	byPath := make(map[string]*e.CommentNode, len(comments))
+	roots := []*e.CommentNode{}
+
+	for _, c := range comments {
+		node := &e.CommentNode{Comments: c, Children: []*e.CommentNode{}}
+		byPath[c.Path] = node
+
+		parent := byPath[parentPath(c.Path)]
+		if parent == nil {
+			roots = append(roots, node)
+			continue
+		}
+		parent.Children = append(parent.Children, node)
+	}
+
+	return roots
+}
+
+func parentPath(path string) string {
+	i := strings.LastIndexByte(path, '.')
+	if i < 0 {
+		return ""
+	}
+	return path[:i]
+}
+
+// pathDepth is the depth of a comment below its thread root.
+func pathDepth(path string) int {
+	return strings.Count(path, ".")
+}
+
+// isAncestorPath reports whether path is anc or lies below it, like the
+// ltree @> operator.
+func isAncestorPath(anc, path string) bool {
+	return path == anc || strings.HasPrefix(path, anc+".")
+}
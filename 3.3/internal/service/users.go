@@ -0,0 +1,144 @@
+package service
+
+import (
+	"commentTree/internal/auth"
+	e "commentTree/internal/entity"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrUserExists         = errors.New("user already exists")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrUserBanned         = errors.New("user is banned")
+	ErrBanModerator       = errors.New("moderators cannot be banned")
+)
+
+type UsersService struct {
+	db *sql.DB
+	tp *auth.TokenProvider
+}
+
+func NewUsersService(db *sql.DB, tp *auth.TokenProvider) *UsersService {
+	return &UsersService{
+		db: db,
+		tp: tp,
+	}
+}
+
+func (s *UsersService) Register(ctx context.Context, c e.Credentials) (*e.User, error) {
+	c.UserName = strings.TrimSpace(c.UserName)
+	if c.UserName == "" || len(c.Password) < 6 {
+		return nil, fmt.Errorf("%w: username is required and password must be at least 6 characters", ErrInvalidInput)
+	}
+
+	return s.create(ctx, c, e.RoleUser)
+}
+
+func (s *UsersService) Login(ctx context.Context, c e.Credentials) (string, error) {
+	u, err := s.getByName(ctx, c.UserName)
+	if errors.Is(err, ErrUserNotFound) {
+		return "", ErrInvalidCredentials
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(u.PassHash), []byte(c.Password)) != nil {
+		return "", ErrInvalidCredentials
+	}
+	if u.Banned {
+		return "", ErrUserBanned
+	}
+
+	return s.tp.Generate(u)
+}
+
+// EnsureModerator creates the bootstrap moderator account from the config
+// if it does not exist yet.
+func (s *UsersService) EnsureModerator(ctx context.Context, name, password string) error {
+	if name == "" || password == "" {
+		return nil
+	}
+
+	_, err := s.getByName(ctx, name)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ErrUserNotFound) {
+		return err
+	}
+
+	_, err = s.create(ctx, e.Credentials{UserName: name, Password: password}, e.RoleModerator)
+	return err
+}
+
+func (s *UsersService) SetBanned(ctx context.Context, stringID string, banned bool) error {
+	id, err := uuid.Parse(stringID)
+	if err != nil {
+		return fmt.Errorf("%w: user ID: %v", ErrInvalidInput, err)
+	}
+
+	var role e.Role
+	err = s.db.QueryRowContext(ctx, `SELECT role FROM users WHERE user_id = $1;`, id).Scan(&role)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if role == e.RoleModerator {
+		return ErrBanModerator
+	}
+
+	_, err = s.db.ExecContext(ctx, `UPDATE users SET banned = $2 WHERE user_id = $1;`, id, banned)
+	return err
+}
+
+func (s *UsersService) create(ctx context.Context, c e.Credentials, role e.Role) (*e.User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(c.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &e.User{UserName: c.UserName, Role: role}
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO users (user_name, pass_hash, role)
+		VALUES ($1, $2, $3)
+		RETURNING user_id, created_at;
+	`, c.UserName, string(hash), role).Scan(&u.UserID, &u.CreatedAt)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrUserExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (s *UsersService) getByName(ctx context.Context, name string) (*e.User, error) {
+	var u e.User
+	err := s.db.QueryRowContext(ctx, `
+		SELECT user_id, user_name, pass_hash, role, banned, created_at
+		FROM users
+		WHERE user_name = $1;
+	`, name).Scan(&u.UserID, &u.UserName, &u.PassHash, &u.Role, &u.Banned, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	e "commentTree/internal/entity"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+var ErrUnknownReaction = errors.New("unknown reaction")
+
+var allowedReactions = map[string]bool{
+	"👍": true, "👎": true, "❤️": true, "😂": true,
+	"😮": true, "😢": true, "🎉": true, "🔥": true,
+}
+
+// Vote records an upvote (1) or downvote (-1) from u, replacing any earlier
+// vote; 0 withdraws it. The counters on the comment row are updated in the
+// same transaction so sorting never needs an aggregate.
+func (s *CommentsService) Vote(ctx context.Context, stringID string, value int, u *e.User) (*e.Comments, error) {
+	if value < -1 || value > 1 {
+		return nil, fmt.Errorf("%w: vote must be -1, 0 or 1", ErrInvalidInput)
+	}
+
+	id, err := s.votable(ctx, stringID, u)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.Vote(ctx, id, u.UserID, value)
+}
+
+// React sets the single reaction of u on a comment; an empty emoji removes it.
+func (s *CommentsService) React(ctx context.Context, stringID string, emoji string, u *e.User) (*e.Comments, error) {
+	if emoji != "" && !allowedReactions[emoji] {
+		return nil, ErrUnknownReaction
+	}
+
+	id, err := s.votable(ctx, stringID, u)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.React(ctx, id, u.UserID, emoji)
+}
+
+// votable parses the comment ID and makes sure u may vote or react.
+func (s *CommentsService) votable(ctx context.Context, stringID string, u *e.User) (uuid.UUID, error) {
+	id, err := uuid.Parse(stringID)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: comment ID: %v", ErrInvalidInput, err)
+	}
+	if err := s.checkNotBanned(ctx, u); err != nil {
+		return uuid.Nil, err
+	}
+	return id, nil
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -1,10 +1,181 @@
 CREATE EXTENSION IF NOT EXISTS ltree;
 
+CREATE TABLE IF NOT EXISTS users (
+    user_id UUID PRIMARY KEY DEFAULT gen_random_uuid (),
+    user_name TEXT NOT NULL UNIQUE,
+    pass_hash TEXT NOT NULL,
+    role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator')),
+    banned BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+-- A thread is the comment section of one external resource such as an
+-- article URL or a product ID. Threads are created by their first comment.
+CREATE TABLE IF NOT EXISTS threads (
+    thread_key TEXT PRIMARY KEY,
+    closed BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+INSERT INTO threads (thread_key) VALUES ('default') ON CONFLICT DO NOTHING;
+
 CREATE TABLE IF NOT EXISTS comments (
     comment_id UUID PRIMARY KEY DEFAULT gen_random_uuid (),
     parent_id UUID REFERENCES comments (comment_id) ON DELETE CASCADE,
     user_name TEXT,
     comment TEXT,
+    -- Sanitised HTML rendered from the Markdown in comment.
+    comment_html TEXT NOT NULL DEFAULT '',
     path LTREE,
+    thread_key TEXT NOT NULL DEFAULT 'default' REFERENCES threads (thread_key) ON DELETE CASCADE,
+    date TIMESTAMP DEFAULT NOW(),
+    deleted BOOLEAN NOT NULL DEFAULT FALSE,
+    edited_at TIMESTAMP,
+    author_id UUID REFERENCES users (user_id) ON DELETE SET NULL,
+    hidden BOOLEAN NOT NULL DEFAULT FALSE,
+    locked BOOLEAN NOT NULL DEFAULT FALSE,
+    status TEXT NOT NULL DEFAULT 'approved' CHECK (status IN ('approved', 'pending', 'rejected')),
+    status_reason TEXT NOT NULL DEFAULT '',
+    upvotes INT NOT NULL DEFAULT 0,
+    downvotes INT NOT NULL DEFAULT 0,
+    score INT GENERATED ALWAYS AS (upvotes - downvotes) STORED,
+    -- Lower bound of the 95% Wilson score interval, see wilson() in
+    -- internal/service/sort.go.
+    wilson DOUBLE PRECISION GENERATED ALWAYS AS (
+        CASE WHEN upvotes + downvotes = 0 THEN 0 ELSE
+            (upvotes::float8 / (upvotes + downvotes) + 1.9208 / (upvotes + downvotes)
+             - 1.96 * sqrt((upvotes::float8 / (upvotes + downvotes) * (1 - upvotes::float8 / (upvotes + downvotes))
+                           + 0.9604 / (upvotes + downvotes)) / (upvotes + downvotes)))
+            / (1 + 3.8416 / (upvotes + downvotes))
+        END
+    ) STORED,
+    reactions JSONB NOT NULL DEFAULT '{}',
+    search_vector TSVECTOR GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple', COALESCE(user_name, '')), 'A') ||
+        setweight(to_tsvector('russian', COALESCE(comment, '')), 'B') ||
+        setweight(to_tsvector('english', COALESCE(comment, '')), 'B')
+    ) STORED
+);
+
+CREATE TABLE IF NOT EXISTS comment_revisions (
+    revision_id UUID PRIMARY KEY DEFAULT gen_random_uuid (),
+    comment_id UUID NOT NULL REFERENCES comments (comment_id) ON DELETE CASCADE,
+    comment TEXT,
     date TIMESTAMP DEFAULT NOW()
-);
\ No newline at end of file
+);
+
+CREATE INDEX IF NOT EXISTS idx_comment_revisions_comment_id ON comment_revisions (comment_id, date);
+CREATE TABLE IF NOT EXISTS comment_votes (
+    comment_id UUID NOT NULL REFERENCES comments (comment_id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    value SMALLINT NOT NULL CHECK (value IN (-1, 1)),
+    PRIMARY KEY (comment_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS comment_reactions (
+    comment_id UUID NOT NULL REFERENCES comments (comment_id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    emoji TEXT NOT NULL,
+    PRIMARY KEY (comment_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS comment_mentions (
+    comment_id UUID NOT NULL REFERENCES comments (comment_id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    PRIMARY KEY (comment_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_comment_mentions_user_id ON comment_mentions (user_id);
+CREATE TABLE IF NOT EXISTS notification_settings (
+    user_id UUID PRIMARY KEY REFERENCES users (user_id) ON DELETE CASCADE,
+    email TEXT NOT NULL DEFAULT '',
+    replies BOOLEAN NOT NULL DEFAULT TRUE,
+    mentions BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+CREATE TABLE IF NOT EXISTS thread_subscriptions (
+    thread_key TEXT NOT NULL REFERENCES threads (thread_key) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    PRIMARY KEY (thread_key, user_id)
+);
+
+-- Notifications waiting to be delivered by the outbound sender. Rows stay
+-- after delivery for the record.
+CREATE TABLE IF NOT EXISTS notification_outbox (
+    notification_id UUID PRIMARY KEY DEFAULT gen_random_uuid (),
+    user_id UUID NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    comment_id UUID NOT NULL REFERENCES comments (comment_id) ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('reply', 'mention', 'thread')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMP,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    -- Set while a dispatcher is sending the notification; a row whose lease
+    -- has run out is picked up again.
+    locked_until TIMESTAMP,
+    UNIQUE (user_id, comment_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_outbox_unsent ON notification_outbox (created_at) WHERE sent_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_comments_author_id ON comments (author_id, date);
+CREATE INDEX IF NOT EXISTS idx_comments_pending ON comments (date, comment_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_comments_path ON comments USING GIST (path);
+CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments (parent_id, date, comment_id);
+CREATE INDEX IF NOT EXISTS idx_comments_search ON comments USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_comments_roots ON comments (thread_key, date, comment_id) WHERE parent_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_comments_thread ON comments (thread_key) WHERE status = 'approved';
+
+-- Every instance of the service LISTENs on comment_events and forwards the
+-- changes to its subscribers. Only published comments produce events; votes
+-- and reactions do not. A moved comment also names the place it left, so
+-- subscribers of either place hear about it.
+CREATE OR REPLACE FUNCTION notify_comment_change() RETURNS trigger AS $$
+DECLARE
+    rec comments;
+    kind TEXT;
+    old_thread_key TEXT;
+    old_path TEXT;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        IF OLD.status <> 'approved' THEN
+            RETURN NULL;
+        END IF;
+        rec := OLD;
+        kind := 'deleted';
+    ELSE
+        IF NEW.status <> 'approved' THEN
+            RETURN NULL;
+        END IF;
+        rec := NEW;
+        IF TG_OP = 'INSERT' OR OLD.status <> 'approved' THEN
+            kind := 'created';
+        ELSIF NEW.path IS DISTINCT FROM OLD.path OR NEW.parent_id IS DISTINCT FROM OLD.parent_id
+              OR NEW.thread_key <> OLD.thread_key THEN
+            kind := 'moved';
+            old_thread_key := OLD.thread_key;
+            old_path := OLD.path::text;
+        ELSIF NEW.deleted AND NOT OLD.deleted THEN
+            kind := 'deleted';
+        ELSIF NEW.comment IS DISTINCT FROM OLD.comment OR NEW.hidden <> OLD.hidden THEN
+            kind := 'edited';
+        ELSE
+            RETURN NULL;
+        END IF;
+    END IF;
+
+    PERFORM pg_notify('comment_events', json_build_object(
+        'type', kind,
+        'comment_id', rec.comment_id,
+        'parent_id', rec.parent_id,
+        'thread_key', rec.thread_key,
+        'path', rec.path::text,
+        'old_thread_key', old_thread_key,
+        'old_path', old_path
+    )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER comments_notify
+    AFTER INSERT OR UPDATE OR DELETE ON comments
+    FOR EACH ROW EXECUTE FUNCTION notify_comment_change();
@@ -0,0 +1,30 @@
+// Comment widget loader. Add to a page:
+//
+//   <div data-comments-thread="article-42"></div>
+//   <script src="https://comments.example.com/embed.js" async></script>
+//
+// Every element with data-comments-thread gets an iframe showing that
+// thread. Without a value the page URL is used as the thread key.
+(function () {
+    const script = document.currentScript;
+    const origin = new URL(script.src).origin;
+    const frames = {};
+
+    document.querySelectorAll('[data-comments-thread]').forEach(el => {
+        const key = el.dataset.commentsThread || location.origin + location.pathname;
+        const iframe = document.createElement('iframe');
+        iframe.src = `${origin}/embed/${encodeURIComponent(key)}`;
+        iframe.title = 'Комментарии';
+        iframe.style.width = '100%';
+        iframe.style.border = 'none';
+        iframe.style.minHeight = '300px';
+        el.appendChild(iframe);
+        frames[key] = iframe;
+    });
+
+    window.addEventListener('message', event => {
+        if (event.origin !== origin || !event.data || event.data.type !== 'comments:resize') return;
+        const iframe = frames[event.data.thread];
+        if (iframe) iframe.style.height = `${event.data.height}px`;
+    });
+})();
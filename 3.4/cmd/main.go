@@ -16,8 +16,10 @@ import (
 	"imageprocessor/internal/handler"
 	"imageprocessor/internal/infrastructure/kafka"
 	"imageprocessor/internal/infrastructure/minio"
+	"imageprocessor/internal/infrastructure/postgres"
 	"imageprocessor/internal/interfaces"
 	"imageprocessor/internal/router"
+	"imageprocessor/internal/urlsign"
 	"imageprocessor/internal/usecase"
 )
 
@@ -27,9 +29,9 @@ func main() {
 
 	var wg sync.WaitGroup
 
-	minioRepo, broker, srv := buildServer()
+	svc, broker, srv := buildServer()
 
-	wg.Add(2)
+	wg.Add(3)
 	go func() {
 		defer wg.Done()
 		fmt.Println("Listen and running :8080")
@@ -40,7 +42,12 @@ func main() {
 
 	go func() {
 		defer wg.Done()
-		startWorker(ctx, minioRepo, broker)
+		startWorker(ctx, svc, broker)
+	}()
+
+	go func() {
+		defer wg.Done()
+		sweepUploads(ctx, svc)
 	}()
 
 	<-ctx.Done()
@@ -53,7 +60,7 @@ func main() {
 	wg.Wait()
 }
 
-func buildServer() (interfaces.MinioRepository, interfaces.EventPublisher, *http.Server) {
+func buildServer() (usecase.ImageProcService, interfaces.EventPublisher, *http.Server) {
 	cfg := config.LoadConfigMinio()
 	minioClient := minio.NewMinioRepo(*cfg)
 
@@ -66,28 +73,61 @@ func buildServer() (interfaces.MinioRepository, interfaces.EventPublisher, *http
 		time.Sleep(5 * time.Second)
 	}
 
+	db := postgres.Connect(*config.LoadConfigPostgres())
+	for i := 0; ; i++ {
+		err := postgres.Migrate(context.Background(), db)
+		if err == nil {
+			break
+		}
+		if i == 9 {
+			log.Fatalf("failed to migrate PostgreSQL: %v", err)
+		}
+		log.Printf("Waiting for PostgreSQL to be ready (%d/10): %v", i+1, err)
+		time.Sleep(5 * time.Second)
+	}
+	imageRepo := postgres.NewImageRepo(db)
+	uploadRepo := postgres.NewUploadRepo(db)
+
 	cfgBroker := config.LoadConfigKafka()
 	broker := kafka.NewKafkaBroker(*cfgBroker)
 
 	srvPort := config.LoadConfigServer()
-	svc := usecase.NewImageProcService(minioClient, broker)
-	handler := handler.NewImageProcHandler(svc)
+	svc := usecase.NewImageProcService(minioClient, imageRepo, uploadRepo, broker, cfgBroker.Topic,
+		*config.LoadConfigWatermark(), *config.LoadConfigUpload())
+	signer := urlsign.New(config.LoadConfigSigning())
+	handler := handler.NewImageProcHandler(svc, signer)
 	router := router.NewRouter(handler)
 
-	return minioClient, broker, &http.Server{
+	return svc, broker, &http.Server{
 		Addr:    srvPort,
 		Handler: router,
 	}
 }
 
-func startWorker(ctx context.Context, minioRepo interfaces.MinioRepository, kafkaBroker interfaces.EventPublisher) {
-	kafkaBroker.Consume(ctx, func(key string, value []byte) error {
+func startWorker(ctx context.Context, svc usecase.ImageProcService, kafkaBroker interfaces.EventPublisher) {
+	kafkaBroker.Consume(ctx, func(ctx context.Context, d domain.Delivery) error {
 		var task domain.ImageTask
-		if err := json.Unmarshal(value, &task); err != nil {
-			return err
+		if err := json.Unmarshal(d.Value, &task); err != nil {
+			return fmt.Errorf("%w: decode task: %v", domain.ErrPoisonMessage, err)
 		}
 
-		log.Printf("Processing task: %s, options: %v\n", task.ID, task.Options)
-		return minioRepo.ProcessImage(ctx, task.ID, task.Options...)
+		log.Printf("Processing task: %s, variants: %d, attempt: %d\n", task.ID, len(task.Variants), d.Attempt)
+		return svc.Process(ctx, task, d.Attempt, d.Last)
 	})
 }
+
+// sweepUploads aborts expired uploads every hour until ctx is done.
+func sweepUploads(ctx context.Context, svc usecase.ImageProcService) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if err := svc.ExpireUploads(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("error expire uploads: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
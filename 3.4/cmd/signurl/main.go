@@ -0,0 +1,39 @@
+// Command signurl prints a signed on-the-fly transform URL for an image,
+// using TRANSFORM_SECRET from config.env:
+//
+//	go run ./cmd/signurl -id <image id> -w 640 -h 480 -fit fill -format png
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"imageprocessor/internal/config"
+	"imageprocessor/internal/domain"
+	"imageprocessor/internal/urlsign"
+)
+
+func main() {
+	var (
+		id string
+		t  domain.Transform
+	)
+	flag.StringVar(&id, "id", "", "image ID as returned by /upload")
+	flag.IntVar(&t.Width, "w", 0, "width in pixels, 0 to keep the aspect ratio")
+	flag.IntVar(&t.Height, "h", 0, "height in pixels, 0 to keep the aspect ratio")
+	flag.StringVar(&t.Fit, "fit", "", "fit, fill or stretch")
+	flag.StringVar(&t.Format, "format", "", "jpeg, png, gif or webp, empty for the format of the original")
+	flag.IntVar(&t.Quality, "q", 0, "JPEG quality 1..100")
+	flag.Parse()
+
+	if id == "" {
+		log.Fatal("-id is required")
+	}
+
+	u, err := urlsign.New(config.LoadConfigSigning()).URL(id, t)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(u)
+}
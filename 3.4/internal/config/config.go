@@ -3,10 +3,15 @@ package config
 import (
 	"log"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 
 	"imageprocessor/internal/domain"
+	"imageprocessor/internal/imagecheck"
 )
 
 func LoadConfigMinio() *domain.MinioCfg {
@@ -16,14 +21,27 @@ func LoadConfigMinio() *domain.MinioCfg {
 
 	ssl := os.Getenv("MINIO_USE_SSL") == "1" || os.Getenv("MINIO_USE_SSL") == "true"
 
-	return &domain.MinioCfg{
+	cfg := &domain.MinioCfg{
 		Endpoint:        os.Getenv("MINIO_ENDPOINT"),
+		PublicEndpoint:  os.Getenv("MINIO_PUBLIC_ENDPOINT"),
+		Region:          os.Getenv("MINIO_REGION"),
 		Bucket:          os.Getenv("MINIO_BUCKET"),
 		BucketProcessed: os.Getenv("MINIO_BUCKET_PROC"),
 		AccessKey:       os.Getenv("MINIO_ACCESS_KEY"),
 		SecretKey:       os.Getenv("MINIO_SECRET_KEY"),
 		SSL:             ssl,
+		PublicSSL:       ssl,
 	}
+	if cfg.PublicEndpoint == "" {
+		cfg.PublicEndpoint = cfg.Endpoint
+	}
+	if v := os.Getenv("MINIO_PUBLIC_USE_SSL"); v != "" {
+		cfg.PublicSSL = v == "1" || v == "true"
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	return cfg
 }
 
 func LoadConfigServer() string {
@@ -40,9 +58,178 @@ func LoadConfigKafka() *domain.ConfigBroker {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
 
-	return &domain.ConfigBroker{
-		Broker:  os.Getenv("KAFKA_BROKER"),
-		GroupID: os.Getenv("KAFKA_GROUP"),
-		Topic:   os.Getenv("KAFKA_TOPIC"),
+	cfg := &domain.ConfigBroker{
+		Broker:          os.Getenv("KAFKA_BROKER"),
+		GroupID:         os.Getenv("KAFKA_GROUP"),
+		Topic:           os.Getenv("KAFKA_TOPIC"),
+		RetryTopic:      os.Getenv("KAFKA_RETRY_TOPIC"),
+		DeadLetterTopic: os.Getenv("KAFKA_DLQ_TOPIC"),
+		Workers:         4,
+		MaxAttempts:     5,
+		RetryBackoff:    5 * time.Second,
+		MaxBackoff:      5 * time.Minute,
+	}
+	if cfg.RetryTopic == "" {
+		cfg.RetryTopic = cfg.Topic + "-retry"
+	}
+	if cfg.DeadLetterTopic == "" {
+		cfg.DeadLetterTopic = cfg.Topic + "-dlq"
+	}
+
+	if v := os.Getenv("KAFKA_WORKERS"); v != "" {
+		if cfg.Workers, err = strconv.Atoi(v); err != nil || cfg.Workers <= 0 {
+			log.Fatalf("invalid KAFKA_WORKERS: %q", v)
+		}
+	}
+	if v := os.Getenv("KAFKA_MAX_ATTEMPTS"); v != "" {
+		if cfg.MaxAttempts, err = strconv.Atoi(v); err != nil || cfg.MaxAttempts <= 0 {
+			log.Fatalf("invalid KAFKA_MAX_ATTEMPTS: %q", v)
+		}
+	}
+	if v := os.Getenv("KAFKA_RETRY_BACKOFF"); v != "" {
+		if cfg.RetryBackoff, err = time.ParseDuration(v); err != nil || cfg.RetryBackoff <= 0 {
+			log.Fatalf("invalid KAFKA_RETRY_BACKOFF: %q", v)
+		}
+	}
+	if v := os.Getenv("KAFKA_RETRY_MAX_BACKOFF"); v != "" {
+		if cfg.MaxBackoff, err = time.ParseDuration(v); err != nil || cfg.MaxBackoff < cfg.RetryBackoff {
+			log.Fatalf("invalid KAFKA_RETRY_MAX_BACKOFF: %q", v)
+		}
+	}
+	return cfg
+}
+
+func LoadConfigPostgres() *domain.PostgresCfg {
+	if err := godotenv.Load("config.env"); err != nil {
+		log.Fatalf("Error loading .env file: %v", err)
+	}
+
+	return &domain.PostgresCfg{
+		Host:     os.Getenv("POSTGRES_HOST"),
+		Port:     os.Getenv("POSTGRES_PORT"),
+		User:     os.Getenv("POSTGRES_USER"),
+		Password: os.Getenv("POSTGRES_PASSWORD"),
+		DB:       os.Getenv("POSTGRES_DB"),
+	}
+}
+
+// LoadConfigSigning returns the secret that on-the-fly transform URLs are
+// signed with.
+func LoadConfigSigning() string {
+	if err := godotenv.Load("config.env"); err != nil {
+		log.Fatalf("Error loading .env file: %v", err)
+	}
+
+	secret := os.Getenv("TRANSFORM_SECRET")
+	if secret == "" {
+		log.Fatalf("TRANSFORM_SECRET is not set")
+	}
+	return secret
+}
+
+func LoadConfigWatermark() *domain.WatermarkCfg {
+	if err := godotenv.Load("config.env"); err != nil {
+		log.Fatalf("Error loading .env file: %v", err)
+	}
+
+	cfg := &domain.WatermarkCfg{
+		Text:     os.Getenv("WATERMARK_TEXT"),
+		Logo:     os.Getenv("WATERMARK_LOGO"),
+		Position: os.Getenv("WATERMARK_POSITION"),
+		Opacity:  0.5,
+		Scale:    0.25,
+		Margin:   16,
+	}
+	if cfg.Text == "" && cfg.Logo == "" {
+		cfg.Text = "imageprocessor"
+	}
+	if cfg.Position == "" {
+		cfg.Position = "bottom-right"
+	}
+
+	var err error
+	if v := os.Getenv("WATERMARK_OPACITY"); v != "" {
+		if cfg.Opacity, err = strconv.ParseFloat(v, 64); err != nil {
+			log.Fatalf("invalid WATERMARK_OPACITY: %v", err)
+		}
+	}
+	if v := os.Getenv("WATERMARK_SCALE"); v != "" {
+		if cfg.Scale, err = strconv.ParseFloat(v, 64); err != nil {
+			log.Fatalf("invalid WATERMARK_SCALE: %v", err)
+		}
+	}
+	if v := os.Getenv("WATERMARK_MARGIN"); v != "" {
+		if cfg.Margin, err = strconv.Atoi(v); err != nil {
+			log.Fatalf("invalid WATERMARK_MARGIN: %v", err)
+		}
+	}
+	return cfg
+}
+
+func LoadConfigUpload() *domain.UploadCfg {
+	if err := godotenv.Load("config.env"); err != nil {
+		log.Fatalf("Error loading .env file: %v", err)
+	}
+
+	cfg := &domain.UploadCfg{
+		MaxSize:        50 << 20,
+		PresignExpiry:  15 * time.Minute,
+		Expiry:         24 * time.Hour,
+		AllowedFormats: []string{"jpeg", "png", "gif", "webp"},
+		MaxWidth:       10000,
+		MaxHeight:      10000,
+		MaxPixels:      50_000_000,
+	}
+
+	var err error
+	if v := os.Getenv("UPLOAD_MAX_SIZE"); v != "" {
+		if cfg.MaxSize, err = strconv.ParseInt(v, 10, 64); err != nil || cfg.MaxSize <= 0 {
+			log.Fatalf("invalid UPLOAD_MAX_SIZE: %q", v)
+		}
+	}
+	if v := os.Getenv("UPLOAD_PRESIGN_EXPIRY"); v != "" {
+		if cfg.PresignExpiry, err = time.ParseDuration(v); err != nil || cfg.PresignExpiry <= 0 {
+			log.Fatalf("invalid UPLOAD_PRESIGN_EXPIRY: %q", v)
+		}
+	}
+	if v := os.Getenv("UPLOAD_EXPIRY"); v != "" {
+		if cfg.Expiry, err = time.ParseDuration(v); err != nil || cfg.Expiry <= 0 {
+			log.Fatalf("invalid UPLOAD_EXPIRY: %q", v)
+		}
+	}
+	// A presigned upload may still arrive until its policy expires, so the
+	// session has to outlive it.
+	if cfg.Expiry <= cfg.PresignExpiry {
+		log.Fatalf("invalid UPLOAD_EXPIRY: %s is not longer than UPLOAD_PRESIGN_EXPIRY", cfg.Expiry)
+	}
+	if v := os.Getenv("UPLOAD_ALLOWED_FORMATS"); v != "" {
+		cfg.AllowedFormats = strings.Split(v, ",")
+		for i, format := range cfg.AllowedFormats {
+			cfg.AllowedFormats[i] = strings.TrimSpace(format)
+			if !slices.Contains(imagecheck.Formats, cfg.AllowedFormats[i]) {
+				log.Fatalf("invalid UPLOAD_ALLOWED_FORMATS: unknown format %q", format)
+			}
+		}
+	}
+	if v := os.Getenv("UPLOAD_MAX_WIDTH"); v != "" {
+		if cfg.MaxWidth, err = strconv.Atoi(v); err != nil || cfg.MaxWidth <= 0 {
+			log.Fatalf("invalid UPLOAD_MAX_WIDTH: %q", v)
+		}
+	}
+	if v := os.Getenv("UPLOAD_MAX_HEIGHT"); v != "" {
+		if cfg.MaxHeight, err = strconv.Atoi(v); err != nil || cfg.MaxHeight <= 0 {
+			log.Fatalf("invalid UPLOAD_MAX_HEIGHT: %q", v)
+		}
+	}
+	if v := os.Getenv("UPLOAD_MAX_PIXELS"); v != "" {
+		if cfg.MaxPixels, err = strconv.ParseInt(v, 10, 64); err != nil || cfg.MaxPixels <= 0 {
+			log.Fatalf("invalid UPLOAD_MAX_PIXELS: %q", v)
+		}
+	}
+	if v := os.Getenv("UPLOAD_STRIP_EXIF"); v != "" {
+		if cfg.StripExif, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("invalid UPLOAD_STRIP_EXIF: %q", v)
+		}
 	}
+	return cfg
 }
@@ -1,5 +1,7 @@
 package domain
 
+import "time"
+
 type ServerConfig struct {
 	Host string
 	Port string
@@ -7,15 +9,48 @@ type ServerConfig struct {
 
 type MinioCfg struct {
 	Endpoint        string
+	PublicEndpoint  string
+	Region          string
 	Bucket          string
 	BucketProcessed string
 	AccessKey       string
 	SecretKey       string
 	SSL             bool
+	PublicSSL       bool
 }
 
 type ConfigBroker struct {
 	Broker  string
 	GroupID string
 	Topic   string
+	// RetryTopic holds failed tasks until their backoff has passed;
+	// DeadLetterTopic those that failed MaxAttempts times or can never
+	// succeed.
+	RetryTopic      string
+	DeadLetterTopic string
+	Workers         int
+	MaxAttempts     int
+	// RetryBackoff is the delay before the first retry, doubled for each
+	// further one up to MaxBackoff.
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+}
+
+type PostgresCfg struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+	DB       string
+}
+
+// WatermarkCfg holds the watermark settings used where a watermark step
+// does not give its own.
+type WatermarkCfg struct {
+	Text     string
+	Logo     string
+	Position string
+	Opacity  float64
+	Scale    float64
+	Margin   int
 }
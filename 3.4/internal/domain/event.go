@@ -1,6 +1,24 @@
 package domain
 
+import "errors"
+
+// ErrPoisonMessage marks a task that fails however often it is retried,
+// such as one that cannot be decoded. It goes straight to the dead-letter
+// topic.
+var ErrPoisonMessage = errors.New("poison message")
+
 type ImageTask struct {
-	ID      string               `json:"id"`
-	Options []ImageProcessOption `json:"options"`
+	ID       string    `json:"id"`
+	Variants []Variant `json:"variants"`
+}
+
+// Delivery is a task message as handed to a worker.
+type Delivery struct {
+	Key   string
+	Value []byte
+	// Attempt counts deliveries of the task, starting at 1.
+	Attempt int
+	// Last is set on the final attempt: if it fails the task is moved to
+	// the dead-letter topic instead of being retried.
+	Last bool
 }
@@ -1,7 +1,18 @@
 package domain
 
+import "io"
+
 type FileDataType struct {
 	FileName    string
 	ContentType string
 	Data        []byte
 }
+
+// FileStream is an upload that is passed on to storage as it is read.
+// Size is -1 when it is not known in advance.
+type FileStream struct {
+	FileName    string
+	ContentType string
+	Reader      io.Reader
+	Size        int64
+}
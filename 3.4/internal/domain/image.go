@@ -1,13 +1,23 @@
 package domain
 
-import "time"
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrImageNotFound = errors.New("image not found")
+	ErrNotAcceptable = errors.New("no acceptable image format")
+)
 
 type ImageStatus string
 
 const (
-	StatusPending   ImageStatus = "pending"
-	StatusProcessed ImageStatus = "processed"
-	StatusFailed    ImageStatus = "failed"
+	StatusPending    ImageStatus = "pending"
+	StatusProcessing ImageStatus = "processing"
+	StatusRetrying   ImageStatus = "retrying"
+	StatusProcessed  ImageStatus = "processed"
+	StatusFailed     ImageStatus = "failed"
 )
 
 type Image struct {
@@ -15,15 +25,54 @@ type Image struct {
 	FileName    string      `json:"file_name"`
 	ContentType string      `json:"content_type"`
 	Size        int64       `json:"size"`
+	Width       int         `json:"width"`
+	Height      int         `json:"height"`
+	Format      string      `json:"format"`
+	Variants    []Variant   `json:"variants"`
+	Exif        *ImageExif  `json:"exif,omitempty"`
 	Status      ImageStatus `json:"status"`
+	Error       string      `json:"error,omitempty"`
 	CreatedAt   time.Time   `json:"created_at"`
 	UpdatedAt   time.Time   `json:"updated_at"`
 }
 
-type ImageProcessOption int
+// StatusChange is one entry of the processing history of an image.
+type StatusChange struct {
+	Status    ImageStatus `json:"status"`
+	Error     string      `json:"error,omitempty"`
+	ChangedAt time.Time   `json:"changed_at"`
+}
+
+type ImageStatusInfo struct {
+	Image
+	History []StatusChange `json:"history"`
+}
 
-const (
-	ProcessResize ImageProcessOption = iota
-	ProcessThumbnail
-	ProcessWatermark
-)
+type ImageFilter struct {
+	Status ImageStatus
+	Limit  int
+	Offset int
+}
+
+type ImagePage struct {
+	Images []Image `json:"images"`
+	Total  int     `json:"total"`
+	Limit  int     `json:"limit"`
+	Offset int     `json:"offset"`
+}
+
+// ImageExif is what is read from the EXIF block of an original.
+type ImageExif struct {
+	Make        string       `json:"make,omitempty"`
+	Model       string       `json:"model,omitempty"`
+	LensModel   string       `json:"lens_model,omitempty"`
+	TakenAt     *time.Time   `json:"taken_at,omitempty"`
+	GPS         *GPSPosition `json:"gps,omitempty"`
+	Orientation int          `json:"orientation,omitempty"`
+}
+
+type GPSPosition struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"`
+}
@@ -0,0 +1,83 @@
+package domain
+
+import "errors"
+
+var ErrInvalidPipeline = errors.New("invalid pipeline")
+
+type StepOp string
+
+const (
+	OpResize     StepOp = "resize"
+	OpCrop       StepOp = "crop"
+	OpRotate     StepOp = "rotate"
+	OpFlip       StepOp = "flip"
+	OpBlur       StepOp = "blur"
+	OpSharpen    StepOp = "sharpen"
+	OpGrayscale  StepOp = "grayscale"
+	OpBrightness StepOp = "brightness"
+	OpContrast   StepOp = "contrast"
+	OpWatermark  StepOp = "watermark"
+)
+
+// Step is one operation of a variant pipeline. Only the parameters of its
+// operation are used:
+//
+//	resize      Width, Height, Fit (fit, fill or stretch), Gravity for fill
+//	crop        Width, Height, Gravity
+//	rotate      Angle in degrees, counter-clockwise
+//	flip        Direction (horizontal or vertical)
+//	blur        Sigma
+//	sharpen     Sigma
+//	brightness  Amount in percent, -100..100
+//	contrast    Amount in percent, -100..100
+//	watermark   Text or Logo, Position, Opacity, Scale, Margin
+//
+// Watermark parameters that are left out are taken from the server
+// configuration when the variant is accepted. Position is a gravity or
+// "tiled", Scale is the width of the mark relative to the image, Margin
+// is in pixels.
+type Step struct {
+	Op        StepOp  `json:"op"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	Fit       string  `json:"fit,omitempty"`
+	Gravity   string  `json:"gravity,omitempty"`
+	Angle     float64 `json:"angle,omitempty"`
+	Direction string  `json:"direction,omitempty"`
+	Sigma     float64 `json:"sigma,omitempty"`
+	Amount    float64 `json:"amount,omitempty"`
+	Text      string  `json:"text,omitempty"`
+	Logo      string  `json:"logo,omitempty"`
+	Position  string  `json:"position,omitempty"`
+	Opacity   float64 `json:"opacity,omitempty"`
+	Scale     float64 `json:"scale,omitempty"`
+	Margin    int     `json:"margin,omitempty"`
+}
+
+// Variant is a named output produced from the original by running Steps in
+// order. It is stored in the processed bucket as "{Name}/{image ID}".
+// Format is jpeg, png, gif or webp, or empty to keep the format of the
+// original; Quality applies to JPEG and defaults to 90.
+type Variant struct {
+	Name    string `json:"name"`
+	Steps   []Step `json:"steps"`
+	Format  string `json:"format,omitempty"`
+	Quality int    `json:"quality,omitempty"`
+	// Progressive is refused: the standard JPEG encoder only writes
+	// baseline images. It is kept so that asking for it fails loudly.
+	Progressive bool `json:"progressive,omitempty"`
+}
+
+// Presets are the variants behind the resize, thumbnail and watermark
+// upload flags.
+var Presets = map[string]Variant{
+	"resized": {Name: "resized", Steps: []Step{
+		{Op: OpResize, Width: 1080},
+	}},
+	"thumbnail": {Name: "thumbnail", Steps: []Step{
+		{Op: OpResize, Width: 200, Height: 200, Fit: "fill"},
+	}},
+	"watermarked": {Name: "watermarked", Steps: []Step{
+		{Op: OpWatermark},
+	}},
+}
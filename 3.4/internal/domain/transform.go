@@ -0,0 +1,19 @@
+package domain
+
+import "errors"
+
+var (
+	ErrInvalidTransform = errors.New("invalid transform")
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// Transform is a resize requested on the fly through a signed
+// /image/{id}/transform URL. Format is empty to keep the format of the
+// original; Quality only applies to lossy formats.
+type Transform struct {
+	Width   int
+	Height  int
+	Fit     string
+	Format  string
+	Quality int
+}
@@ -0,0 +1,81 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrTooLarge          = errors.New("upload is too large")
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrInvalidImage      = errors.New("invalid image")
+	ErrUploadNotFound    = errors.New("upload not found")
+	ErrInvalidUpload     = errors.New("invalid upload")
+)
+
+type UploadKind string
+
+const (
+	// UploadMultipart uploads are sent in parts through the API and can be
+	// resumed by sending only the missing parts.
+	UploadMultipart UploadKind = "multipart"
+	// UploadPresigned uploads go straight to object storage with a
+	// presigned POST policy.
+	UploadPresigned UploadKind = "presigned"
+)
+
+// UploadRequest starts a multipart or presigned upload.
+type UploadRequest struct {
+	Kind        UploadKind `json:"kind"`
+	FileName    string     `json:"file_name"`
+	ContentType string     `json:"content_type"`
+	Variants    []Variant  `json:"variants"`
+}
+
+// UploadSession is an upload that has been started but not completed.
+// ImageID is the ID the image gets once it is.
+type UploadSession struct {
+	ID          string     `json:"id"`
+	ImageID     string     `json:"image_id"`
+	Kind        UploadKind `json:"kind"`
+	MultipartID string     `json:"-"`
+	FileName    string     `json:"file_name"`
+	ContentType string     `json:"content_type"`
+	Variants    []Variant  `json:"variants"`
+	CreatedAt   time.Time  `json:"created_at"`
+
+	// Parts lists what has arrived so far of a multipart upload.
+	Parts []UploadPart `json:"parts,omitempty"`
+	// Presigned is where to send a presigned upload.
+	Presigned *PresignedUpload `json:"presigned,omitempty"`
+}
+
+type UploadPart struct {
+	Number int    `json:"number"`
+	Size   int64  `json:"size"`
+	ETag   string `json:"etag"`
+}
+
+// PresignedUpload is a form POST to object storage: the file goes in the
+// "file" field after Fields.
+type PresignedUpload struct {
+	URL       string            `json:"url"`
+	Fields    map[string]string `json:"fields"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+type UploadCfg struct {
+	MaxSize       int64
+	PresignExpiry time.Duration
+	// Expiry is how long a started upload may stay incomplete before it
+	// is aborted and whatever was stored for it removed.
+	Expiry         time.Duration
+	AllowedFormats []string
+	MaxWidth       int
+	MaxHeight      int
+	// MaxPixels guards against small files that decode into huge images.
+	MaxPixels int64
+	// StripExif removes EXIF from originals once they are processed, so
+	// that camera and location data is only kept in the image metadata.
+	StripExif bool
+}
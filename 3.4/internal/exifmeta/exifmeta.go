@@ -0,0 +1,282 @@
+package exifmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+	"github.com/rwcarlsen/goexif/exif"
+	"golang.org/x/image/tiff"
+
+	"imageprocessor/internal/domain"
+)
+
+const (
+	markerSOI   = 0xd8
+	markerSOS   = 0xda
+	markerAPP0  = 0xe0
+	markerAPP1  = 0xe1
+	markerAPP13 = 0xed
+)
+
+// Extract reads camera, capture time, GPS position and orientation from
+// the EXIF block of a JPEG or TIFF file. It returns nil if there is none.
+func Extract(data []byte) *domain.ImageExif {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+
+	meta := &domain.ImageExif{
+		Make:        stringTag(x, exif.Make),
+		Model:       stringTag(x, exif.Model),
+		LensModel:   stringTag(x, exif.LensModel),
+		Orientation: orientation(x),
+	}
+	if t, err := x.DateTime(); err == nil {
+		meta.TakenAt = &t
+	}
+	if lat, long, err := x.LatLong(); err == nil && !math.IsNaN(lat) && !math.IsNaN(long) {
+		meta.GPS = &domain.GPSPosition{Latitude: lat, Longitude: long}
+		if tag, err := x.Get(exif.GPSAltitude); err == nil {
+			if num, den, err := tag.Rat2(0); err == nil && den != 0 {
+				alt := float64(num) / float64(den)
+				if ref, err := x.Get(exif.GPSAltitudeRef); err == nil {
+					if below, err := ref.Int(0); err == nil && below == 1 {
+						alt = -alt
+					}
+				}
+				meta.GPS.Altitude = &alt
+			}
+		}
+	}
+	return meta
+}
+
+// Orientation returns the EXIF orientation of a file, 1 to 8, or 0 if it
+// has none.
+func Orientation(data []byte) int {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0
+	}
+	return orientation(x)
+}
+
+// Orient turns img upright according to an EXIF orientation. Decoders
+// ignore the tag, so without this phone photos come out rotated.
+func Orient(img image.Image, o int) image.Image {
+	switch o {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	}
+	return img
+}
+
+// Strip removes the EXIF, XMP and IPTC metadata from an image file.
+// JPEG, PNG and WebP are rewritten without re-encoding; a JPEG keeps its
+// orientation in a minimal EXIF block, as the pixels are stored as shot.
+// TIFF keeps its metadata in the same directory as the pixel layout, so it
+// is re-encoded upright instead. Other formats carry no EXIF and are
+// returned unchanged.
+func Strip(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, markerSOI}):
+		return stripJPEG(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return stripPNG(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return stripWebP(data)
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return stripTIFF(data)
+	}
+	return data, nil
+}
+
+func stripJPEG(data []byte) ([]byte, error) {
+	o := Orientation(data)
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	wroteOrientation := o <= 1
+
+	pos := 2
+	for {
+		// Any number of 0xff fill bytes may precede a marker.
+		for pos+1 < len(data) && data[pos] == 0xff && data[pos+1] == 0xff {
+			pos++
+		}
+		if pos+4 > len(data) || data[pos] != 0xff {
+			return nil, errors.New("strip exif: malformed JPEG segment")
+		}
+		marker := data[pos+1]
+		if marker == markerSOS {
+			if !wroteOrientation {
+				out.Write(orientationSegment(o))
+			}
+			out.Write(data[pos:])
+			return out.Bytes(), nil
+		}
+
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end > len(data) {
+			return nil, fmt.Errorf("strip exif: segment %#x overruns the file", marker)
+		}
+		if marker != markerAPP0 && !wroteOrientation {
+			out.Write(orientationSegment(o))
+			wroteOrientation = true
+		}
+		if marker != markerAPP1 && marker != markerAPP13 {
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// stripPNG drops the eXIf chunk and the text chunks that carry XMP or
+// ImageMagick's raw EXIF and IPTC profiles. Decoders here ignore the PNG
+// orientation, so there is none to keep.
+func stripPNG(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+
+	pos := len(pngSignature)
+	for pos < len(data) {
+		if pos+12 > len(data) {
+			return nil, errors.New("strip exif: malformed PNG chunk")
+		}
+		end := pos + 12 + int(binary.BigEndian.Uint32(data[pos:]))
+		if end > len(data) || end < pos {
+			return nil, fmt.Errorf("strip exif: chunk %q overruns the file", data[pos+4:pos+8])
+		}
+		if !metadataChunk(string(data[pos+4:pos+8]), data[pos+8:end-4]) {
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+	return out.Bytes(), nil
+}
+
+func metadataChunk(typ string, body []byte) bool {
+	switch typ {
+	case "eXIf":
+		return true
+	case "tEXt", "zTXt", "iTXt":
+		keyword, _, _ := bytes.Cut(body, []byte{0})
+		return string(keyword) == "XML:com.adobe.xmp" || bytes.HasPrefix(keyword, []byte("Raw profile type "))
+	}
+	return false
+}
+
+const (
+	vp8xXMP  = 0x04
+	vp8xEXIF = 0x08
+)
+
+// stripWebP drops the EXIF and XMP chunks and clears their flags in the
+// VP8X header. Decoders here ignore the WebP orientation, so there is none
+// to keep.
+func stripWebP(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+
+	pos := 12
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, errors.New("strip exif: malformed WebP chunk")
+		}
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size + size%2
+		if end > len(data) || end < pos {
+			return nil, fmt.Errorf("strip exif: chunk %q overruns the file", data[pos:pos+4])
+		}
+		switch typ := string(data[pos : pos+4]); typ {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			start := out.Len()
+			out.Write(data[pos:end])
+			if size > 0 {
+				out.Bytes()[start+8] &^= vp8xXMP | vp8xEXIF
+			}
+		default:
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+
+	b := out.Bytes()
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+	return b, nil
+}
+
+// stripTIFF re-encodes a TIFF upright, which writes no metadata at all.
+// Only the first page of a multi-page file is kept.
+func stripTIFF(data []byte) ([]byte, error) {
+	img, err := tiff.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("strip exif: %w", err)
+	}
+	var out bytes.Buffer
+	if err := tiff.Encode(&out, Orient(img, Orientation(data)), &tiff.Options{Compression: tiff.Deflate}); err != nil {
+		return nil, fmt.Errorf("strip exif: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// orientationSegment is an APP1 segment holding an EXIF block with the
+// orientation tag only.
+func orientationSegment(o int) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0xff, markerAPP1, 0, 34})
+	b.WriteString("Exif\x00\x00")
+	b.WriteString("MM\x00\x2a")
+	_ = binary.Write(&b, binary.BigEndian, uint32(8)) // offset of IFD0
+	_ = binary.Write(&b, binary.BigEndian, uint16(1)) // one entry
+	_ = binary.Write(&b, binary.BigEndian, []uint16{0x0112, 3})
+	_ = binary.Write(&b, binary.BigEndian, uint32(1))
+	_ = binary.Write(&b, binary.BigEndian, []uint16{uint16(o), 0})
+	_ = binary.Write(&b, binary.BigEndian, uint32(0)) // no next IFD
+	return b.Bytes()
+}
+
+func orientation(x *exif.Exif) int {
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 0
+	}
+	o, err := tag.Int(0)
+	if err != nil || o < 1 || o > 8 {
+		return 0
+	}
+	return o
+}
+
+func stringTag(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	s, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return s
+}
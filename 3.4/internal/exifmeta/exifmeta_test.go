@@ -0,0 +1,170 @@
+package exifmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// gpsExif is an APP1 segment with orientation 6 and a GPS position.
+func gpsExif() []byte {
+	var t bytes.Buffer
+	w := func(v ...any) {
+		for _, x := range v {
+			_ = binary.Write(&t, binary.BigEndian, x)
+		}
+	}
+	t.WriteString("MM\x00\x2a")
+	w(uint32(8))
+	// IFD0 at 8: orientation and the GPS IFD at 38.
+	w(uint16(2))
+	w(uint16(0x0112), uint16(3), uint32(1), uint16(6), uint16(0))
+	w(uint16(0x8825), uint16(4), uint32(1), uint32(38))
+	w(uint32(0))
+	// GPS IFD at 38, its rationals at 92 and 116.
+	w(uint16(4))
+	w(uint16(1), uint16(2), uint32(2), []byte("N\x00\x00\x00"))
+	w(uint16(2), uint16(5), uint32(3), uint32(92))
+	w(uint16(3), uint16(2), uint32(2), []byte("E\x00\x00\x00"))
+	w(uint16(4), uint16(5), uint32(3), uint32(116))
+	w(uint32(0))
+	w([]uint32{52, 1, 30, 1, 0, 1})
+	w([]uint32{13, 1, 24, 1, 0, 1})
+
+	seg := []byte{0xff, markerAPP1, 0, 0}
+	seg = append(seg, "Exif\x00\x00"...)
+	seg = append(seg, t.Bytes()...)
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(seg)-2))
+	return seg
+}
+
+func testJPEG(t *testing.T, segments ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := append([]byte{}, buf.Bytes()[:2]...)
+	for _, s := range segments {
+		data = append(data, s...)
+	}
+	return append(data, buf.Bytes()[2:]...)
+}
+
+// app1Segments returns the APP1 segments of a JPEG before its scan.
+func app1Segments(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	var segs [][]byte
+	pos := 2
+	for pos+4 <= len(data) && data[pos+1] != markerSOS {
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if data[pos+1] == markerAPP1 {
+			segs = append(segs, data[pos:end])
+		}
+		pos = end
+	}
+	return segs
+}
+
+func TestStripJPEG(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"exif", testJPEG(t, gpsExif())},
+		// Fill bytes may precede any marker.
+		{"fill bytes", testJPEG(t, []byte{0xff, 0xff}, gpsExif(), []byte{0xff})},
+	} {
+		if meta := Extract(tc.data); meta == nil || meta.GPS == nil {
+			t.Fatalf("%s: no GPS position in the test image", tc.name)
+		}
+
+		out, err := Strip(tc.data)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		segs := app1Segments(t, out)
+		if len(segs) != 1 || !bytes.Equal(segs[0], orientationSegment(6)) {
+			t.Errorf("%s: APP1 segments = %q, want the orientation block only", tc.name, segs)
+		}
+		if meta := Extract(out); meta == nil || meta.GPS != nil || meta.Orientation != 6 {
+			t.Errorf("%s: metadata after strip = %+v", tc.name, meta)
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+			t.Errorf("%s: stripped file does not decode: %v", tc.name, err)
+		}
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// Insert an eXIf chunk after IHDR; the CRC is not checked here.
+	ihdr := len(pngSignature) + 25
+	exifChunk := append([]byte{0, 0, 0, 4}, "eXIfMM\x00\x2a\x00\x00\x00\x00"...)
+	data = append(append(append([]byte{}, data[:ihdr]...), exifChunk...), data[ihdr:]...)
+
+	out, err := Strip(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, buf.Bytes()) {
+		t.Error("eXIf chunk was not removed")
+	}
+}
+
+func TestStripWebP(t *testing.T) {
+	chunk := func(typ string, body []byte) []byte {
+		c := binary.LittleEndian.AppendUint32([]byte(typ), uint32(len(body)))
+		c = append(c, body...)
+		if len(body)%2 == 1 {
+			c = append(c, 0)
+		}
+		return c
+	}
+	riff := func(chunks ...[]byte) []byte {
+		body := []byte("WEBP")
+		for _, c := range chunks {
+			body = append(body, c...)
+		}
+		return append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body))), body...)
+	}
+	bitstream := chunk("VP8L", []byte{0x2f, 1, 2, 3, 4})
+
+	data := riff(chunk("VP8X", []byte{vp8xEXIF | vp8xXMP | 0x10, 0, 0, 0, 7, 0, 0, 7, 0, 0}),
+		bitstream, chunk("EXIF", []byte("MM\x00")), chunk("XMP ", []byte("<x/>")))
+	want := riff(chunk("VP8X", []byte{0x10, 0, 0, 0, 7, 0, 0, 7, 0, 0}), bitstream)
+
+	out, err := Strip(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, want) {
+		t.Errorf("Strip = %q, want %q", out, want)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.White)
+
+	// Orientation 6 is shot with the camera turned right, so the image is
+	// turned clockwise and the left pixel ends up on top.
+	out := Orient(img, 6)
+	if b := out.Bounds(); b.Dx() != 1 || b.Dy() != 2 {
+		t.Fatalf("bounds = %v, want 1x2", b)
+	}
+	if r, _, _, _ := out.At(0, 0).RGBA(); r != 0xffff {
+		t.Error("top pixel is not the left one")
+	}
+	if Orient(img, 1) != image.Image(img) {
+		t.Error("orientation 1 changed the image")
+	}
+}
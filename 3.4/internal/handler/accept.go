@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"imageprocessor/internal/pipeline"
+)
+
+// acceptedFormats returns the output formats an Accept header allows,
+// highest quality value first, or nil if there is no header. Each format
+// takes the q of the most specific media range matching it.
+func acceptedFormats(header string) []string {
+	if strings.TrimSpace(header) == "" {
+		return nil
+	}
+
+	ranges := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		ranges[mediaType] = q
+	}
+
+	type weighted struct {
+		format string
+		q      float64
+	}
+	var found []weighted
+	for _, format := range pipeline.Formats {
+		contentType := pipeline.ContentType(format)
+		for _, r := range []string{contentType, "image/*", "*/*"} {
+			if q, ok := ranges[r]; ok {
+				if q > 0 {
+					found = append(found, weighted{format, q})
+				}
+				break
+			}
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].q > found[j].q
+	})
+
+	formats := make([]string, len(found))
+	for i, w := range found {
+		formats[i] = w.format
+	}
+	return formats
+}
@@ -2,36 +2,128 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"strconv"
 
 	"github.com/gorilla/mux"
 
 	"imageprocessor/internal/domain"
+	"imageprocessor/internal/pipeline"
+	"imageprocessor/internal/urlsign"
 	"imageprocessor/internal/usecase"
 )
 
+const (
+	// maxFieldSize limits the form fields sent along with an upload.
+	maxFieldSize = 64 << 10
+	maxLogoSize  = 5 << 20
+)
+
+// transformMaxAge is how long clients and proxies may cache a transform.
+// The original never changes, so the same URL always gives the same image.
+const transformMaxAge = 365 * 24 * 60 * 60
+
 type ImageProcHandler struct {
-	svc usecase.ImageProcService
+	svc    usecase.ImageProcService
+	signer *urlsign.Signer
 }
 
-func NewImageProcHandler(svc usecase.ImageProcService) *ImageProcHandler {
+func NewImageProcHandler(svc usecase.ImageProcService, signer *urlsign.Signer) *ImageProcHandler {
 	return &ImageProcHandler{
-		svc: svc,
+		svc:    svc,
+		signer: signer,
 	}
 }
 
+// Upload streams the "file" field of a multipart form to storage without
+// buffering it. The other fields are only seen if they come before the
+// file.
 func (h *ImageProcHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "expected multipart/form-data", http.StatusBadRequest)
+		return
+	}
+
+	form := url.Values{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			http.Error(w, "file not provided", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid form: %v", err), http.StatusBadRequest)
+			return
+		}
+		if part.FormName() == "file" {
+			h.upload(w, r, part, form)
+			return
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxFieldSize+1))
+		if err != nil || len(value) > maxFieldSize {
+			http.Error(w, fmt.Sprintf("invalid form field %q", part.FormName()), http.StatusBadRequest)
+			return
+		}
+		form.Set(part.FormName(), string(value))
+	}
+}
+
+func (h *ImageProcHandler) upload(w http.ResponseWriter, r *http.Request, part *multipart.Part, form url.Values) {
+	variants, err := uploadVariants(form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	file := domain.FileStream{
+		FileName:    part.FileName(),
+		ContentType: part.Header.Get("Content-Type"),
+		Reader:      part,
+		Size:        -1,
+	}
+	id, status, err := h.svc.Upload(r.Context(), file, variants)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("upload failed: %v", err), uploadStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(
+		map[string]string{
+			"File upload": id,
+			"Status":      string(status),
+		}); err != nil {
+		http.Error(w, fmt.Sprintf("upload failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// UploadWatermark stores the "file" form field as a logo for watermark
+// steps to refer to by name.
+func (h *ImageProcHandler) UploadWatermark(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxLogoSize)
 	file, header, err := r.FormFile("file")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, "logo is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
 		http.Error(w, "file not provided", http.StatusBadRequest)
 		return
 	}
-
 	defer func() {
 		if err := file.Close(); err != nil {
-			http.Error(w, fmt.Sprintf("error file close: %v", err), http.StatusInternalServerError)
+			log.Printf("error file close: %v", err)
 		}
 	}()
 
@@ -41,35 +133,17 @@ func (h *ImageProcHandler) Upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var options []domain.ImageProcessOption
-	if r.FormValue("resize") == "true" {
-		options = append(options, domain.ProcessResize)
-	}
-	if r.FormValue("thumbnail") == "true" {
-		options = append(options, domain.ProcessThumbnail)
-	}
-	if r.FormValue("watermark") == "true" {
-		options = append(options, domain.ProcessWatermark)
-	}
-
-	fileData := domain.FileDataType{
+	err = h.svc.UploadWatermark(r.Context(), name, domain.FileDataType{
 		FileName:    header.Filename,
 		Data:        data,
 		ContentType: header.Header.Get("Content-Type"),
-	}
-	id, err := h.svc.Upload(fileData, options...)
+	})
 	if err != nil {
-		http.Error(w, fmt.Sprintf("upload failed: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("upload failed: %v", err), uploadStatus(err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(
-		map[string]string{
-			"File upload": id,
-		}); err != nil {
-		http.Error(w, fmt.Sprintf("upload failed: %v", err), http.StatusInternalServerError)
-	}
+	writeJSON(w, map[string]string{"Watermark": name})
 }
 
 func (h *ImageProcHandler) GetImage(w http.ResponseWriter, r *http.Request) {
@@ -80,7 +154,12 @@ func (h *ImageProcHandler) GetImage(w http.ResponseWriter, r *http.Request) {
 		option = "resized"
 	}
 
-	data, contentType, err := h.svc.Get(id, option)
+	w.Header().Set("Vary", "Accept")
+	data, contentType, err := h.svc.Get(r.Context(), id, option, acceptedFormats(r.Header.Get("Accept")))
+	if errors.Is(err, domain.ErrNotAcceptable) {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("error get image: %v", err), http.StatusNotFound)
 		return
@@ -93,10 +172,122 @@ func (h *ImageProcHandler) GetImage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Transform serves GET /image/{id}/transform?w=&h=&fit=&format=&q=&s=,
+// where s is the signature made by urlsign.Signer.
+func (h *ImageProcHandler) Transform(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	query := r.URL.Query()
+
+	var t domain.Transform
+	var err error
+	if t.Width, err = intParam(query.Get("w")); err != nil {
+		http.Error(w, "invalid w", http.StatusBadRequest)
+		return
+	}
+	if t.Height, err = intParam(query.Get("h")); err != nil {
+		http.Error(w, "invalid h", http.StatusBadRequest)
+		return
+	}
+	if t.Quality, err = intParam(query.Get("q")); err != nil {
+		http.Error(w, "invalid q", http.StatusBadRequest)
+		return
+	}
+	t.Fit = query.Get("fit")
+	t.Format = query.Get("format")
+
+	t, err = pipeline.NormalizeTransform(t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.signer.Verify(id, t, query.Get("s")); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	etag := `"` + pipeline.TransformKey(t) + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		setCacheHeaders(w, etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	data, contentType, err := h.svc.Transform(r.Context(), id, t)
+	if errors.Is(err, domain.ErrImageNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, domain.ErrInvalidTransform) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error transform image: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	setCacheHeaders(w, etag)
+	w.Header().Set("Content-Type", contentType)
+	if _, err := w.Write(data); err != nil {
+		log.Printf("error write data: %v", err)
+	}
+}
+
+func setCacheHeaders(w http.ResponseWriter, etag string) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", transformMaxAge))
+}
+
+func (h *ImageProcHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	info, err := h.svc.Status(r.Context(), id)
+	if errors.Is(err, domain.ErrImageNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error get status: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, info)
+}
+
+func (h *ImageProcHandler) ListImages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := domain.ImageFilter{Status: domain.ImageStatus(query.Get("status"))}
+	switch filter.Status {
+	case "", domain.StatusPending, domain.StatusProcessing, domain.StatusRetrying, domain.StatusProcessed, domain.StatusFailed:
+	default:
+		http.Error(w, "unknown status", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if filter.Limit, err = intParam(query.Get("limit")); err != nil {
+		http.Error(w, "invalid limit", http.StatusBadRequest)
+		return
+	}
+	if filter.Offset, err = intParam(query.Get("offset")); err != nil {
+		http.Error(w, "invalid offset", http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.svc.List(r.Context(), filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error list images: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, page)
+}
+
 func (h *ImageProcHandler) DeleteImage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
-	err := h.svc.DeleteImage(id)
+	err := h.svc.DeleteImage(r.Context(), id)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("error delete image: %v", err), http.StatusInternalServerError)
 	}
@@ -113,3 +304,46 @@ func (h *ImageProcHandler) DeleteImage(w http.ResponseWriter, r *http.Request) {
 func (h *ImageProcHandler) Index(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "web/index.html")
 }
+
+// uploadVariants reads the variants to produce from the "variants" form
+// field, a JSON array of domain.Variant. Without it the resize, thumbnail
+// and watermark flags select the matching presets.
+func uploadVariants(form url.Values) ([]domain.Variant, error) {
+	if spec := form.Get("variants"); spec != "" {
+		var variants []domain.Variant
+		if err := json.Unmarshal([]byte(spec), &variants); err != nil {
+			return nil, fmt.Errorf("invalid variants: %v", err)
+		}
+		return variants, nil
+	}
+
+	var variants []domain.Variant
+	for _, flag := range []struct{ name, preset string }{
+		{"resize", "resized"},
+		{"thumbnail", "thumbnail"},
+		{"watermark", "watermarked"},
+	} {
+		if form.Get(flag.name) == "true" {
+			variants = append(variants, domain.Presets[flag.preset])
+		}
+	}
+	return variants, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error write response: %v", err)
+	}
+}
+
+func intParam(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"imageprocessor/internal/domain"
+)
+
+// StartUpload begins a multipart or presigned upload from a JSON
+// domain.UploadRequest.
+func (h *ImageProcHandler) StartUpload(w http.ResponseWriter, r *http.Request) {
+	var req domain.UploadRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxFieldSize)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	u, err := h.svc.StartUpload(r.Context(), req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error start upload: %v", err), uploadStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		http.Error(w, fmt.Sprintf("error start upload: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// UploadPart stores the request body as one part of a multipart upload.
+// The body has to come with a Content-Length.
+func (h *ImageProcHandler) UploadPart(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	number, err := strconv.Atoi(vars["number"])
+	if err != nil {
+		http.Error(w, "invalid part number", http.StatusBadRequest)
+		return
+	}
+	if r.ContentLength <= 0 {
+		http.Error(w, "Content-Length is required", http.StatusLengthRequired)
+		return
+	}
+
+	part, err := h.svc.UploadPart(r.Context(), vars["id"], number, r.Body, r.ContentLength)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error upload part: %v", err), uploadStatus(err))
+		return
+	}
+
+	writeJSON(w, part)
+}
+
+// UploadStatus lists the parts of a multipart upload that have arrived, so
+// that an interrupted client can send the rest.
+func (h *ImageProcHandler) UploadStatus(w http.ResponseWriter, r *http.Request) {
+	u, err := h.svc.UploadStatus(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error get upload: %v", err), uploadStatus(err))
+		return
+	}
+
+	writeJSON(w, u)
+}
+
+func (h *ImageProcHandler) CompleteUpload(w http.ResponseWriter, r *http.Request) {
+	id, status, err := h.svc.CompleteUpload(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error complete upload: %v", err), uploadStatus(err))
+		return
+	}
+
+	writeJSON(w, map[string]string{
+		"File upload": id,
+		"Status":      string(status),
+	})
+}
+
+func (h *ImageProcHandler) AbortUpload(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := h.svc.AbortUpload(r.Context(), id); err != nil {
+		http.Error(w, fmt.Sprintf("error abort upload: %v", err), uploadStatus(err))
+		return
+	}
+
+	writeJSON(w, map[string]string{"Upload abort": id})
+}
+
+func uploadStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, domain.ErrUnsupportedFormat):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, domain.ErrInvalidImage):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, domain.ErrUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, domain.ErrInvalidUpload), errors.Is(err, domain.ErrInvalidPipeline):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,118 @@
+package imagecheck
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"slices"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+
+	"imageprocessor/internal/domain"
+)
+
+// maxHeaderSize is how far into a file its dimensions have to be found.
+// It leaves room for large EXIF blocks before the JPEG frame header.
+const maxHeaderSize = 1 << 20
+
+// Formats are the formats that can be recognised; the allowlist picks
+// from these.
+var Formats = []string{"jpeg", "png", "gif", "webp", "bmp", "tiff"}
+
+var contentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+	"bmp":  "image/bmp",
+	"tiff": "image/tiff",
+}
+
+type Info struct {
+	Format      string
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// Check reads the start of an upload and rejects it unless its magic bytes
+// name an allowed format and its header declares dimensions within the
+// limits, so that nothing is stored or fully decoded before that is known.
+// The returned reader yields the whole upload again.
+func Check(r io.Reader, cfg domain.UploadCfg) (Info, io.Reader, error) {
+	var head bytes.Buffer
+	src := io.TeeReader(io.LimitReader(r, maxHeaderSize), &head)
+
+	magic := make([]byte, 16)
+	n, err := io.ReadFull(src, magic)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return Info{}, nil, err
+	}
+	magic = magic[:n]
+
+	format := Sniff(magic)
+	if format == "" {
+		return Info{}, nil, fmt.Errorf("%w: not a recognised image", domain.ErrUnsupportedFormat)
+	}
+	if !slices.Contains(cfg.AllowedFormats, format) {
+		return Info{}, nil, fmt.Errorf("%w: %s is not accepted", domain.ErrUnsupportedFormat, format)
+	}
+
+	config, decoded, err := image.DecodeConfig(io.MultiReader(bytes.NewReader(magic), src))
+	if err != nil {
+		return Info{}, nil, fmt.Errorf("%w: %v", domain.ErrInvalidImage, err)
+	}
+	if decoded != format {
+		return Info{}, nil, fmt.Errorf("%w: %s content decodes as %s", domain.ErrInvalidImage, format, decoded)
+	}
+	if err := checkDimensions(config, cfg); err != nil {
+		return Info{}, nil, err
+	}
+
+	info := Info{
+		Format:      format,
+		ContentType: contentTypes[format],
+		Width:       config.Width,
+		Height:      config.Height,
+	}
+	return info, io.MultiReader(&head, r), nil
+}
+
+func checkDimensions(c image.Config, cfg domain.UploadCfg) error {
+	switch {
+	case c.Width <= 0 || c.Height <= 0:
+		return fmt.Errorf("%w: empty image", domain.ErrInvalidImage)
+	case c.Width > cfg.MaxWidth || c.Height > cfg.MaxHeight:
+		return fmt.Errorf("%w: %dx%d exceeds %dx%d", domain.ErrInvalidImage, c.Width, c.Height, cfg.MaxWidth, cfg.MaxHeight)
+	case int64(c.Width)*int64(c.Height) > cfg.MaxPixels:
+		return fmt.Errorf("%w: %d pixels exceeds %d", domain.ErrInvalidImage, int64(c.Width)*int64(c.Height), cfg.MaxPixels)
+	}
+	return nil
+}
+
+// Sniff returns the format the magic bytes at the start of a file belong
+// to, or "" if they are not an image format in Formats.
+func Sniff(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte("\xff\xd8\xff")):
+		return "jpeg"
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(b, []byte("GIF87a")), bytes.HasPrefix(b, []byte("GIF89a")):
+		return "gif"
+	case len(b) >= 12 && bytes.Equal(b[:4], []byte("RIFF")) && bytes.Equal(b[8:12], []byte("WEBP")):
+		return "webp"
+	case bytes.HasPrefix(b, []byte("BM")):
+		return "bmp"
+	case bytes.HasPrefix(b, []byte("II*\x00")), bytes.HasPrefix(b, []byte("MM\x00*")):
+		return "tiff"
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+package imagecheck
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"imageprocessor/internal/domain"
+)
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeGIF(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, w, h), color.Palette{color.Black, color.White}), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSniff(t *testing.T) {
+	for _, tc := range []struct {
+		data string
+		want string
+	}{
+		{"\xff\xd8\xff\xe0", "jpeg"},
+		{"\x89PNG\r\n\x1a\n", "png"},
+		{"GIF89a", "gif"},
+		{"RIFF\x00\x00\x00\x00WEBPVP8 ", "webp"},
+		{"RIFF\x00\x00\x00\x00WAVE", ""},
+		{"BM", "bmp"},
+		{"II*\x00", "tiff"},
+		{"MM\x00*", "tiff"},
+		{"<svg", ""},
+		{"", ""},
+	} {
+		if got := Sniff([]byte(tc.data)); got != tc.want {
+			t.Errorf("Sniff(%q) = %q, want %q", tc.data, got, tc.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	cfg := domain.UploadCfg{
+		AllowedFormats: []string{"png", "jpeg"},
+		MaxWidth:       100,
+		MaxHeight:      100,
+		MaxPixels:      5000,
+	}
+	valid := encodePNG(t, 50, 40)
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"allowed", valid, nil},
+		{"format not allowed", encodeGIF(t, 10, 10), domain.ErrUnsupportedFormat},
+		{"unknown format", []byte("<svg xmlns='http://www.w3.org/2000/svg'/>"), domain.ErrUnsupportedFormat},
+		{"empty", nil, domain.ErrUnsupportedFormat},
+		{"png magic with a gif body", append([]byte("\x89PNG\r\n\x1a\n"), encodeGIF(t, 10, 10)...), domain.ErrInvalidImage},
+		{"too wide", encodePNG(t, 101, 10), domain.ErrInvalidImage},
+		{"too many pixels", encodePNG(t, 100, 100), domain.ErrInvalidImage},
+		{"truncated header", valid[:20], domain.ErrInvalidImage},
+	} {
+		info, r, err := Check(bytes.NewReader(tc.data), cfg)
+		if tc.want != nil {
+			if !errors.Is(err, tc.want) {
+				t.Errorf("%s: err = %v, want %v", tc.name, err, tc.want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if info != (Info{Format: "png", ContentType: "image/png", Width: 50, Height: 40}) {
+			t.Errorf("%s: info = %+v", tc.name, info)
+		}
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, tc.data) {
+			t.Errorf("%s: reader yields %d bytes (err %v), want the %d original bytes", tc.name, len(got), err, len(tc.data))
+		}
+	}
+}
+
+// The header is read in as many pieces as the source hands out, and the
+// reader still replays the file from its first byte.
+func TestCheckReplaysShortReads(t *testing.T) {
+	cfg := domain.UploadCfg{AllowedFormats: []string{"png"}, MaxWidth: 1000, MaxHeight: 1000, MaxPixels: 1 << 20}
+	data := encodePNG(t, 300, 200)
+
+	_, r, err := Check(iotest.OneByteReader(bytes.NewReader(data)), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("reader yields %d bytes (err %v), want the %d original bytes", len(got), err, len(data))
+	}
+}
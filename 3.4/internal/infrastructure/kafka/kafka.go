@@ -2,7 +2,11 @@ package kafka
 
 import (
 	"context"
+	"errors"
 	"log"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 
@@ -10,54 +14,191 @@ import (
 	"imageprocessor/internal/interfaces"
 )
 
+// Headers a retried task carries: how many times it has been delivered,
+// why the last attempt failed and when it may run again.
+const (
+	headerAttempt = "attempt"
+	headerError   = "error"
+	headerRetryAt = "retry-at"
+)
+
 type kafkaBroker struct {
-	writer *kafka.Writer
-	reader *kafka.Reader
-	topic  string
+	writer      *kafka.Writer
+	reader      *kafka.Reader
+	retryReader *kafka.Reader
+	cfg         domain.ConfigBroker
+}
+
+type job struct {
+	msg     kafka.Message
+	reader  *kafka.Reader
+	offsets *offsets
 }
 
 func NewKafkaBroker(cfg domain.ConfigBroker) interfaces.EventPublisher {
 	writer := &kafka.Writer{
-		Addr:     kafka.TCP(cfg.Broker),
-		Balancer: &kafka.LeastBytes{},
+		Addr:                   kafka.TCP(cfg.Broker),
+		Balancer:               &kafka.LeastBytes{},
+		AllowAutoTopicCreation: true,
 	}
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers: []string{cfg.Broker},
 		GroupID: cfg.GroupID,
 		Topic:   cfg.Topic,
 	})
-	return &kafkaBroker{writer: writer, reader: reader, topic: cfg.Topic}
+	// The retry topic is only created by the first retry, so the reader
+	// has to notice its partitions appearing.
+	retryReader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:               []string{cfg.Broker},
+		GroupID:               cfg.GroupID + "-retry",
+		Topic:                 cfg.RetryTopic,
+		WatchPartitionChanges: true,
+	})
+	return &kafkaBroker{writer: writer, reader: reader, retryReader: retryReader, cfg: cfg}
 }
 
-func (k *kafkaBroker) Consume(ctx context.Context, handler func(key string, value []byte) error) {
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Kafka consumer stopping...")
-			if err := k.reader.Close(); err != nil {
-				log.Printf("error kafka consumer stopping: %v", err)
-				return
+func (k *kafkaBroker) Consume(ctx context.Context, handler func(ctx context.Context, d domain.Delivery) error) {
+	jobs := make(chan job)
+
+	var workers sync.WaitGroup
+	for range k.cfg.Workers {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for j := range jobs {
+				k.handle(ctx, j, handler)
 			}
-			return
-		default:
+		}()
+	}
+
+	var fetchers sync.WaitGroup
+	for _, r := range []*kafka.Reader{k.reader, k.retryReader} {
+		fetchers.Add(1)
+		go func() {
+			defer fetchers.Done()
+			k.fetch(ctx, r, jobs)
+		}()
+	}
+
+	fetchers.Wait()
+	close(jobs)
+	workers.Wait()
+
+	log.Println("Kafka consumer stopping...")
+	for _, r := range []*kafka.Reader{k.reader, k.retryReader} {
+		if err := r.Close(); err != nil {
+			log.Printf("error kafka consumer stopping: %v", err)
 		}
+	}
+}
 
-		msg, err := k.reader.ReadMessage(ctx)
+// fetch hands the messages of r to the workers until ctx is done. Retried
+// tasks are held back until their backoff has passed.
+func (k *kafkaBroker) fetch(ctx context.Context, r *kafka.Reader, jobs chan<- job) {
+	offsets := newOffsets()
+	for {
+		msg, err := r.FetchMessage(ctx)
 		if err != nil {
 			if ctx.Err() != nil {
-				log.Println("Kafka consumer stopped due to context cancellation")
 				return
 			}
-			log.Println("Kafka read error:", err)
+			log.Printf("Kafka read error: %v", err)
+			continue
+		}
+
+		if !offsets.add(msg) {
 			continue
 		}
+		if at, ok := retryAt(msg); ok && !sleep(ctx, time.Until(at)) {
+			return
+		}
+
+		select {
+		case jobs <- job{msg: msg, reader: r, offsets: offsets}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handle runs handler on a message and commits it once it has either
+// succeeded or been passed on to the retry or dead-letter topic.
+func (k *kafkaBroker) handle(ctx context.Context, j job, handler func(ctx context.Context, d domain.Delivery) error) {
+	attempt := attemptOf(j.msg)
+	d := domain.Delivery{
+		Key:     string(j.msg.Key),
+		Value:   j.msg.Value,
+		Attempt: attempt,
+		Last:    attempt >= k.cfg.MaxAttempts,
+	}
+
+	if err := handler(ctx, d); err != nil {
+		if ctx.Err() != nil {
+			// Shutting down: the task stays uncommitted and is delivered
+			// again on the next start.
+			log.Printf("task %s interrupted: %v", d.Key, err)
+			return
+		}
+		if err := k.reschedule(ctx, j.msg, d, err); err != nil {
+			log.Printf("error reschedule task %s: %v", d.Key, err)
+			return
+		}
+	}
+
+	if msg, ok := j.offsets.done(j.msg); ok {
+		if err := j.reader.CommitMessages(ctx, msg); err != nil {
+			log.Printf("error commit offset %d of partition %d: %v", msg.Offset, msg.Partition, err)
+		}
+	}
+}
+
+// reschedule moves a failed task to the retry topic, or to the dead-letter
+// topic if it is out of attempts or can never succeed.
+func (k *kafkaBroker) reschedule(ctx context.Context, msg kafka.Message, d domain.Delivery, cause error) error {
+	out := kafka.Message{
+		Key:   msg.Key,
+		Value: msg.Value,
+		Headers: []kafka.Header{
+			{Key: headerError, Value: []byte(cause.Error())},
+		},
+	}
+
+	if d.Last || errors.Is(cause, domain.ErrPoisonMessage) {
+		out.Topic = k.cfg.DeadLetterTopic
+		out.Headers = append(out.Headers, kafka.Header{Key: headerAttempt, Value: []byte(strconv.Itoa(d.Attempt))})
+		log.Printf("task %s moved to %s after %d attempts: %v", d.Key, out.Topic, d.Attempt, cause)
+	} else {
+		delay := k.backoff(d.Attempt)
+		out.Topic = k.cfg.RetryTopic
+		out.Headers = append(out.Headers,
+			kafka.Header{Key: headerAttempt, Value: []byte(strconv.Itoa(d.Attempt + 1))},
+			kafka.Header{Key: headerRetryAt, Value: []byte(time.Now().Add(delay).Format(time.RFC3339Nano))},
+		)
+		log.Printf("task %s failed on attempt %d, retry in %s: %v", d.Key, d.Attempt, delay, cause)
+	}
 
-		if err := handler(string(msg.Key), msg.Value); err != nil {
-			log.Println("Handler error:", err)
+	// Committing without the task written elsewhere would lose it, so
+	// keep trying until it is or the consumer stops.
+	for wait := time.Second; ; wait = min(2*wait, time.Minute) {
+		err := k.writer.WriteMessages(ctx, out)
+		if err == nil {
+			return nil
+		}
+		log.Printf("error write task %s to %s: %v", d.Key, out.Topic, err)
+		if !sleep(ctx, wait) {
+			return ctx.Err()
 		}
 	}
 }
 
+func (k *kafkaBroker) backoff(attempt int) time.Duration {
+	delay := k.cfg.RetryBackoff
+	for i := 1; i < attempt && delay < k.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, k.cfg.MaxBackoff)
+}
+
 func (k *kafkaBroker) Produce(topic, key string, value []byte) error {
 	return k.writer.WriteMessages(context.Background(), kafka.Message{
 		Topic: topic,
@@ -69,3 +210,39 @@ func (k *kafkaBroker) Produce(topic, key string, value []byte) error {
 func (k *kafkaBroker) GetReader() *kafka.Reader {
 	return k.reader
 }
+
+func attemptOf(msg kafka.Message) int {
+	for _, h := range msg.Headers {
+		if h.Key == headerAttempt {
+			if n, err := strconv.Atoi(string(h.Value)); err == nil && n > 0 {
+				return n
+			}
+		}
+	}
+	return 1
+}
+
+func retryAt(msg kafka.Message) (time.Time, bool) {
+	for _, h := range msg.Headers {
+		if h.Key == headerRetryAt {
+			t, err := time.Parse(time.RFC3339Nano, string(h.Value))
+			return t, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+// sleep waits for d and reports whether ctx is still live.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,88 @@
+package kafka
+
+import (
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// offsets tracks the messages fetched from a reader that workers are still
+// handling. Workers finish out of order, and committing an offset commits
+// everything before it on the partition, so a message is only committed
+// once all earlier ones are done.
+//
+// After a rebalance the reader fetches a partition again from its last
+// commit, so messages may come twice. An offset counts as done once any of
+// its deliveries is.
+type offsets struct {
+	mu sync.Mutex
+	// queue holds the uncommitted messages of each partition in the order
+	// they were fetched, and handled whether each of them is done.
+	queue     map[int][]kafka.Message
+	handled   map[position]bool
+	committed map[int]int64
+}
+
+type position struct {
+	partition int
+	offset    int64
+}
+
+func newOffsets() *offsets {
+	return &offsets{
+		queue:     map[int][]kafka.Message{},
+		handled:   map[position]bool{},
+		committed: map[int]int64{},
+	}
+}
+
+// add starts tracking msg and reports whether it still has to be handled;
+// it has not if its offset is already committed.
+func (o *offsets) add(msg kafka.Message) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if c, ok := o.committed[msg.Partition]; ok && msg.Offset <= c {
+		return false
+	}
+	// The partition is fetched again from an earlier offset, so whatever
+	// is queued comes round again.
+	queue := o.queue[msg.Partition]
+	if n := len(queue); n > 0 && msg.Offset <= queue[n-1].Offset {
+		for _, m := range queue {
+			delete(o.handled, position{m.Partition, m.Offset})
+		}
+		queue = nil
+	}
+
+	o.queue[msg.Partition] = append(queue, msg)
+	o.handled[position{msg.Partition, msg.Offset}] = false
+	return true
+}
+
+// done marks msg as handled and returns the last message of its partition
+// that can now be committed, if any.
+func (o *offsets) done(msg kafka.Message) (kafka.Message, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	pos := position{msg.Partition, msg.Offset}
+	if _, ok := o.handled[pos]; !ok {
+		return kafka.Message{}, false
+	}
+	o.handled[pos] = true
+
+	queue := o.queue[msg.Partition]
+	n := 0
+	for n < len(queue) && o.handled[position{queue[n].Partition, queue[n].Offset}] {
+		delete(o.handled, position{queue[n].Partition, queue[n].Offset})
+		n++
+	}
+	o.queue[msg.Partition] = queue[n:]
+	if n == 0 {
+		return kafka.Message{}, false
+	}
+	last := queue[n-1]
+	o.committed[msg.Partition] = last.Offset
+	return last, true
+}
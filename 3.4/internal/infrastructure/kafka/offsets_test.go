@@ -0,0 +1,77 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestOffsets(t *testing.T) {
+	// An op adds a fetched message, which is kept or skipped, or marks one
+	// done, which commits an offset or -1 for none.
+	type op struct {
+		add       bool
+		partition int
+		offset    int64
+		kept      bool
+		commit    int64
+	}
+	add := func(p int, off int64, kept bool) op { return op{add: true, partition: p, offset: off, kept: kept} }
+	done := func(p int, off, commit int64) op { return op{partition: p, offset: off, commit: commit} }
+
+	for _, tc := range []struct {
+		name string
+		ops  []op
+	}{
+		{"in order", []op{
+			add(0, 1, true), add(0, 2, true),
+			done(0, 1, 1), done(0, 2, 2),
+		}},
+		{"out of order", []op{
+			add(0, 1, true), add(0, 2, true), add(0, 3, true),
+			done(0, 3, -1), done(0, 2, -1), done(0, 1, 3),
+		}},
+		{"partitions apart", []op{
+			add(0, 1, true), add(1, 1, true), add(0, 2, true),
+			done(0, 2, -1), done(1, 1, 1), done(0, 1, 2),
+		}},
+		{"done twice", []op{
+			add(0, 1, true), add(0, 2, true),
+			done(0, 1, 1), done(0, 1, -1), done(0, 2, 2),
+		}},
+		{"committed offset fetched again", []op{
+			add(0, 1, true), done(0, 1, 1),
+			add(0, 1, false), add(0, 2, true), done(0, 2, 2),
+		}},
+		{"rebalance fetches from the last commit", []op{
+			add(0, 1, true), add(0, 2, true), add(0, 3, true),
+			done(0, 1, 1),
+			add(0, 2, true), add(0, 3, true),
+			// The first delivery of 3 finishes, then both of 2.
+			done(0, 3, -1), done(0, 2, 3), done(0, 2, -1),
+		}},
+		{"stale done after a rebalance", []op{
+			add(0, 5, true), add(0, 6, true),
+			add(0, 2, true),
+			done(0, 6, -1), done(0, 2, 2),
+		}},
+	} {
+		o := newOffsets()
+		for i, op := range tc.ops {
+			msg := kafka.Message{Partition: op.partition, Offset: op.offset}
+			if op.add {
+				if got := o.add(msg); got != op.kept {
+					t.Errorf("%s: op %d: add(%d/%d) = %v", tc.name, i, op.partition, op.offset, got)
+				}
+				continue
+			}
+			got := int64(-1)
+			if m, ok := o.done(msg); ok {
+				got = m.Offset
+			}
+			if got != op.commit {
+				t.Errorf("%s: op %d: done(%d/%d) commits %d, want %d", tc.name, i, op.partition, op.offset, got, op.commit)
+			}
+		}
+	}
+}
@@ -3,27 +3,29 @@ package minio
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"image"
-	"image/color"
-	"image/draw"
-	"image/jpeg"
-	"image/png"
 	"io"
 	"log"
-	"strings"
 
-	"github.com/disintegration/imaging"
-	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 
 	"imageprocessor/internal/domain"
+	"imageprocessor/internal/exifmeta"
 	"imageprocessor/internal/interfaces"
+	"imageprocessor/internal/pipeline"
 )
 
+// uploadPartSize bounds the memory PutObject buffers per upload when the
+// size is not known in advance.
+const uploadPartSize = 16 << 20
+
 type minioClient struct {
 	client          *minio.Client
+	core            minio.Core
+	public          *minio.Client
 	bucketOriginal  string
 	bucketProcessed string
 }
@@ -32,12 +34,25 @@ func NewMinioRepo(cfg domain.MinioCfg) interfaces.MinioRepository {
 	mc, err := minio.New(cfg.Endpoint, &minio.Options{
 		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
 		Secure: cfg.SSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		log.Fatalf("failed to init MinIO client: %v", err)
+	}
+	// Presigned URLs are signed for the host clients reach MinIO at, which
+	// need not be the one the service uses. Signing needs no connection.
+	public, err := minio.New(cfg.PublicEndpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.PublicSSL,
+		Region: cfg.Region,
 	})
 	if err != nil {
 		log.Fatalf("failed to init MinIO client: %v", err)
 	}
 	return &minioClient{
 		client:          mc,
+		core:            minio.Core{Client: mc},
+		public:          public,
 		bucketOriginal:  cfg.Bucket,
 		bucketProcessed: cfg.BucketProcessed,
 	}
@@ -60,51 +75,34 @@ func (m *minioClient) InitMinio() error {
 	return nil
 }
 
-func (m *minioClient) Create(file domain.FileDataType, options ...domain.ImageProcessOption) (string, error) {
-	ctx := context.Background()
-	id := uuid.New().String()
-	objectName := fmt.Sprintf("%s_%s", id, file.FileName)
-
-	reader := bytes.NewReader(file.Data)
-	_, err := m.client.PutObject(ctx, m.bucketOriginal, objectName, reader, int64(len(file.Data)), minio.PutObjectOptions{
+// Create streams file into the originals bucket and returns its size.
+func (m *minioClient) Create(ctx context.Context, objectName string, file domain.FileStream) (int64, error) {
+	info, err := m.client.PutObject(ctx, m.bucketOriginal, objectName, file.Reader, file.Size, minio.PutObjectOptions{
 		ContentType: file.ContentType,
+		PartSize:    uploadPartSize,
 	})
 	if err != nil {
-		return "", fmt.Errorf("put object: %w", err)
+		return 0, fmt.Errorf("put object: %w", err)
 	}
-	return objectName, nil
+	return info.Size, nil
+}
+
+// OpenOriginal returns a reader for an uploaded original.
+func (m *minioClient) OpenOriginal(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	return m.client.GetObject(ctx, m.bucketOriginal, objectName, minio.GetObjectOptions{})
 }
 
+// Get returns a processed variant with the content type it was stored
+// with.
 func (m *minioClient) Get(objectID, variant string) ([]byte, string, error) {
 	ctx := context.Background()
 	key := fmt.Sprintf("%s/%s", variant, objectID)
-
-	obj, err := m.client.GetObject(ctx, m.bucketProcessed, key, minio.GetObjectOptions{})
-	if err != nil {
-		return nil, "", err
-	}
-	defer func() {
-		if err := obj.Close(); err != nil {
-			log.Printf("error close minio object close: %v", err)
-			return
-		}
-	}()
-
-	buf := new(bytes.Buffer)
-	_, err = io.Copy(buf, obj)
-	if err != nil {
-		return nil, "", err
-	}
-
-	contentType := "image/jpeg"
-	if strings.HasSuffix(strings.ToLower(objectID), ".png") {
-		contentType = "image/png"
-	}
-
-	return buf.Bytes(), contentType, nil
+	return m.read(ctx, m.bucketProcessed, key)
 }
 
-func (m *minioClient) Delete(objectID string) error {
+// Delete removes the original, the given processed variants and all
+// on-the-fly transforms.
+func (m *minioClient) Delete(objectID string, variants ...string) error {
 	ctx := context.Background()
 
 	var errs []error
@@ -115,7 +113,6 @@ func (m *minioClient) Delete(objectID string) error {
 		}
 	}
 
-	var variants = []string{"resized", "thumb", "watermarked"}
 	for _, variant := range variants {
 		key := fmt.Sprintf("%s/%s", variant, objectID)
 		if err := m.client.RemoveObject(ctx, m.bucketProcessed, key, minio.RemoveObjectOptions{}); err != nil {
@@ -125,6 +122,22 @@ func (m *minioClient) Delete(objectID string) error {
 		}
 	}
 
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	transforms := m.client.ListObjects(listCtx, m.bucketProcessed, minio.ListObjectsOptions{
+		Prefix:    transformKey(objectID, ""),
+		Recursive: true,
+	})
+	for obj := range transforms {
+		if obj.Err != nil {
+			errs = append(errs, fmt.Errorf("list transforms failed: %w", obj.Err))
+			break
+		}
+		if err := m.client.RemoveObject(ctx, m.bucketProcessed, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("delete from processed/%s failed: %w", obj.Key, err))
+		}
+	}
+
 	if len(errs) > 0 {
 		return fmt.Errorf("delete errors: %v", errs)
 	}
@@ -132,83 +145,187 @@ func (m *minioClient) Delete(objectID string) error {
 	return nil
 }
 
-func (m *minioClient) ProcessImage(ctx context.Context, objectName string, options ...domain.ImageProcessOption) error {
-	obj, err := m.client.GetObject(ctx, m.bucketOriginal, objectName, minio.GetObjectOptions{})
+// ProcessImage renders the variants of an original and returns the EXIF
+// metadata read from it. Variants are upright and carry no metadata, as the
+// encoders write none.
+func (m *minioClient) ProcessImage(ctx context.Context, objectName string, variants []domain.Variant) (*domain.ImageExif, error) {
+	data, _, err := m.read(ctx, m.bucketOriginal, objectName)
+	if err != nil {
+		return nil, fmt.Errorf("get object: %w", err)
+	}
+	img, format, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	logos := map[string]image.Image{}
+	for _, name := range pipeline.Logos(variants) {
+		logo, _, err := m.decode(ctx, m.bucketOriginal, watermarkKey(name))
+		if err != nil {
+			return nil, fmt.Errorf("watermark logo %s: %w", name, err)
+		}
+		logos[name] = logo
+	}
+
+	for _, variant := range variants {
+		out := pipeline.Apply(img, variant.Steps, logos)
+		key := fmt.Sprintf("%s/%s", variant.Name, objectName)
+		_, _, err := m.store(ctx, key, out, pipeline.OutputFormat(variant.Format, format), variant.Quality)
+		if err != nil {
+			return nil, fmt.Errorf("variant %s: %w", variant.Name, err)
+		}
+	}
+
+	return exifmeta.Extract(data), nil
+}
+
+// StripOriginal rewrites an original without its EXIF, XMP and IPTC
+// metadata, keeping what later transforms need to turn it upright.
+func (m *minioClient) StripOriginal(ctx context.Context, objectName string) error {
+	data, contentType, err := m.read(ctx, m.bucketOriginal, objectName)
 	if err != nil {
 		return fmt.Errorf("get object: %w", err)
 	}
+	stripped, err := exifmeta.Strip(data)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(stripped, data) {
+		return nil
+	}
+
+	_, err = m.client.PutObject(ctx, m.bucketOriginal, objectName, bytes.NewReader(stripped), int64(len(stripped)), minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return fmt.Errorf("put object: %w", err)
+	}
+	return nil
+}
+
+// Transformed returns an on-the-fly transform or conversion stored by
+// Transform or Convert, or domain.ErrImageNotFound if it has not been
+// generated yet.
+func (m *minioClient) Transformed(ctx context.Context, objectID, key string) ([]byte, string, error) {
+	return m.read(ctx, m.bucketProcessed, transformKey(objectID, key))
+}
+
+// Transform generates t from the original and stores it under key in the
+// processed bucket for Transformed.
+func (m *minioClient) Transform(ctx context.Context, objectID, key string, t domain.Transform) ([]byte, string, error) {
+	img, format, err := m.decode(ctx, m.bucketOriginal, objectID)
+	if err != nil {
+		return nil, "", err
+	}
+	out := pipeline.Apply(img, pipeline.TransformSteps(t), nil)
+	return m.store(ctx, transformKey(objectID, key), out, pipeline.OutputFormat(t.Format, format), t.Quality)
+}
+
+// Convert re-encodes a processed variant as format and stores it under key
+// in the processed bucket for Transformed.
+func (m *minioClient) Convert(ctx context.Context, objectID, variant, key, format string) ([]byte, string, error) {
+	img, _, err := m.decode(ctx, m.bucketProcessed, fmt.Sprintf("%s/%s", variant, objectID))
+	if err != nil {
+		return nil, "", err
+	}
+	return m.store(ctx, transformKey(objectID, key), img, format, 0)
+}
+
+func (m *minioClient) read(ctx context.Context, bucket, key string) ([]byte, string, error) {
+	obj, err := m.client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, "", err
+	}
 	defer func() {
 		if err := obj.Close(); err != nil {
 			log.Printf("error close minio object close: %v", err)
-			return
 		}
 	}()
 
-	img, format, err := image.Decode(obj)
+	data, err := io.ReadAll(obj)
 	if err != nil {
-		return fmt.Errorf("decode image: %w", err)
+		return nil, "", notFound(err)
 	}
+	info, err := obj.Stat()
+	if err != nil {
+		return nil, "", notFound(err)
+	}
+	return data, info.ContentType, nil
+}
 
-	for _, option := range options {
-		switch option {
-		case domain.ProcessResize:
-			resized := imaging.Resize(img, 1080, 0, imaging.Lanczos)
-			if err := m.uploadProcessed(ctx, objectName, resized, format, "resized"); err != nil {
-				return err
-			}
-		case domain.ProcessThumbnail:
-			thumb := imaging.Thumbnail(img, 200, 200, imaging.Lanczos)
-			if err := m.uploadProcessed(ctx, objectName, thumb, format, "thumbnail"); err != nil {
-				return err
-			}
-		case domain.ProcessWatermark:
-			watermarked := addWatermark(img)
-			if err := m.uploadProcessed(ctx, objectName, watermarked, format, "watermarked"); err != nil {
-				return err
-			}
+func (m *minioClient) decode(ctx context.Context, bucket, key string) (image.Image, string, error) {
+	data, _, err := m.read(ctx, bucket, key)
+	if err != nil {
+		if errors.Is(err, domain.ErrImageNotFound) {
+			return nil, "", err
 		}
+		return nil, "", fmt.Errorf("get object: %w", err)
 	}
+	return decodeImage(data)
+}
 
-	return nil
+// decodeImage decodes data and turns it upright by its EXIF orientation.
+func decodeImage(data []byte) (image.Image, string, error) {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("decode image: %w", err)
+	}
+	return exifmeta.Orient(img, exifmeta.Orientation(data)), format, nil
 }
 
-func (m *minioClient) uploadProcessed(ctx context.Context, objectName string, img image.Image, format string, variant string) error {
+// store encodes img into the processed bucket under key and returns the
+// encoded data.
+func (m *minioClient) store(ctx context.Context, key string, img image.Image, format string, quality int) ([]byte, string, error) {
 	buf := new(bytes.Buffer)
-	var contentType string
-
-	switch strings.ToLower(format) {
-	case "png":
-		contentType = "image/png"
-		if err := png.Encode(buf, img); err != nil {
-			return fmt.Errorf("encode png: %w", err)
-		}
-	case "jpeg", "jpg":
-		contentType = "image/jpeg"
-		if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 90}); err != nil {
-			return fmt.Errorf("encode jpeg: %w", err)
-		}
-	default:
-		contentType = "image/jpeg"
-		if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 90}); err != nil {
-			return fmt.Errorf("encode default jpeg: %w", err)
-		}
+	contentType, err := pipeline.Encode(buf, img, format, quality)
+	if err != nil {
+		return nil, "", err
 	}
+	data := buf.Bytes()
 
-	key := fmt.Sprintf("%s/%s", variant, objectName)
-	_, err := m.client.PutObject(ctx, m.bucketProcessed, key, buf, int64(buf.Len()), minio.PutObjectOptions{
+	_, err = m.client.PutObject(ctx, m.bucketProcessed, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
 		ContentType: contentType,
 	})
 	if err != nil {
-		return fmt.Errorf("put object failed: %w", err)
+		return nil, "", fmt.Errorf("put object failed: %w", err)
 	}
+	return data, contentType, nil
+}
 
+// PutWatermark stores a logo for watermark steps to refer to by name.
+// Logos live next to the originals, under a prefix no upload can have.
+func (m *minioClient) PutWatermark(ctx context.Context, name string, file domain.FileDataType) error {
+	_, err := m.client.PutObject(ctx, m.bucketOriginal, watermarkKey(name), bytes.NewReader(file.Data), int64(len(file.Data)), minio.PutObjectOptions{
+		ContentType: file.ContentType,
+	})
+	if err != nil {
+		return fmt.Errorf("put object: %w", err)
+	}
 	return nil
 }
 
-func addWatermark(img image.Image) image.Image {
-	nrgba := imaging.Clone(img)
-	bounds := nrgba.Bounds()
-	bar := image.Rect(bounds.Min.X, bounds.Max.Y-40, bounds.Max.X, bounds.Max.Y)
-	draw.Draw(nrgba, bar, &image.Uniform{C: color.RGBA{0, 0, 0, 100}}, image.Point{}, draw.Over)
-	return nrgba
+func (m *minioClient) HasWatermark(ctx context.Context, name string) (bool, error) {
+	_, err := m.client.StatObject(ctx, m.bucketOriginal, watermarkKey(name), minio.StatObjectOptions{})
+	if err != nil {
+		if errors.Is(notFound(err), domain.ErrImageNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func watermarkKey(name string) string {
+	return "watermarks/" + name
+}
+
+func transformKey(objectID, key string) string {
+	return fmt.Sprintf("transform/%s/%s", objectID, key)
+}
+
+func notFound(err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return domain.ErrImageNotFound
+	}
+	return err
 }
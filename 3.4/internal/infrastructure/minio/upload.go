@@ -0,0 +1,114 @@
+package minio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+
+	"imageprocessor/internal/domain"
+)
+
+func (m *minioClient) StartMultipart(ctx context.Context, objectName, contentType string) (string, error) {
+	id, err := m.core.NewMultipartUpload(ctx, m.bucketOriginal, objectName, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return "", fmt.Errorf("start multipart upload: %w", err)
+	}
+	return id, nil
+}
+
+func (m *minioClient) PutPart(ctx context.Context, objectName, uploadID string, number int, r io.Reader, size int64) (domain.UploadPart, error) {
+	part, err := m.core.PutObjectPart(ctx, m.bucketOriginal, objectName, uploadID, number, r, size, minio.PutObjectPartOptions{})
+	if err != nil {
+		return domain.UploadPart{}, uploadError(err)
+	}
+	return domain.UploadPart{Number: part.PartNumber, Size: part.Size, ETag: part.ETag}, nil
+}
+
+func (m *minioClient) ListParts(ctx context.Context, objectName, uploadID string) ([]domain.UploadPart, error) {
+	parts := []domain.UploadPart{}
+	marker := 0
+	for {
+		res, err := m.core.ListObjectParts(ctx, m.bucketOriginal, objectName, uploadID, marker, 1000)
+		if err != nil {
+			return nil, uploadError(err)
+		}
+		for _, p := range res.ObjectParts {
+			parts = append(parts, domain.UploadPart{Number: p.PartNumber, Size: p.Size, ETag: p.ETag})
+		}
+		if !res.IsTruncated {
+			return parts, nil
+		}
+		marker = res.NextPartNumberMarker
+	}
+}
+
+// CompleteMultipart joins the parts into the original and returns its
+// size.
+func (m *minioClient) CompleteMultipart(ctx context.Context, objectName, uploadID string, parts []domain.UploadPart) (int64, error) {
+	complete := make([]minio.CompletePart, len(parts))
+	for i, p := range parts {
+		complete[i] = minio.CompletePart{PartNumber: p.Number, ETag: p.ETag}
+	}
+	info, err := m.core.CompleteMultipartUpload(ctx, m.bucketOriginal, objectName, uploadID, complete, minio.PutObjectOptions{})
+	if err != nil {
+		return 0, uploadError(err)
+	}
+	return info.Size, nil
+}
+
+func (m *minioClient) AbortMultipart(ctx context.Context, objectName, uploadID string) error {
+	if err := m.core.AbortMultipartUpload(ctx, m.bucketOriginal, objectName, uploadID); err != nil {
+		return uploadError(err)
+	}
+	return nil
+}
+
+// PresignUpload returns a POST policy that lets a client store one file
+// of at most maxSize bytes as objectName.
+func (m *minioClient) PresignUpload(ctx context.Context, objectName, contentType string, maxSize int64, expiry time.Duration) (*domain.PresignedUpload, error) {
+	expires := time.Now().Add(expiry).UTC()
+
+	policy := minio.NewPostPolicy()
+	for _, err := range []error{
+		policy.SetBucket(m.bucketOriginal),
+		policy.SetKey(objectName),
+		policy.SetExpires(expires),
+		policy.SetContentLengthRange(1, maxSize),
+		policy.SetContentType(contentType),
+	} {
+		if err != nil {
+			return nil, fmt.Errorf("post policy: %w", err)
+		}
+	}
+
+	u, fields, err := m.public.PresignedPostPolicy(ctx, policy)
+	if err != nil {
+		return nil, fmt.Errorf("presign upload: %w", err)
+	}
+	return &domain.PresignedUpload{URL: u.String(), Fields: fields, ExpiresAt: expires}, nil
+}
+
+// Stat returns the size of an original, or domain.ErrImageNotFound if it
+// has not been uploaded.
+func (m *minioClient) Stat(ctx context.Context, objectName string) (int64, error) {
+	info, err := m.client.StatObject(ctx, m.bucketOriginal, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		return 0, notFound(err)
+	}
+	return info.Size, nil
+}
+
+func uploadError(err error) error {
+	switch resp := minio.ToErrorResponse(err); resp.Code {
+	case "NoSuchUpload":
+		return domain.ErrUploadNotFound
+	case "EntityTooSmall", "InvalidPart", "InvalidPartOrder":
+		return fmt.Errorf("%w: %s", domain.ErrInvalidUpload, resp.Message)
+	}
+	return err
+}
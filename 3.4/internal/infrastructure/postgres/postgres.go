@@ -0,0 +1,237 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	_ "github.com/lib/pq"
+
+	"imageprocessor/internal/domain"
+	"imageprocessor/internal/interfaces"
+	"imageprocessor/storage"
+)
+
+type imageRepo struct {
+	db *sql.DB
+}
+
+func Connect(cfg domain.PostgresCfg) *sql.DB {
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DB)
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		log.Fatalf("failed to init PostgreSQL client: %v", err)
+	}
+	return db
+}
+
+// Migrate applies the schema in storage/model.sql, so that a database
+// created by an earlier version gets the tables and columns added since.
+// Instances starting together take turns under an advisory lock.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('imageprocessor schema'));`); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, storage.Schema); err != nil {
+		return fmt.Errorf("apply schema: %w", err)
+	}
+	return tx.Commit()
+}
+
+func NewImageRepo(db *sql.DB) interfaces.ImageRepository {
+	return &imageRepo{db: db}
+}
+
+const imageColumns = `image_id, file_name, content_type, size, width, height, format, variants, exif, status, error, created_at, updated_at`
+
+func scanImage(row interface{ Scan(...any) error }) (*domain.Image, error) {
+	var img domain.Image
+	var variants, exif []byte
+	err := row.Scan(&img.ID, &img.FileName, &img.ContentType, &img.Size, &img.Width, &img.Height, &img.Format,
+		&variants, &exif, &img.Status, &img.Error, &img.CreatedAt, &img.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(variants, &img.Variants); err != nil {
+		return nil, fmt.Errorf("decode variants: %w", err)
+	}
+	if exif != nil {
+		if err := json.Unmarshal(exif, &img.Exif); err != nil {
+			return nil, fmt.Errorf("decode exif: %w", err)
+		}
+	}
+	return &img, nil
+}
+
+func (r *imageRepo) Create(ctx context.Context, img domain.Image) error {
+	variants, err := json.Marshal(img.Variants)
+	if err != nil {
+		return err
+	}
+	if img.Status == "" {
+		img.Status = domain.StatusPending
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO images (image_id, file_name, content_type, size, width, height, format, variants, status, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+	`, img.ID, img.FileName, img.ContentType, img.Size, img.Width, img.Height, img.Format, variants, img.Status, img.Error)
+	if err != nil {
+		return fmt.Errorf("insert image: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO image_status_history (image_id, status, error) VALUES ($1, $2, $3);
+	`, img.ID, img.Status, img.Error)
+	if err != nil {
+		return fmt.Errorf("insert status: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (r *imageRepo) Get(ctx context.Context, id string) (*domain.Image, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+imageColumns+` FROM images WHERE image_id = $1;`, id)
+	img, err := scanImage(row)
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrImageNotFound
+	}
+	return img, err
+}
+
+func (r *imageRepo) List(ctx context.Context, filter domain.ImageFilter) ([]domain.Image, int, error) {
+	var total int
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM images WHERE ($1 = '' OR status = $1);
+	`, filter.Status).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+imageColumns+`
+		FROM images
+		WHERE ($1 = '' OR status = $1)
+		ORDER BY created_at DESC, image_id
+		LIMIT $2 OFFSET $3;
+	`, filter.Status, filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	images := []domain.Image{}
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		images = append(images, *img)
+	}
+	return images, total, rows.Err()
+}
+
+func (r *imageRepo) History(ctx context.Context, id string) ([]domain.StatusChange, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT status, error, changed_at FROM image_status_history WHERE image_id = $1 ORDER BY id;
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	history := []domain.StatusChange{}
+	for rows.Next() {
+		var c domain.StatusChange
+		if err := rows.Scan(&c.Status, &c.Error, &c.ChangedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, c)
+	}
+	return history, rows.Err()
+}
+
+func (r *imageRepo) SetStatus(ctx context.Context, id string, status domain.ImageStatus, errMsg string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE images SET status = $2, error = $3, updated_at = NOW() WHERE image_id = $1;
+	`, id, status, errMsg)
+	if err != nil {
+		return fmt.Errorf("update status: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrImageNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO image_status_history (image_id, status, error) VALUES ($1, $2, $3);
+	`, id, status, errMsg)
+	if err != nil {
+		return fmt.Errorf("insert status: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (r *imageRepo) SetExif(ctx context.Context, id string, exif domain.ImageExif) error {
+	data, err := json.Marshal(exif)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `
+		UPDATE images SET exif = $2 WHERE image_id = $1 AND exif IS NULL;
+	`, id, data)
+	if err != nil {
+		return fmt.Errorf("update exif: %w", err)
+	}
+	return nil
+}
+
+func (r *imageRepo) Delete(ctx context.Context, id string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM images WHERE image_id = $1;`, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrImageNotFound
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"imageprocessor/internal/domain"
+	"imageprocessor/internal/interfaces"
+)
+
+type uploadRepo struct {
+	db *sql.DB
+}
+
+func NewUploadRepo(db *sql.DB) interfaces.UploadRepository {
+	return &uploadRepo{db: db}
+}
+
+func (r *uploadRepo) Create(ctx context.Context, u domain.UploadSession) error {
+	variants, err := json.Marshal(u.Variants)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, `
+		INSERT INTO uploads (upload_id, image_id, kind, multipart_id, file_name, content_type, variants)
+		VALUES ($1, $2, $3, $4, $5, $6, $7);
+	`, u.ID, u.ImageID, u.Kind, u.MultipartID, u.FileName, u.ContentType, variants)
+	if err != nil {
+		return fmt.Errorf("insert upload: %w", err)
+	}
+	return nil
+}
+
+func (r *uploadRepo) Get(ctx context.Context, id string) (*domain.UploadSession, error) {
+	var u domain.UploadSession
+	var variants []byte
+	err := r.db.QueryRowContext(ctx, `
+		SELECT upload_id, image_id, kind, multipart_id, file_name, content_type, variants, created_at
+		FROM uploads WHERE upload_id = $1;
+	`, id).Scan(&u.ID, &u.ImageID, &u.Kind, &u.MultipartID, &u.FileName, &u.ContentType, &variants, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(variants, &u.Variants); err != nil {
+		return nil, fmt.Errorf("decode variants: %w", err)
+	}
+	return &u, nil
+}
+
+func (r *uploadRepo) Expired(ctx context.Context, before time.Time) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT upload_id FROM uploads WHERE created_at < $1;`, before)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *uploadRepo) Delete(ctx context.Context, id string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM uploads WHERE upload_id = $1;`, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrUploadNotFound
+	}
+	return nil
+}
@@ -4,10 +4,16 @@ import (
 	"context"
 
 	"github.com/segmentio/kafka-go"
+
+	"imageprocessor/internal/domain"
 )
 
 type EventPublisher interface {
-	Consume(ctx context.Context, handler func(key string, value []byte) error)
+	// Consume runs handler on tasks from a pool of workers until ctx is
+	// done. A task is committed once handled, moved to the retry topic if
+	// handler fails, or to the dead-letter topic on its last attempt or
+	// when the error is domain.ErrPoisonMessage.
+	Consume(ctx context.Context, handler func(ctx context.Context, d domain.Delivery) error)
 	Produce(topic, key string, value []byte) error
 	GetReader() *kafka.Reader
 }
@@ -2,14 +2,58 @@ package interfaces
 
 import (
 	"context"
+	"io"
+	"time"
 
 	"imageprocessor/internal/domain"
 )
 
 type MinioRepository interface {
 	InitMinio() error
-	Create(file domain.FileDataType, options ...domain.ImageProcessOption) (string, error)
+	Create(ctx context.Context, objectName string, file domain.FileStream) (int64, error)
+	OpenOriginal(ctx context.Context, objectName string) (io.ReadCloser, error)
+	Stat(ctx context.Context, objectName string) (int64, error)
 	Get(objectID, variant string) ([]byte, string, error)
-	Delete(objectID string) error
-	ProcessImage(ctx context.Context, objectName string, options ...domain.ImageProcessOption) error
+	Delete(objectID string, variants ...string) error
+	// ProcessImage renders the variants of an original and returns its EXIF
+	// metadata, or nil if it has none.
+	ProcessImage(ctx context.Context, objectName string, variants []domain.Variant) (*domain.ImageExif, error)
+	StripOriginal(ctx context.Context, objectName string) error
+	// Transformed returns a stored on-the-fly transform or conversion, or
+	// domain.ErrImageNotFound if there is none under key yet.
+	Transformed(ctx context.Context, objectID, key string) ([]byte, string, error)
+	Transform(ctx context.Context, objectID, key string, t domain.Transform) ([]byte, string, error)
+	Convert(ctx context.Context, objectID, variant, key, format string) ([]byte, string, error)
+	PutWatermark(ctx context.Context, name string, file domain.FileDataType) error
+	HasWatermark(ctx context.Context, name string) (bool, error)
+
+	StartMultipart(ctx context.Context, objectName, contentType string) (string, error)
+	PutPart(ctx context.Context, objectName, uploadID string, number int, r io.Reader, size int64) (domain.UploadPart, error)
+	ListParts(ctx context.Context, objectName, uploadID string) ([]domain.UploadPart, error)
+	CompleteMultipart(ctx context.Context, objectName, uploadID string, parts []domain.UploadPart) (int64, error)
+	AbortMultipart(ctx context.Context, objectName, uploadID string) error
+	PresignUpload(ctx context.Context, objectName, contentType string, maxSize int64, expiry time.Duration) (*domain.PresignedUpload, error)
+}
+
+type ImageRepository interface {
+	Create(ctx context.Context, img domain.Image) error
+	Get(ctx context.Context, id string) (*domain.Image, error)
+	List(ctx context.Context, filter domain.ImageFilter) ([]domain.Image, int, error)
+	History(ctx context.Context, id string) ([]domain.StatusChange, error)
+	// SetStatus moves an image to a new status and records the transition.
+	SetStatus(ctx context.Context, id string, status domain.ImageStatus, errMsg string) error
+	// SetExif records the EXIF metadata of an image unless it already has
+	// some, so that a retry on a stripped original does not overwrite it.
+	SetExif(ctx context.Context, id string, exif domain.ImageExif) error
+	Delete(ctx context.Context, id string) error
+}
+
+// UploadRepository keeps multipart and presigned uploads between the
+// request that starts them and the one that completes them.
+type UploadRepository interface {
+	Create(ctx context.Context, u domain.UploadSession) error
+	Get(ctx context.Context, id string) (*domain.UploadSession, error)
+	// Expired returns the IDs of uploads started before the given time.
+	Expired(ctx context.Context, before time.Time) ([]string, error)
+	Delete(ctx context.Context, id string) error
 }
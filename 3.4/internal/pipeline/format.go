@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/HugoSmits86/nativewebp"
+	_ "golang.org/x/image/webp"
+
+	"imageprocessor/internal/domain"
+)
+
+// Formats are the output formats in the order they are offered when a
+// client does not accept the stored one. There is no pure-Go AVIF encoder,
+// so AVIF is not among them.
+var Formats = []string{"webp", "jpeg", "png", "gif"}
+
+var contentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+}
+
+// NormalizeFormat checks an output format. The empty format keeps the
+// format of the original.
+func NormalizeFormat(format string) (string, error) {
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	if _, ok := contentTypes[format]; !ok && format != "" {
+		return "", fmt.Errorf("unknown format %q, expected jpeg, png, gif or webp", format)
+	}
+	return format, nil
+}
+
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// FormatOf returns the output format with the given content type, or ""
+// if there is none.
+func FormatOf(contentType string) string {
+	for format, ct := range contentTypes {
+		if ct == contentType {
+			return format
+		}
+	}
+	return ""
+}
+
+// OutputFormat picks the format to write: the requested one, else the
+// format of the original if it can be written, else JPEG.
+func OutputFormat(requested, original string) string {
+	if requested != "" {
+		return requested
+	}
+	if format, err := NormalizeFormat(original); err == nil && format != "" {
+		return format
+	}
+	return "jpeg"
+}
+
+// Encode writes img as format and returns its content type. quality is
+// used by JPEG only; JPEG is always baseline, as image/jpeg cannot write
+// progressive scans, WebP is always lossless and GIF is quantized to the
+// Plan 9 palette.
+func Encode(w io.Writer, img image.Image, format string, quality int) (string, error) {
+	if quality == 0 {
+		quality = DefaultQuality
+	}
+
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(w, img)
+	case "gif":
+		err = gif.Encode(w, img, &gif.Options{NumColors: 256, Drawer: draw.FloydSteinberg})
+	case "webp":
+		err = nativewebp.Encode(w, img, nil)
+	case "jpeg":
+		err = jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	default:
+		return "", fmt.Errorf("%w: unknown format %q", domain.ErrInvalidPipeline, format)
+	}
+	if err != nil {
+		return "", fmt.Errorf("encode %s: %w", format, err)
+	}
+	return contentTypes[format], nil
+}
@@ -0,0 +1,269 @@
+package pipeline
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"regexp"
+
+	"github.com/disintegration/imaging"
+
+	"imageprocessor/internal/domain"
+)
+
+const (
+	MaxVariants  = 10
+	MaxSteps     = 20
+	MaxDimension = 10000
+	MaxSigma     = 50
+)
+
+var variantName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+var gravities = map[string]imaging.Anchor{
+	"":             imaging.Center,
+	"center":       imaging.Center,
+	"top":          imaging.Top,
+	"bottom":       imaging.Bottom,
+	"left":         imaging.Left,
+	"right":        imaging.Right,
+	"top-left":     imaging.TopLeft,
+	"top-right":    imaging.TopRight,
+	"bottom-left":  imaging.BottomLeft,
+	"bottom-right": imaging.BottomRight,
+}
+
+// Validate checks a set of variants before it is accepted for processing,
+// so that a bad spec is rejected at upload instead of failing in the
+// worker. Format aliases such as jpg are rewritten in place.
+func Validate(variants []domain.Variant) error {
+	if len(variants) > MaxVariants {
+		return fmt.Errorf("%w: at most %d variants", domain.ErrInvalidPipeline, MaxVariants)
+	}
+
+	seen := map[string]bool{}
+	for i, v := range variants {
+		if !variantName.MatchString(v.Name) {
+			return fmt.Errorf("%w: variant name %q must be 1-32 lowercase letters, digits, '-' or '_'", domain.ErrInvalidPipeline, v.Name)
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("%w: duplicate variant %q", domain.ErrInvalidPipeline, v.Name)
+		}
+		seen[v.Name] = true
+
+		format, err := NormalizeFormat(v.Format)
+		if err != nil {
+			return fmt.Errorf("%w: variant %q: %v", domain.ErrInvalidPipeline, v.Name, err)
+		}
+		variants[i].Format = format
+		if v.Quality < 0 || v.Quality > 100 {
+			return fmt.Errorf("%w: variant %q: quality must be within 1..100", domain.ErrInvalidPipeline, v.Name)
+		}
+		if v.Progressive {
+			return fmt.Errorf("%w: variant %q: progressive output is not supported, images are written baseline", domain.ErrInvalidPipeline, v.Name)
+		}
+
+		if len(v.Steps) > MaxSteps {
+			return fmt.Errorf("%w: variant %q has more than %d steps", domain.ErrInvalidPipeline, v.Name, MaxSteps)
+		}
+		for i, step := range v.Steps {
+			if err := validateStep(step); err != nil {
+				return fmt.Errorf("%w: variant %q step %d: %v", domain.ErrInvalidPipeline, v.Name, i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateStep(s domain.Step) error {
+	switch s.Op {
+	case domain.OpResize:
+		if s.Width < 0 || s.Height < 0 || s.Width > MaxDimension || s.Height > MaxDimension {
+			return fmt.Errorf("width and height must be within 0..%d", MaxDimension)
+		}
+		if s.Width == 0 && s.Height == 0 {
+			return fmt.Errorf("width or height is required")
+		}
+		switch s.Fit {
+		case "", "fit", "stretch":
+		case "fill":
+			if s.Width == 0 || s.Height == 0 {
+				return fmt.Errorf("fill needs both width and height")
+			}
+		default:
+			return fmt.Errorf("unknown fit %q, expected fit, fill or stretch", s.Fit)
+		}
+		return validGravity(s.Gravity)
+	case domain.OpCrop:
+		if s.Width <= 0 || s.Height <= 0 || s.Width > MaxDimension || s.Height > MaxDimension {
+			return fmt.Errorf("width and height must be within 1..%d", MaxDimension)
+		}
+		return validGravity(s.Gravity)
+	case domain.OpRotate:
+		if math.IsNaN(s.Angle) || math.IsInf(s.Angle, 0) {
+			return fmt.Errorf("invalid angle")
+		}
+	case domain.OpFlip:
+		if s.Direction != "horizontal" && s.Direction != "vertical" {
+			return fmt.Errorf("direction must be horizontal or vertical")
+		}
+	case domain.OpBlur, domain.OpSharpen:
+		if s.Sigma <= 0 || s.Sigma > MaxSigma {
+			return fmt.Errorf("sigma must be within 0..%d", MaxSigma)
+		}
+	case domain.OpBrightness, domain.OpContrast:
+		if s.Amount < -100 || s.Amount > 100 {
+			return fmt.Errorf("amount must be within -100..100")
+		}
+	case domain.OpWatermark:
+		return validateWatermark(s)
+	case domain.OpGrayscale:
+	default:
+		return fmt.Errorf("unknown operation %q", s.Op)
+	}
+	return nil
+}
+
+// CheckVariants runs CheckSize on every variant of an image of the given
+// size.
+func CheckVariants(variants []domain.Variant, size image.Point, maxPixels int64) error {
+	for _, v := range variants {
+		if err := CheckSize(size, v.Steps, maxPixels); err != nil {
+			return fmt.Errorf("%w: variant %q: %v", domain.ErrInvalidPipeline, v.Name, err)
+		}
+	}
+	return nil
+}
+
+// CheckSize follows the dimensions of an image through steps and fails if
+// any step would produce more than maxPixels pixels, before anything is
+// allocated, or if a watermark margin leaves no room for the mark. The
+// EXIF orientation may swap the sides of the decoded image, so both ways
+// round are checked.
+func CheckSize(size image.Point, steps []domain.Step, maxPixels int64) error {
+	for _, cur := range []image.Point{size, {X: size.Y, Y: size.X}} {
+		for i, s := range steps {
+			if s.Op == domain.OpWatermark && (2*s.Margin >= cur.X || 2*s.Margin >= cur.Y) {
+				return fmt.Errorf("step %d: watermark margin %d leaves no room on a %dx%d image", i+1, s.Margin, cur.X, cur.Y)
+			}
+			cur = stepSize(cur, s)
+			if int64(cur.X)*int64(cur.Y) > maxPixels {
+				return fmt.Errorf("step %d would produce %dx%d, more than %d pixels", i+1, cur.X, cur.Y, maxPixels)
+			}
+		}
+	}
+	return nil
+}
+
+// stepSize is the size applyStep produces from an image of size src.
+func stepSize(src image.Point, s domain.Step) image.Point {
+	if src.X <= 0 || src.Y <= 0 {
+		return image.Point{}
+	}
+	switch s.Op {
+	case domain.OpResize:
+		w, h := s.Width, s.Height
+		switch {
+		case s.Fit == "fill":
+			return image.Pt(w, h)
+		case s.Fit == "stretch" || w == 0 || h == 0:
+			if w == 0 {
+				w = max(1, int(math.Floor(float64(h)*float64(src.X)/float64(src.Y)+0.5)))
+			}
+			if h == 0 {
+				h = max(1, int(math.Floor(float64(w)*float64(src.Y)/float64(src.X)+0.5)))
+			}
+			return image.Pt(w, h)
+		default:
+			if src.X <= w && src.Y <= h {
+				return src
+			}
+			aspect := float64(src.X) / float64(src.Y)
+			if aspect > float64(w)/float64(h) {
+				return image.Pt(w, max(1, int(float64(w)/aspect+0.5)))
+			}
+			return image.Pt(max(1, int(float64(h)*aspect+0.5)), h)
+		}
+	case domain.OpCrop:
+		return image.Pt(min(s.Width, src.X), min(s.Height, src.Y))
+	case domain.OpRotate:
+		switch math.Mod(math.Mod(s.Angle, 360)+360, 360) {
+		case 0, 180:
+			return src
+		case 90, 270:
+			return image.Pt(src.Y, src.X)
+		default:
+			// The bounding box of the rotated image, with a pixel of slack
+			// for rounding.
+			sin, cos := math.Sincos(s.Angle * math.Pi / 180)
+			w := math.Abs(float64(src.X)*cos) + math.Abs(float64(src.Y)*sin)
+			h := math.Abs(float64(src.X)*sin) + math.Abs(float64(src.Y)*cos)
+			return image.Pt(int(math.Ceil(w))+1, int(math.Ceil(h))+1)
+		}
+	}
+	return src
+}
+
+func validGravity(g string) error {
+	if _, ok := gravities[g]; !ok {
+		return fmt.Errorf("unknown gravity %q", g)
+	}
+	return nil
+}
+
+// Apply runs the steps of a validated variant on img. logos holds the
+// images named by its watermark steps.
+func Apply(img image.Image, steps []domain.Step, logos map[string]image.Image) image.Image {
+	for _, s := range steps {
+		img = applyStep(img, s, logos)
+	}
+	return img
+}
+
+func applyStep(img image.Image, s domain.Step, logos map[string]image.Image) image.Image {
+	switch s.Op {
+	case domain.OpResize:
+		switch {
+		case s.Fit == "fill":
+			return imaging.Fill(img, s.Width, s.Height, gravities[s.Gravity], imaging.Lanczos)
+		case s.Fit == "stretch" || s.Width == 0 || s.Height == 0:
+			return imaging.Resize(img, s.Width, s.Height, imaging.Lanczos)
+		default:
+			return imaging.Fit(img, s.Width, s.Height, imaging.Lanczos)
+		}
+	case domain.OpCrop:
+		return imaging.CropAnchor(img, s.Width, s.Height, gravities[s.Gravity])
+	case domain.OpRotate:
+		switch math.Mod(math.Mod(s.Angle, 360)+360, 360) {
+		case 0:
+			return img
+		case 90:
+			return imaging.Rotate90(img)
+		case 180:
+			return imaging.Rotate180(img)
+		case 270:
+			return imaging.Rotate270(img)
+		default:
+			return imaging.Rotate(img, s.Angle, color.Transparent)
+		}
+	case domain.OpFlip:
+		if s.Direction == "vertical" {
+			return imaging.FlipV(img)
+		}
+		return imaging.FlipH(img)
+	case domain.OpBlur:
+		return imaging.Blur(img, s.Sigma)
+	case domain.OpSharpen:
+		return imaging.Sharpen(img, s.Sigma)
+	case domain.OpGrayscale:
+		return imaging.Grayscale(img)
+	case domain.OpBrightness:
+		return imaging.AdjustBrightness(img, s.Amount)
+	case domain.OpContrast:
+		return imaging.AdjustContrast(img, s.Amount)
+	case domain.OpWatermark:
+		return watermark(img, s, logos)
+	}
+	return img
+}
@@ -0,0 +1,70 @@
+package pipeline
+
+import (
+	"errors"
+	"image"
+	"testing"
+
+	"imageprocessor/internal/domain"
+)
+
+func TestCheckSize(t *testing.T) {
+	const maxPixels = 50_000_000
+	for _, tc := range []struct {
+		name  string
+		size  image.Point
+		steps []domain.Step
+		ok    bool
+	}{
+		{"fit inside", image.Pt(4000, 3000), []domain.Step{{Op: domain.OpResize, Width: 800, Height: 600}}, true},
+		{"width only keeps a tall aspect", image.Pt(10, 10000), []domain.Step{{Op: domain.OpResize, Width: 10000}}, false},
+		{"width only", image.Pt(4000, 3000), []domain.Step{{Op: domain.OpResize, Width: 2000}}, true},
+		// The EXIF orientation may turn the image tall.
+		{"width only on a wide image", image.Pt(10000, 10), []domain.Step{{Op: domain.OpResize, Width: 10000}}, false},
+		{"stretch", image.Pt(100, 100), []domain.Step{{Op: domain.OpResize, Width: 10000, Height: 10000, Fit: "stretch"}}, false},
+		{"chained", image.Pt(100, 100), []domain.Step{
+			{Op: domain.OpResize, Width: 5000, Height: 5000, Fit: "fill"},
+			{Op: domain.OpRotate, Angle: 45},
+		}, false},
+		{"crop shrinks", image.Pt(9000, 9000), []domain.Step{{Op: domain.OpCrop, Width: 1000, Height: 1000}}, true},
+		{"watermark fits", image.Pt(400, 300), []domain.Step{{Op: domain.OpWatermark, Text: "x", Margin: 100}}, true},
+		{"watermark margin too wide", image.Pt(400, 300), []domain.Step{{Op: domain.OpWatermark, Text: "x", Margin: 150}}, false},
+		{"watermark after a shrink", image.Pt(4000, 3000), []domain.Step{
+			{Op: domain.OpResize, Width: 100, Height: 100},
+			{Op: domain.OpWatermark, Text: "x", Margin: 50},
+		}, false},
+	} {
+		err := CheckSize(tc.size, tc.steps, maxPixels)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: err = %v, want ok = %v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestCheckVariants(t *testing.T) {
+	variants := []domain.Variant{{Name: "tall", Steps: []domain.Step{{Op: domain.OpResize, Width: 10000}}}}
+	err := CheckVariants(variants, image.Pt(10, 10000), 50_000_000)
+	if !errors.Is(err, domain.ErrInvalidPipeline) {
+		t.Errorf("err = %v, want ErrInvalidPipeline", err)
+	}
+}
+
+func TestValidateRefusesProgressive(t *testing.T) {
+	variants := []domain.Variant{{Name: "web", Format: "jpg", Progressive: true}}
+	if err := Validate(variants); !errors.Is(err, domain.ErrInvalidPipeline) {
+		t.Errorf("err = %v, want ErrInvalidPipeline", err)
+	}
+}
+
+func TestMarkFitsImage(t *testing.T) {
+	if b := textMark("Hi", 400, 16).Bounds(); b.Dx() > 400 || b.Dy() > 16 {
+		t.Errorf("text mark is %dx%d, want within 400x16", b.Dx(), b.Dy())
+	}
+	logo := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	if b := logoMark(logo, 400, 16).Bounds(); b.Dx() != 16 || b.Dy() != 16 {
+		t.Errorf("logo mark is %dx%d, want 16x16", b.Dx(), b.Dy())
+	}
+	if b := logoMark(logo, 50, 400).Bounds(); b.Dx() != 50 || b.Dy() != 50 {
+		t.Errorf("logo mark is %dx%d, want 50x50", b.Dx(), b.Dy())
+	}
+}
@@ -0,0 +1,58 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"imageprocessor/internal/domain"
+)
+
+const DefaultQuality = 90
+
+// NormalizeTransform validates t and fills in the defaults, so that
+// requests producing the same image have the same canonical form.
+func NormalizeTransform(t domain.Transform) (domain.Transform, error) {
+	if t.Fit == "" {
+		t.Fit = "fit"
+	}
+	if err := validateStep(resizeStep(t)); err != nil {
+		return t, fmt.Errorf("%w: %v", domain.ErrInvalidTransform, err)
+	}
+
+	format, err := NormalizeFormat(t.Format)
+	if err != nil {
+		return t, fmt.Errorf("%w: %v", domain.ErrInvalidTransform, err)
+	}
+	t.Format = format
+
+	if t.Quality == 0 {
+		t.Quality = DefaultQuality
+	}
+	if t.Quality < 1 || t.Quality > 100 {
+		return t, fmt.Errorf("%w: quality must be within 1..100", domain.ErrInvalidTransform)
+	}
+	return t, nil
+}
+
+// Canonical is the string form of a normalized transform. It is what URL
+// signatures are computed over.
+func Canonical(t domain.Transform) string {
+	return fmt.Sprintf("w=%d&h=%d&fit=%s&format=%s&q=%d", t.Width, t.Height, t.Fit, t.Format, t.Quality)
+}
+
+// TransformKey names the cached result of a normalized transform in the
+// processed bucket. It doubles as the ETag of the response.
+func TransformKey(t domain.Transform) string {
+	sum := sha256.Sum256([]byte(Canonical(t)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// TransformSteps returns the pipeline that produces t.
+func TransformSteps(t domain.Transform) []domain.Step {
+	return []domain.Step{resizeStep(t)}
+}
+
+func resizeStep(t domain.Transform) domain.Step {
+	return domain.Step{Op: domain.OpResize, Width: t.Width, Height: t.Height, Fit: t.Fit}
+}
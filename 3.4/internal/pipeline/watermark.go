@@ -0,0 +1,242 @@
+package pipeline
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"imageprocessor/internal/domain"
+)
+
+const (
+	MaxWatermarkText   = 200
+	MaxWatermarkMargin = 1000
+)
+
+var watermarkFont = mustParseFont(goregular.TTF)
+
+func mustParseFont(ttf []byte) *opentype.Font {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		panic(fmt.Sprintf("parse watermark font: %v", err))
+	}
+	return f
+}
+
+// WithWatermarkDefaults returns a copy of variants with the watermark
+// parameters they leave out taken from cfg.
+func WithWatermarkDefaults(variants []domain.Variant, cfg domain.WatermarkCfg) []domain.Variant {
+	out := make([]domain.Variant, len(variants))
+	for i, v := range variants {
+		steps := make([]domain.Step, len(v.Steps))
+		for j, s := range v.Steps {
+			if s.Op == domain.OpWatermark {
+				if s.Text == "" && s.Logo == "" {
+					s.Text, s.Logo = cfg.Text, cfg.Logo
+				}
+				if s.Position == "" {
+					s.Position = cfg.Position
+				}
+				if s.Opacity == 0 {
+					s.Opacity = cfg.Opacity
+				}
+				if s.Scale == 0 {
+					s.Scale = cfg.Scale
+				}
+				if s.Margin == 0 {
+					s.Margin = cfg.Margin
+				}
+			}
+			steps[j] = s
+		}
+		v.Steps = steps
+		out[i] = v
+	}
+	return out
+}
+
+// Logos returns the names of the logo images the variants watermark with.
+func Logos(variants []domain.Variant) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, v := range variants {
+		for _, s := range v.Steps {
+			if s.Op == domain.OpWatermark && s.Logo != "" && !seen[s.Logo] {
+				seen[s.Logo] = true
+				names = append(names, s.Logo)
+			}
+		}
+	}
+	return names
+}
+
+// ValidLogoName checks the name a watermark logo is uploaded under.
+func ValidLogoName(name string) error {
+	if !variantName.MatchString(name) {
+		return fmt.Errorf("%w: logo name %q must be 1-32 lowercase letters, digits, '-' or '_'", domain.ErrInvalidPipeline, name)
+	}
+	return nil
+}
+
+func validateWatermark(s domain.Step) error {
+	switch {
+	case s.Text == "" && s.Logo == "":
+		return fmt.Errorf("text or logo is required")
+	case s.Text != "" && s.Logo != "":
+		return fmt.Errorf("text and logo cannot be combined")
+	case len(s.Text) > MaxWatermarkText:
+		return fmt.Errorf("text is longer than %d bytes", MaxWatermarkText)
+	case s.Logo != "" && !variantName.MatchString(s.Logo):
+		return fmt.Errorf("unknown logo %q", s.Logo)
+	case s.Opacity <= 0 || s.Opacity > 1:
+		return fmt.Errorf("opacity must be within 0..1")
+	case s.Scale <= 0 || s.Scale > 1:
+		return fmt.Errorf("scale must be within 0..1")
+	case s.Margin < 0 || s.Margin > MaxWatermarkMargin:
+		return fmt.Errorf("margin must be within 0..%d", MaxWatermarkMargin)
+	}
+	if s.Position == "tiled" {
+		return nil
+	}
+	return validGravity(s.Position)
+}
+
+func watermark(img image.Image, s domain.Step, logos map[string]image.Image) image.Image {
+	bounds := img.Bounds()
+	// The mark has to fit between the margins on both axes; CheckSize
+	// rejects pipelines that leave no room for it.
+	room := image.Pt(bounds.Dx()-2*s.Margin, bounds.Dy()-2*s.Margin)
+	if room.X <= 0 || room.Y <= 0 {
+		return img
+	}
+	width := min(room.X, max(1, int(float64(bounds.Dx())*s.Scale)))
+
+	var mark image.Image
+	if s.Logo != "" {
+		logo, ok := logos[s.Logo]
+		if !ok {
+			return img
+		}
+		mark = logoMark(logo, width, room.Y)
+	} else {
+		mark = textMark(s.Text, width, room.Y)
+	}
+	if mark == nil || mark.Bounds().Empty() {
+		return img
+	}
+
+	out := imaging.Clone(img)
+	size := mark.Bounds().Size()
+	mask := image.NewUniform(color.Alpha{A: uint8(s.Opacity * 255)})
+	put := func(at image.Point) {
+		r := image.Rectangle{Min: at, Max: at.Add(size)}
+		draw.DrawMask(out, r, mark, mark.Bounds().Min, mask, image.Point{}, draw.Over)
+	}
+
+	if s.Position == "tiled" {
+		for y := s.Margin; y < out.Bounds().Dy(); y += size.Y + s.Margin {
+			for x := s.Margin; x < out.Bounds().Dx(); x += size.X + s.Margin {
+				put(image.Pt(x, y))
+			}
+		}
+		return out
+	}
+	put(anchorPoint(out.Bounds(), size, s.Position, s.Margin))
+	return out
+}
+
+// anchorPoint places a mark of the given size at a gravity, margin pixels
+// away from the edges it is anchored to.
+func anchorPoint(bounds image.Rectangle, size image.Point, gravity string, margin int) image.Point {
+	x := bounds.Min.X + (bounds.Dx()-size.X)/2
+	y := bounds.Min.Y + (bounds.Dy()-size.Y)/2
+	if strings.Contains(gravity, "left") {
+		x = bounds.Min.X + margin
+	}
+	if strings.Contains(gravity, "right") {
+		x = bounds.Max.X - size.X - margin
+	}
+	if strings.Contains(gravity, "top") {
+		y = bounds.Min.Y + margin
+	}
+	if strings.Contains(gravity, "bottom") {
+		y = bounds.Max.Y - size.Y - margin
+	}
+	return image.Pt(x, y)
+}
+
+// logoMark scales logo to width pixels, or less if it would be taller
+// than maxHeight.
+func logoMark(logo image.Image, width, maxHeight int) image.Image {
+	lb := logo.Bounds()
+	if lb.Empty() {
+		return nil
+	}
+	w, h := width, int(float64(lb.Dy())*float64(width)/float64(lb.Dx())+0.5)
+	if h > maxHeight {
+		w, h = int(float64(lb.Dx())*float64(maxHeight)/float64(lb.Dy())+0.5), maxHeight
+	}
+	return imaging.Resize(logo, max(1, w), max(1, h), imaging.Lanczos)
+}
+
+// textMark renders text in white with a dark shadow, sized to be width
+// pixels wide, or smaller if it would be taller than maxHeight.
+func textMark(text string, width, maxHeight int) image.Image {
+	const refSize = 64
+
+	face, err := opentype.NewFace(watermarkFont, &opentype.FaceOptions{Size: refSize, DPI: 72})
+	if err != nil {
+		return nil
+	}
+	advance := font.MeasureString(face, text).Ceil()
+	if advance == 0 {
+		return nil
+	}
+	// The shadow adds a 24th of the line height.
+	refHeight := float64(face.Metrics().Ascent.Ceil()+face.Metrics().Descent.Ceil()) * 25 / 24
+	size := min(refSize*float64(width)/float64(advance), refSize*float64(maxHeight)/refHeight)
+
+	// Hinting and rounding to whole pixels can still make the mark a pixel
+	// or two too big, so it shrinks until it fits.
+	var ascent, height, shadow int
+	for {
+		face, err = opentype.NewFace(watermarkFont, &opentype.FaceOptions{
+			Size:    size,
+			DPI:     72,
+			Hinting: font.HintingFull,
+		})
+		if err != nil {
+			return nil
+		}
+		metrics := face.Metrics()
+		ascent = metrics.Ascent.Ceil()
+		height = ascent + metrics.Descent.Ceil()
+		shadow = max(1, height/24)
+		advance = font.MeasureString(face, text).Ceil()
+		if (advance+shadow <= width && height+shadow <= maxHeight) || size <= 1 {
+			break
+		}
+		size *= 0.9
+	}
+
+	mark := image.NewNRGBA(image.Rect(0, 0, advance+shadow, height+shadow))
+	d := font.Drawer{
+		Dst:  mark,
+		Src:  image.NewUniform(color.NRGBA{A: 160}),
+		Face: face,
+		Dot:  fixed.P(shadow, ascent+shadow),
+	}
+	d.DrawString(text)
+	d.Src = image.White
+	d.Dot = fixed.P(0, ascent)
+	d.DrawString(text)
+	return mark
+}
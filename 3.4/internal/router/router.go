@@ -13,6 +13,15 @@ func NewRouter(h *handler.ImageProcHandler) *mux.Router {
 
 	r.HandleFunc("/", h.Index)
 	r.HandleFunc("/upload", h.Upload).Methods("POST")
+	r.HandleFunc("/uploads", h.StartUpload).Methods("POST")
+	r.HandleFunc("/uploads/{id}", h.UploadStatus).Methods("GET")
+	r.HandleFunc("/uploads/{id}", h.AbortUpload).Methods("DELETE")
+	r.HandleFunc("/uploads/{id}/parts/{number:[0-9]+}", h.UploadPart).Methods("PUT")
+	r.HandleFunc("/uploads/{id}/complete", h.CompleteUpload).Methods("POST")
+	r.HandleFunc("/watermarks/{name}", h.UploadWatermark).Methods("POST")
+	r.HandleFunc("/images", h.ListImages).Methods("GET")
+	r.HandleFunc("/image/{id}/status", h.GetStatus).Methods("GET")
+	r.HandleFunc("/image/{id}/transform", h.Transform).Methods("GET")
 	r.HandleFunc("/image/{id}", h.GetImage).Methods("GET")
 	r.HandleFunc("/image/{id}", h.DeleteImage).Methods("DELETE")
 
@@ -0,0 +1,61 @@
+package urlsign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+
+	"imageprocessor/internal/domain"
+	"imageprocessor/internal/pipeline"
+)
+
+// Signer signs and checks /image/{id}/transform URLs with HMAC-SHA256, so
+// that only URLs handed out by a holder of the secret make the server
+// generate new images.
+type Signer struct {
+	secret []byte
+}
+
+func New(secret string) *Signer {
+	return &Signer{secret: []byte(secret)}
+}
+
+// Sign returns the signature of a normalized transform of image id.
+func (s *Signer) Sign(id string, t domain.Transform) string {
+	return base64.RawURLEncoding.EncodeToString(s.mac(id, t))
+}
+
+func (s *Signer) Verify(id string, t domain.Transform, signature string) error {
+	got, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(got, s.mac(id, t)) {
+		return domain.ErrInvalidSignature
+	}
+	return nil
+}
+
+// URL returns the signed path of a transform of image id.
+func (s *Signer) URL(id string, t domain.Transform) (string, error) {
+	t, err := pipeline.NormalizeTransform(t)
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{}
+	q.Set("w", fmt.Sprint(t.Width))
+	q.Set("h", fmt.Sprint(t.Height))
+	q.Set("fit", t.Fit)
+	if t.Format != "" {
+		q.Set("format", t.Format)
+	}
+	q.Set("q", fmt.Sprint(t.Quality))
+	q.Set("s", s.Sign(id, t))
+	return fmt.Sprintf("/image/%s/transform?%s", url.PathEscape(id), q.Encode()), nil
+}
+
+func (s *Signer) mac(id string, t domain.Transform) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(id + "?" + pipeline.Canonical(t)))
+	return mac.Sum(nil)
+}
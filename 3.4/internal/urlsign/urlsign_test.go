@@ -0,0 +1,142 @@
+package urlsign
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"imageprocessor/internal/domain"
+	"imageprocessor/internal/pipeline"
+)
+
+// parse reads a signed URL back the way the transform handler does.
+func parse(t *testing.T, raw string) (string, domain.Transform, string) {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(u.EscapedPath(), "/image/"), "/transform"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := u.Query()
+	atoi := func(s string) int {
+		if s == "" {
+			return 0
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			t.Fatalf("%s: %v", raw, err)
+		}
+		return n
+	}
+	tr := domain.Transform{
+		Width:   atoi(query.Get("w")),
+		Height:  atoi(query.Get("h")),
+		Fit:     query.Get("fit"),
+		Format:  query.Get("format"),
+		Quality: atoi(query.Get("q")),
+	}
+	tr, err = pipeline.NormalizeTransform(tr)
+	if err != nil {
+		t.Fatalf("%s: %v", raw, err)
+	}
+	return id, tr, query.Get("s")
+}
+
+func TestRoundTrip(t *testing.T) {
+	s := New("secret")
+	for _, tr := range []domain.Transform{
+		{Width: 200},
+		{Width: 200, Height: 100, Fit: "fill", Format: "webp", Quality: 60},
+		{Height: 50, Format: "jpg"},
+	} {
+		raw, err := s.URL("a b/c", tr)
+		if err != nil {
+			t.Fatalf("%+v: %v", tr, err)
+		}
+		id, got, sig := parse(t, raw)
+		if id != "a b/c" {
+			t.Errorf("%s: id = %q", raw, id)
+		}
+		if err := s.Verify(id, got, sig); err != nil {
+			t.Errorf("%s: %v", raw, err)
+		}
+	}
+}
+
+func TestTamperedURL(t *testing.T) {
+	s := New("secret")
+	raw, err := s.URL("img", domain.Transform{Width: 200, Height: 100, Fit: "fill", Format: "png", Quality: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		param string
+		value string
+	}{
+		{"width", "w", "201"},
+		{"height", "h", "99"},
+		{"fit", "fit", "fit"},
+		{"format", "format", "webp"},
+		{"no format", "format", ""},
+		{"quality", "q", "81"},
+		{"signature", "s", "AAAA"},
+	} {
+		u, _ := url.Parse(raw)
+		query := u.Query()
+		query.Set(tc.param, tc.value)
+		u.RawQuery = query.Encode()
+
+		id, tr, sig := parse(t, u.String())
+		if err := s.Verify(id, tr, sig); !errors.Is(err, domain.ErrInvalidSignature) {
+			t.Errorf("%s: err = %v, want %v", tc.name, err, domain.ErrInvalidSignature)
+		}
+	}
+
+	id, tr, sig := parse(t, raw)
+	if err := s.Verify(id+"x", tr, sig); !errors.Is(err, domain.ErrInvalidSignature) {
+		t.Errorf("other id: err = %v, want %v", err, domain.ErrInvalidSignature)
+	}
+	if err := New("other").Verify(id, tr, sig); !errors.Is(err, domain.ErrInvalidSignature) {
+		t.Errorf("other secret: err = %v, want %v", err, domain.ErrInvalidSignature)
+	}
+}
+
+// jpg is an alias of jpeg, so both spellings must name the same image: one
+// signature and one cached result.
+func TestJPGAlias(t *testing.T) {
+	s := New("secret")
+	jpg, err := s.URL("img", domain.Transform{Width: 100, Format: "jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpeg, err := s.URL("img", domain.Transform{Width: 100, Format: "jpeg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jpg != jpeg {
+		t.Errorf("URL differs by spelling: %s and %s", jpg, jpeg)
+	}
+
+	// A signed jpeg URL that a client rewrote to jpg still verifies.
+	u, _ := url.Parse(jpeg)
+	query := u.Query()
+	query.Set("format", "jpg")
+	u.RawQuery = query.Encode()
+
+	id, a, sig := parse(t, jpeg)
+	_, b, _ := parse(t, u.String())
+	if err := s.Verify(id, b, sig); err != nil {
+		t.Errorf("format=jpg: %v", err)
+	}
+	if pipeline.TransformKey(a) != pipeline.TransformKey(b) {
+		t.Errorf("cache key differs: %s and %s", pipeline.TransformKey(a), pipeline.TransformKey(b))
+	}
+}
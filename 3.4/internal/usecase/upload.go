@@ -0,0 +1,299 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+
+	"imageprocessor/internal/domain"
+	"imageprocessor/internal/imagecheck"
+	"imageprocessor/internal/pipeline"
+)
+
+// maxParts is the S3 limit on the parts of a multipart upload.
+const maxParts = 10000
+
+// StartUpload starts a multipart or presigned upload. The image is only
+// registered and queued for processing by CompleteUpload.
+func (s *imageProcService) StartUpload(ctx context.Context, req domain.UploadRequest) (*domain.UploadSession, error) {
+	name, err := objectName(req.FileName)
+	if err != nil {
+		return nil, err
+	}
+	variants, err := s.prepareVariants(ctx, req.Variants)
+	if err != nil {
+		return nil, err
+	}
+	if req.ContentType == "" {
+		req.ContentType = "application/octet-stream"
+	}
+
+	u := domain.UploadSession{
+		ID:          uuid.New().String(),
+		ImageID:     name,
+		Kind:        req.Kind,
+		FileName:    path.Base(req.FileName),
+		ContentType: req.ContentType,
+		Variants:    variants,
+	}
+	switch req.Kind {
+	case domain.UploadMultipart:
+		if u.MultipartID, err = s.minioService.StartMultipart(ctx, name, req.ContentType); err != nil {
+			return nil, err
+		}
+	case domain.UploadPresigned:
+		u.Presigned, err = s.minioService.PresignUpload(ctx, name, req.ContentType, s.upload.MaxSize, s.upload.PresignExpiry)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: kind must be multipart or presigned", domain.ErrInvalidUpload)
+	}
+
+	if err := s.uploads.Create(ctx, u); err != nil {
+		if u.Kind == domain.UploadMultipart {
+			if err := s.minioService.AbortMultipart(ctx, name, u.MultipartID); err != nil {
+				log.Printf("error abort upload %s: %v", name, err)
+			}
+		}
+		return nil, err
+	}
+	return &u, nil
+}
+
+// UploadPart stores one part of a multipart upload. Sending a part again
+// replaces it, so an interrupted upload resumes with the parts missing from
+// UploadStatus.
+func (s *imageProcService) UploadPart(ctx context.Context, id string, number int, r io.Reader, size int64) (domain.UploadPart, error) {
+	u, err := s.multipart(ctx, id)
+	if err != nil {
+		return domain.UploadPart{}, err
+	}
+	if number < 1 || number > maxParts {
+		return domain.UploadPart{}, fmt.Errorf("%w: part number must be within 1..%d", domain.ErrInvalidUpload, maxParts)
+	}
+	// Parts sent at the same time can still pass this together, which
+	// CompleteUpload catches; what it stops is storing far more than a
+	// whole file may be.
+	parts, err := s.minioService.ListParts(ctx, u.ImageID, u.MultipartID)
+	if err != nil {
+		return domain.UploadPart{}, err
+	}
+	total := size
+	for _, p := range parts {
+		if p.Number != number {
+			total += p.Size
+		}
+	}
+	if total > s.upload.MaxSize {
+		return domain.UploadPart{}, domain.ErrTooLarge
+	}
+	// The first part starts with the image header, so a file that would
+	// be rejected on completion is rejected before the rest is sent.
+	if number == 1 {
+		if _, r, err = imagecheck.Check(r, s.upload); err != nil {
+			return domain.UploadPart{}, err
+		}
+	}
+	return s.minioService.PutPart(ctx, u.ImageID, u.MultipartID, number, r, size)
+}
+
+// UploadStatus returns an upload with the parts that have arrived.
+func (s *imageProcService) UploadStatus(ctx context.Context, id string) (*domain.UploadSession, error) {
+	u, err := s.session(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if u.Kind == domain.UploadMultipart {
+		if u.Parts, err = s.minioService.ListParts(ctx, u.ImageID, u.MultipartID); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// CompleteUpload assembles a multipart upload or checks that a presigned
+// one has arrived, then registers the image like Upload does and returns
+// its ID and status.
+func (s *imageProcService) CompleteUpload(ctx context.Context, id string) (string, domain.ImageStatus, error) {
+	u, err := s.session(ctx, id)
+	if err != nil {
+		return "", "", err
+	}
+
+	var size int64
+	switch u.Kind {
+	case domain.UploadMultipart:
+		parts, err := s.minioService.ListParts(ctx, u.ImageID, u.MultipartID)
+		if err != nil {
+			return "", "", err
+		}
+		if len(parts) == 0 {
+			return "", "", fmt.Errorf("%w: no parts uploaded", domain.ErrInvalidUpload)
+		}
+		var total int64
+		for _, p := range parts {
+			total += p.Size
+		}
+		if total > s.upload.MaxSize {
+			if err := s.AbortUpload(ctx, id); err != nil {
+				log.Printf("error abort upload %s: %v", id, err)
+			}
+			return "", "", domain.ErrTooLarge
+		}
+		if size, err = s.minioService.CompleteMultipart(ctx, u.ImageID, u.MultipartID, parts); err != nil {
+			return "", "", err
+		}
+	case domain.UploadPresigned:
+		size, err = s.minioService.Stat(ctx, u.ImageID)
+		if errors.Is(err, domain.ErrImageNotFound) {
+			return "", "", fmt.Errorf("%w: file has not been uploaded", domain.ErrInvalidUpload)
+		}
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	info, err := s.checkOriginal(ctx, u.ImageID)
+	if err == nil {
+		err = pipeline.CheckVariants(u.Variants, image.Pt(info.Width, info.Height), s.upload.MaxPixels)
+	}
+	if errors.Is(err, domain.ErrUnsupportedFormat) || errors.Is(err, domain.ErrInvalidImage) ||
+		errors.Is(err, domain.ErrInvalidPipeline) {
+		if err := s.minioService.Delete(u.ImageID); err != nil {
+			log.Printf("error delete rejected upload %s: %v", u.ImageID, err)
+		}
+		if err := s.uploads.Delete(ctx, id); err != nil {
+			log.Printf("error delete upload %s: %v", id, err)
+		}
+		return "", "", err
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	status, err := s.register(ctx, newImage(u.ImageID, u.FileName, size, info, u.Variants))
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.uploads.Delete(ctx, id); err != nil {
+		log.Printf("error delete upload %s: %v", id, err)
+	}
+	return u.ImageID, status, nil
+}
+
+// AbortUpload drops an upload and whatever has been stored for it.
+func (s *imageProcService) AbortUpload(ctx context.Context, id string) error {
+	u, err := s.uploads.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	switch u.Kind {
+	case domain.UploadMultipart:
+		err = s.minioService.AbortMultipart(ctx, u.ImageID, u.MultipartID)
+		if errors.Is(err, domain.ErrUploadNotFound) {
+			err = nil
+		}
+	case domain.UploadPresigned:
+		err = s.minioService.Delete(u.ImageID)
+	}
+	if err != nil {
+		return err
+	}
+	return s.uploads.Delete(ctx, id)
+}
+
+// ExpireUploads aborts the uploads started longer than the configured
+// expiry ago, with the parts or object stored for them.
+func (s *imageProcService) ExpireUploads(ctx context.Context) error {
+	ids, err := s.uploads.Expired(ctx, time.Now().Add(-s.upload.Expiry))
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		// Another instance may have swept or completed it meanwhile.
+		if err := s.AbortUpload(ctx, id); err != nil && !errors.Is(err, domain.ErrUploadNotFound) {
+			log.Printf("error abort expired upload %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// checkOriginal runs the upload checks on an original that reached
+// storage without passing through Upload.
+func (s *imageProcService) checkOriginal(ctx context.Context, name string) (imagecheck.Info, error) {
+	obj, err := s.minioService.OpenOriginal(ctx, name)
+	if err != nil {
+		return imagecheck.Info{}, err
+	}
+	defer func() {
+		if err := obj.Close(); err != nil {
+			log.Printf("error close original %s: %v", name, err)
+		}
+	}()
+
+	info, _, err := imagecheck.Check(obj, s.upload)
+	return info, err
+}
+
+// session returns an upload that has not expired yet; expired ones are
+// left to ExpireUploads.
+func (s *imageProcService) session(ctx context.Context, id string) (*domain.UploadSession, error) {
+	u, err := s.uploads.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(u.CreatedAt) > s.upload.Expiry {
+		return nil, domain.ErrUploadNotFound
+	}
+	return u, nil
+}
+
+func (s *imageProcService) multipart(ctx context.Context, id string) (*domain.UploadSession, error) {
+	u, err := s.session(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if u.Kind != domain.UploadMultipart {
+		return nil, fmt.Errorf("%w: not a multipart upload", domain.ErrInvalidUpload)
+	}
+	return u, nil
+}
+
+// objectName is the ID an uploaded file is stored and known under.
+func objectName(fileName string) (string, error) {
+	fileName = path.Base(fileName)
+	if fileName == "." || fileName == "/" {
+		return "", fmt.Errorf("%w: file name is required", domain.ErrInvalidUpload)
+	}
+	return fmt.Sprintf("%s_%s", uuid.New().String(), fileName), nil
+}
+
+// limitedReader fails with domain.ErrTooLarge once more than n bytes have
+// been read from r.
+type limitedReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		l.exceeded = true
+		return n, domain.ErrTooLarge
+	}
+	return n, err
+}
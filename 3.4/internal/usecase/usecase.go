@@ -1,51 +1,341 @@
 package usecase
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
 	"log"
+	"slices"
+	"strings"
 
 	"imageprocessor/internal/domain"
+	"imageprocessor/internal/imagecheck"
 	"imageprocessor/internal/interfaces"
+	"imageprocessor/internal/pipeline"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
 )
 
 type imageProcService struct {
 	minioService   interfaces.MinioRepository
+	images         interfaces.ImageRepository
+	uploads        interfaces.UploadRepository
 	eventPublisher interfaces.EventPublisher
+	// topic is where tasks are queued for the workers.
+	topic     string
+	watermark domain.WatermarkCfg
+	upload    domain.UploadCfg
 }
 
-func NewImageProcService(m interfaces.MinioRepository, e interfaces.EventPublisher) ImageProcService {
+func NewImageProcService(m interfaces.MinioRepository, images interfaces.ImageRepository, uploads interfaces.UploadRepository,
+	e interfaces.EventPublisher, topic string, watermark domain.WatermarkCfg, upload domain.UploadCfg) ImageProcService {
 	return &imageProcService{
 		minioService:   m,
+		images:         images,
+		uploads:        uploads,
 		eventPublisher: e,
+		topic:          topic,
+		watermark:      watermark,
+		upload:         upload,
 	}
 }
 
-func (s *imageProcService) Upload(file domain.FileDataType, options ...domain.ImageProcessOption) (string, error) {
-	name, err := s.minioService.Create(file)
+// Upload checks the format and dimensions of file from its first bytes,
+// then streams it into storage and queues it for processing. It returns
+// the image ID and status. Files over the configured size fail with
+// domain.ErrTooLarge.
+func (s *imageProcService) Upload(ctx context.Context, file domain.FileStream, variants []domain.Variant) (string, domain.ImageStatus, error) {
+	name, err := objectName(file.FileName)
+	if err != nil {
+		return "", "", err
+	}
+	variants, err = s.prepareVariants(ctx, variants)
+	if err != nil {
+		return "", "", err
+	}
+	if file.Size > s.upload.MaxSize {
+		return "", "", domain.ErrTooLarge
+	}
+
+	limited := &limitedReader{r: file.Reader, n: s.upload.MaxSize}
+	info, body, err := imagecheck.Check(limited, s.upload)
+	if limited.exceeded {
+		return "", "", domain.ErrTooLarge
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if err := pipeline.CheckVariants(variants, image.Pt(info.Width, info.Height), s.upload.MaxPixels); err != nil {
+		return "", "", err
+	}
+
+	file.Reader = body
+	file.ContentType = info.ContentType
+	size, err := s.minioService.Create(ctx, name, file)
+	if limited.exceeded {
+		return "", "", domain.ErrTooLarge
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	status, err := s.register(ctx, newImage(name, file.FileName, size, info, variants))
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
+	return name, status, nil
+}
+
+// prepareVariants fills in the watermark defaults and checks the variants
+// before anything is stored.
+func (s *imageProcService) prepareVariants(ctx context.Context, variants []domain.Variant) ([]domain.Variant, error) {
+	variants = pipeline.WithWatermarkDefaults(variants, s.watermark)
+	if err := pipeline.Validate(variants); err != nil {
+		return nil, err
+	}
+	for _, logo := range pipeline.Logos(variants) {
+		ok, err := s.minioService.HasWatermark(ctx, logo)
+		if err != nil {
+			return nil, fmt.Errorf("check watermark logo: %w", err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: no watermark logo %q", domain.ErrInvalidPipeline, logo)
+		}
+	}
+	return variants, nil
+}
 
+func newImage(name, fileName string, size int64, info imagecheck.Info, variants []domain.Variant) domain.Image {
+	return domain.Image{
+		ID:          name,
+		FileName:    fileName,
+		ContentType: info.ContentType,
+		Size:        size,
+		Width:       info.Width,
+		Height:      info.Height,
+		Format:      info.Format,
+		Variants:    variants,
+		Status:      domain.StatusPending,
+	}
+}
+
+// enqueueFailed starts the error of an image whose task never reached
+// Kafka. Status queues such images again.
+const enqueueFailed = "enqueue task: "
+
+// register records a stored original, queues it for processing and
+// returns the status it ends up in: pending, or failed if the task could
+// not be queued.
+func (s *imageProcService) register(ctx context.Context, img domain.Image) (domain.ImageStatus, error) {
+	if err := s.images.Create(ctx, img); err != nil {
+		return "", fmt.Errorf("save image metadata: %w", err)
+	}
+
+	if err := s.enqueue(img); err != nil {
+		log.Printf("Kafka produce failed: %v", err)
+		if err := s.images.SetStatus(ctx, img.ID, domain.StatusFailed, enqueueFailed+err.Error()); err != nil {
+			log.Printf("error set status of %s: %v", img.ID, err)
+		}
+		return domain.StatusFailed, nil
+	}
+	return domain.StatusPending, nil
+}
+
+func (s *imageProcService) enqueue(img domain.Image) error {
 	task := domain.ImageTask{
-		ID:      name,
-		Options: options,
+		ID:       img.ID,
+		Variants: img.Variants,
 	}
 	data, _ := json.Marshal(task)
+	return s.eventPublisher.Produce(s.topic, img.ID, data)
+}
 
-	if err := s.eventPublisher.Produce("image-tasks", name, data); err != nil {
-		log.Printf("Kafka produce failed: %v", err)
+// Get returns a processed variant. accept lists the formats the client
+// takes, preferred first; a variant stored in another format is converted
+// to the first of them. A nil accept takes the stored format.
+func (s *imageProcService) Get(ctx context.Context, id, variant string, accept []string) ([]byte, string, error) {
+	data, contentType, err := s.minioService.Get(id, variant)
+	if err != nil || accept == nil || slices.Contains(accept, pipeline.FormatOf(contentType)) {
+		return data, contentType, err
+	}
+	if len(accept) == 0 {
+		return nil, "", domain.ErrNotAcceptable
+	}
+
+	key := variant + "." + accept[0]
+	data, contentType, err = s.minioService.Transformed(ctx, id, key)
+	if err == nil {
+		return data, contentType, nil
+	}
+	if !errors.Is(err, domain.ErrImageNotFound) {
+		log.Printf("error read cached conversion %s of %s: %v", key, id, err)
+	}
+
+	return s.minioService.Convert(ctx, id, variant, key, accept[0])
+}
+
+// UploadWatermark stores a logo that watermark steps can use by name.
+func (s *imageProcService) UploadWatermark(ctx context.Context, name string, file domain.FileDataType) error {
+	if err := pipeline.ValidLogoName(name); err != nil {
+		return err
+	}
+	info, _, err := imagecheck.Check(bytes.NewReader(file.Data), s.upload)
+	if err != nil {
+		return err
+	}
+	file.ContentType = info.ContentType
+	return s.minioService.PutWatermark(ctx, name, file)
+}
+
+// Transform serves a resize of the original, generating it on first use
+// and afterwards from the copy kept in the processed bucket.
+func (s *imageProcService) Transform(ctx context.Context, id string, t domain.Transform) ([]byte, string, error) {
+	t, err := pipeline.NormalizeTransform(t)
+	if err != nil {
+		return nil, "", err
+	}
+	key := pipeline.TransformKey(t)
+
+	data, contentType, err := s.minioService.Transformed(ctx, id, key)
+	if err == nil {
+		return data, contentType, nil
+	}
+	if !errors.Is(err, domain.ErrImageNotFound) {
+		log.Printf("error read cached transform %s of %s: %v", key, id, err)
+	}
+
+	// Images uploaded before metadata was kept have no record and are
+	// transformed unchecked, as before.
+	img, err := s.images.Get(ctx, id)
+	if err != nil && !errors.Is(err, domain.ErrImageNotFound) {
+		return nil, "", err
+	}
+	if err == nil {
+		err := pipeline.CheckSize(image.Pt(img.Width, img.Height), pipeline.TransformSteps(t), s.upload.MaxPixels)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", domain.ErrInvalidTransform, err)
+		}
 	}
 
-	return name, nil
+	return s.minioService.Transform(ctx, id, key, t)
 }
 
-func (s *imageProcService) Get(id, variant string) ([]byte, string, error) {
-	return s.minioService.Get(id, variant)
+// Status returns an image with its status history. An image whose task
+// could not be queued is queued again first.
+func (s *imageProcService) Status(ctx context.Context, id string) (*domain.ImageStatusInfo, error) {
+	img, err := s.images.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if img.Status == domain.StatusFailed && strings.HasPrefix(img.Error, enqueueFailed) {
+		if err := s.enqueue(*img); err != nil {
+			log.Printf("error enqueue %s again: %v", id, err)
+		} else {
+			if err := s.images.SetStatus(ctx, id, domain.StatusPending, ""); err != nil {
+				return nil, err
+			}
+			img.Status, img.Error = domain.StatusPending, ""
+		}
+	}
+	history, err := s.images.History(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.ImageStatusInfo{Image: *img, History: history}, nil
 }
 
-func (s *imageProcService) DeleteImage(id string) error {
-	err := s.minioService.Delete(id)
+func (s *imageProcService) List(ctx context.Context, filter domain.ImageFilter) (*domain.ImagePage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultPageSize
+	}
+	if filter.Limit > maxPageSize {
+		filter.Limit = maxPageSize
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	images, total, err := s.images.List(ctx, filter)
 	if err != nil {
+		return nil, err
+	}
+	return &domain.ImagePage{Images: images, Total: total, Limit: filter.Limit, Offset: filter.Offset}, nil
+}
+
+// Process runs a task from Kafka and records every step in the image
+// status: processing when the worker picks it up, then processed, or
+// retrying or failed with the error depending on whether another attempt
+// follows. The EXIF metadata of the original is saved with the image.
+func (s *imageProcService) Process(ctx context.Context, task domain.ImageTask, attempt int, last bool) error {
+	if err := s.images.SetStatus(ctx, task.ID, domain.StatusProcessing, ""); err != nil {
+		if errors.Is(err, domain.ErrImageNotFound) {
+			log.Printf("skip task %s: image was deleted", task.ID)
+			return nil
+		}
+		return err
+	}
+
+	exif, err := s.minioService.ProcessImage(ctx, task.ID, task.Variants)
+	if err != nil {
+		// Without its original the image can never be processed.
+		if errors.Is(err, domain.ErrImageNotFound) {
+			err = fmt.Errorf("%w: %v", domain.ErrPoisonMessage, err)
+		}
+		status := domain.StatusRetrying
+		if last || errors.Is(err, domain.ErrPoisonMessage) {
+			status = domain.StatusFailed
+		}
+		if serr := s.images.SetStatus(ctx, task.ID, status, fmt.Sprintf("attempt %d: %v", attempt, err)); serr != nil {
+			log.Printf("error set status of %s: %v", task.ID, serr)
+		}
+		return err
+	}
+
+	if exif != nil {
+		if err := s.images.SetExif(ctx, task.ID, *exif); err != nil {
+			log.Printf("error save exif of %s: %v", task.ID, err)
+		}
+	}
+	// The variants are done, so a failure here leaves the original as
+	// uploaded rather than failing the image.
+	if s.upload.StripExif {
+		if err := s.minioService.StripOriginal(ctx, task.ID); err != nil {
+			log.Printf("error strip exif of %s: %v", task.ID, err)
+		}
+	}
+
+	return s.images.SetStatus(ctx, task.ID, domain.StatusProcessed, "")
+}
+
+func (s *imageProcService) DeleteImage(ctx context.Context, id string) error {
+	// Images uploaded before metadata was kept have no record; they can
+	// only have the preset variants.
+	var variants []string
+	img, err := s.images.Get(ctx, id)
+	switch {
+	case err == nil:
+		for _, v := range img.Variants {
+			variants = append(variants, v.Name)
+		}
+	case errors.Is(err, domain.ErrImageNotFound):
+		for name := range domain.Presets {
+			variants = append(variants, name)
+		}
+	default:
+		return err
+	}
+
+	if err := s.minioService.Delete(id, variants...); err != nil {
+		return err
+	}
+
+	if err := s.images.Delete(ctx, id); err != nil && !errors.Is(err, domain.ErrImageNotFound) {
 		return err
 	}
 
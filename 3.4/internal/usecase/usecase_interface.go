@@ -1,11 +1,25 @@
 package usecase
 
 import (
+	"context"
+	"io"
+
 	"imageprocessor/internal/domain"
 )
 
 type ImageProcService interface {
-	Upload(file domain.FileDataType, options ...domain.ImageProcessOption) (string, error)
-	Get(id, variant string) ([]byte, string, error)
-	DeleteImage(id string) error
+	Upload(ctx context.Context, file domain.FileStream, variants []domain.Variant) (string, domain.ImageStatus, error)
+	StartUpload(ctx context.Context, req domain.UploadRequest) (*domain.UploadSession, error)
+	UploadPart(ctx context.Context, id string, number int, r io.Reader, size int64) (domain.UploadPart, error)
+	UploadStatus(ctx context.Context, id string) (*domain.UploadSession, error)
+	CompleteUpload(ctx context.Context, id string) (string, domain.ImageStatus, error)
+	AbortUpload(ctx context.Context, id string) error
+	ExpireUploads(ctx context.Context) error
+	UploadWatermark(ctx context.Context, name string, file domain.FileDataType) error
+	Get(ctx context.Context, id, variant string, accept []string) ([]byte, string, error)
+	Transform(ctx context.Context, id string, t domain.Transform) ([]byte, string, error)
+	Status(ctx context.Context, id string) (*domain.ImageStatusInfo, error)
+	List(ctx context.Context, filter domain.ImageFilter) (*domain.ImagePage, error)
+	Process(ctx context.Context, task domain.ImageTask, attempt int, last bool) error
+	DeleteImage(ctx context.Context, id string) error
 }
@@ -0,0 +1,56 @@
+CREATE TABLE IF NOT EXISTS images (
+    image_id TEXT PRIMARY KEY,
+    file_name TEXT NOT NULL,
+    content_type TEXT NOT NULL DEFAULT '',
+    size BIGINT NOT NULL DEFAULT 0,
+    width INT NOT NULL DEFAULT 0,
+    height INT NOT NULL DEFAULT 0,
+    format TEXT NOT NULL DEFAULT '',
+    -- Variant pipelines requested at upload, as sent in the Kafka task.
+    variants JSONB NOT NULL DEFAULT '[]',
+    -- Camera, capture time and GPS read from the original; NULL if it had none.
+    exif JSONB,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'processing', 'retrying', 'processed', 'failed')),
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS image_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    image_id TEXT NOT NULL REFERENCES images (image_id) ON DELETE CASCADE,
+    status TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_images_created ON images (created_at DESC, image_id);
+CREATE INDEX IF NOT EXISTS idx_images_status ON images (status, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_image_status_history ON image_status_history (image_id, id);
+
+-- Multipart and presigned uploads that have been started but not completed.
+CREATE TABLE IF NOT EXISTS uploads (
+    upload_id TEXT PRIMARY KEY,
+    image_id TEXT NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('multipart', 'presigned')),
+    multipart_id TEXT NOT NULL DEFAULT '',
+    file_name TEXT NOT NULL,
+    content_type TEXT NOT NULL DEFAULT '',
+    variants JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_uploads_created ON uploads (created_at);
+
+-- Tables created by an earlier version are not recreated above, so what
+-- has changed in them since is applied here.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'images' AND column_name = 'options') THEN
+        ALTER TABLE images RENAME COLUMN options TO variants;
+    END IF;
+END $$;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS exif JSONB;
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_status_check;
+ALTER TABLE images ADD CONSTRAINT images_status_check
+    CHECK (status IN ('pending', 'processing', 'retrying', 'processed', 'failed'));
@@ -0,0 +1,9 @@
+// Package storage holds the database schema. The same file initialises a
+// new database through docker-entrypoint-initdb.d and is applied again by
+// the service on start, so every statement in it can run more than once.
+package storage
+
+import _ "embed"
+
+//go:embed model.sql
+var Schema string